@@ -0,0 +1,72 @@
+package main
+
+import (
+	"time"
+
+	"github.com/ctferio/scenarios/scenarioconfig"
+	"github.com/ctferio/scenarios/scenarioconfig/conninfo"
+)
+
+// connection_info：Go text/template，資料模型與輔助函式見 scenarioconfig/conninfo
+//
+// additional keys：
+//
+//	connection_info   模板（預設 "nc {ip} {port}"；舊 {ip} {port} 佔位符仍可用）
+//	ports             額外的具名 port，"name=port" 逗號分隔（如 "web=80,debug=9000"），
+//	                  每個都建立 container port 與 NodePort，模板以 {{.Ports.web}} 取得 NodePort
+//	credentials_user  產生 per-instance 帳密：container 取得 CTF_USERNAME / CTF_PASSWORD，
+//	                  模板以 {{.Credentials.Username}} {{.Credentials.Password}} 取得
+//
+// k8s-pod 特有欄位：{{.Extra.namespace}}、{{.Extra.pod}}
+//
+// 模板在建立任何資源前以範例資料試渲染，引用不存在的欄位 / port 直接讓 deployment 失敗。
+
+// connectionConfig 由 additional 解析出的 connection_info 設定
+type connectionConfig struct {
+	Template    *conninfo.Template
+	Ports       []conninfo.NamedPort
+	Credentials conninfo.Credentials
+	ExpiresAt   time.Time
+}
+
+func parseConnectionConfig(conf *scenarioconfig.Values, identity string, now time.Time) (connectionConfig, error) {
+	cfg := connectionConfig{
+		Credentials: conninfo.NewCredentials(conf.String("credentials_user"), identity, conf.String("base_flag")),
+	}
+	if secs := conf.Int("instance_timeout"); secs > 0 {
+		cfg.ExpiresAt = now.Add(time.Duration(secs) * time.Second)
+	}
+	var err error
+	// 格式已由 schema 驗證
+	if cfg.Ports, err = conninfo.ParsePorts(conf.String("ports")); err != nil {
+		return cfg, err
+	}
+	if cfg.Template, err = conninfo.Parse(conf.String("connection_info")); err != nil {
+		return cfg, err
+	}
+	sample := map[string]int{conninfo.PrimaryPort: conf.Int("port")}
+	for _, p := range cfg.Ports {
+		sample[p.Name] = p.Port
+	}
+	if err := cfg.Template.Check(cfg.data("192.0.2.1", "", sample, "", "")); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// data 組出模板資料；ports 為 port 名稱 → NodePort
+func (c connectionConfig) data(ip, hostname string, ports map[string]int, sid, namespace string) conninfo.Data {
+	return conninfo.Data{
+		IP:          ip,
+		Hostname:    hostname,
+		Port:        ports[conninfo.PrimaryPort],
+		Ports:       ports,
+		ShortID:     sid,
+		Credentials: c.Credentials,
+		ExpiresAt:   c.ExpiresAt,
+		Extra: map[string]string{
+			"namespace": namespace,
+			"pod":       "ctf-" + sid,
+		},
+	}
+}
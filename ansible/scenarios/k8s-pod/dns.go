@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/ctferio/scenarios/scenarioconfig"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/apiextensions"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// 每位玩家的 DNS 記錄：設定 dns_backend 後建立 <shortID>.<dns_zone> 指向 worker IP，
+// connection_info 的 {ip} 改為 hostname（Host header / 憑證題目可用，玩家也好記）。
+//
+// additional keys：
+//
+//	dns_backend  rfc2136（經 ExternalDNS 寫入 RFC2136 DNS server）；未設定時不建立記錄
+//	dns_zone     記錄所在 zone（例："ctf.example.com"）
+//	dns_ttl      記錄 TTL 秒數（預設 60）
+//
+// rfc2136 backend 不直接對 DNS server 送 UPDATE：scenario 只建立 ExternalDNS 的 DNSEndpoint
+// 資源（屬於玩家 stack），由叢集內以 --source=crd --provider=rfc2136 執行的 ExternalDNS
+// 同步到 DNS server（TSIG key 只放在 ExternalDNS）。destroy 刪除 DNSEndpoint 時記錄隨之移除。
+const (
+	dnsBackendRFC2136 = "rfc2136"
+
+	defaultDNSTTL = 60
+)
+
+// dnsConfig 由 additional 解析出的 DNS 設定
+type dnsConfig struct {
+	Backend string
+	Zone    string
+	TTL     int
+}
+
+func parseDNSConfig(conf *scenarioconfig.Values) dnsConfig {
+	return dnsConfig{
+		Backend: conf.String("dns_backend"),
+		Zone:    strings.TrimSuffix(conf.String("dns_zone"), "."),
+		TTL:     conf.Int("dns_ttl"),
+	}
+}
+
+// Enabled 是否建立 DNS 記錄
+func (c dnsConfig) Enabled() bool {
+	return c.Backend != ""
+}
+
+// Hostname 回傳玩家的 hostname（不含結尾的 "."）
+func (c dnsConfig) Hostname(sid string) string {
+	return sid + "." + c.Zone
+}
+
+// dnsRecord 一筆要建立的記錄（多個位址時為 round-robin）
+type dnsRecord struct {
+	FQDN      string
+	Addresses []string
+	TTL       int
+}
+
+// dnsBackend 建立 DNS 記錄的實作；記錄必須是 stack 內的資源，destroy 時才會一併刪除
+type dnsBackend interface {
+	Register(ctx *pulumi.Context, name string, rec dnsRecord, opts ...pulumi.ResourceOption) error
+}
+
+func newDNSBackend(cfg dnsConfig, namespace pulumi.StringInput, own *ownership) (dnsBackend, error) {
+	switch cfg.Backend {
+	case dnsBackendRFC2136:
+		return externalDNSBackend{Namespace: namespace, Own: own}, nil
+	}
+	return nil, fmt.Errorf("unsupported dns_backend %q", cfg.Backend)
+}
+
+// externalDNSBackend 以 ExternalDNS DNSEndpoint（externaldns.k8s.io/v1alpha1）描述記錄
+type externalDNSBackend struct {
+	Namespace pulumi.StringInput
+	Own       *ownership
+}
+
+func (b externalDNSBackend) Register(ctx *pulumi.Context, name string, rec dnsRecord, opts ...pulumi.ResourceOption) error {
+	// ExternalDNS 每個 endpoint 只能有一種記錄類型，IPv4 / IPv6 分開
+	byType := map[string][]any{}
+	for _, addr := range rec.Addresses {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return fmt.Errorf("dns record target %q is not an IP address", addr)
+		}
+		t := "AAAA"
+		if ip.To4() != nil {
+			t = "A"
+		}
+		byType[t] = append(byType[t], addr)
+	}
+	var endpoints []any
+	for _, t := range []string{"A", "AAAA"} {
+		if len(byType[t]) == 0 {
+			continue
+		}
+		endpoints = append(endpoints, map[string]any{
+			"dnsName":    rec.FQDN,
+			"recordType": t,
+			"recordTTL":  rec.TTL,
+			"targets":    byType[t],
+		})
+	}
+	if len(endpoints) == 0 {
+		return fmt.Errorf("dns record %s has no target address (set K3S_WORKER_IPS)", rec.FQDN)
+	}
+
+	_, err := apiextensions.NewCustomResource(ctx, name, &apiextensions.CustomResourceArgs{
+		ApiVersion: pulumi.String("externaldns.k8s.io/v1alpha1"),
+		Kind:       pulumi.String("DNSEndpoint"),
+		Metadata: &metav1.ObjectMetaArgs{
+			Namespace:   b.Namespace,
+			Name:        pulumi.String(name),
+			Labels:      b.Own.Labels(nil),
+			Annotations: b.Own.Annotations(nil),
+		},
+		OtherFields: kubernetes.UntypedArgs{
+			"spec": map[string]any{"endpoints": endpoints},
+		},
+	}, opts...)
+	return err
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ctferio/scenarios/scenarioconfig/flaggen"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi-random/sdk/v4/go/random"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// flag 產生策略（策略說明見 scenarioconfig/flaggen）
+//
+// additional keys：
+//
+//	flag_strategy  variate（預設）/ static / hmac / random / format
+//	flag_format    format 策略的模板，例："{{.Prefix}}{ {{leet .Base}}_{{hash 6}} }"
+//	flags          多個具名 flag（見 scenarioconfig/flaggen/flags.go），各自以同一策略產生，
+//	               stack output "named_flags"（name → flag）；主要 flag 仍為 resp.Flag
+//
+// 全域設定（環境變數，出題者無法覆蓋）：
+//
+//	CHALLENGE_FLAG_SECRET / CHALLENGE_FLAG_SECRET_FILE  hmac 策略（與 flag_format 的 .HMAC）的 key
+//
+// random 策略以 random.RandomId（ctf-<shortID>-flag-nonce）把亂數存在 Pulumi state，
+// 同一個 instance 重跑 pulumi up 得到相同 flag。產生的 flag 放進 CTF_FLAG env 與 resp.Flag。
+//
+// flags 的每個 flag 以 name=path 掛載為檔案（Secret ctf-<shortID>-flags 的 subPath，mode= 預設 0444），
+// 及 / 或以 env= 放進環境變數，兩者至少其一（見 validateFlags）。
+
+// flagOutputs 回傳主要 flag 與具名 flag（產生方式見 flaggen.Source）；
+// 需要 nonce 時建立 name 的 random.RandomId 資源（所有 flag 共用）
+func flagOutputs(ctx *pulumi.Context, flags flaggen.Source, name string, opts ...pulumi.ResourceOption) (pulumi.StringOutput, pulumi.StringMapOutput, error) {
+	if !flags.NeedsNonce() {
+		flag, err := flags.Flag("")
+		if err != nil {
+			return pulumi.StringOutput{}, pulumi.StringMapOutput{}, err
+		}
+		named, err := flags.Named("")
+		return pulumi.String(flag).ToStringOutput(), pulumi.ToStringMap(named).ToStringMapOutput(), err
+	}
+	nonce, err := random.NewRandomId(ctx, name, &random.RandomIdArgs{
+		ByteLength: pulumi.Int(flaggen.NonceBytes),
+	}, opts...)
+	if err != nil {
+		return pulumi.StringOutput{}, pulumi.StringMapOutput{}, err
+	}
+	return nonce.Hex.ApplyT(flags.Flag).(pulumi.StringOutput),
+		nonce.Hex.ApplyT(flags.Named).(pulumi.StringMapOutput), nil
+}
+
+var (
+	envNamePattern  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	flagModePattern = regexp.MustCompile(`^0?[0-7]{3}$`)
+
+	// reservedEnv scenario 自行設定的環境變數
+	reservedEnv = []string{"CTF_FLAG", "CTF_IDENTITY", "CTF_USERNAME", "CTF_PASSWORD"}
+)
+
+// validateFlags 檢查 flags：每個 flag 需要掛載路徑或 env，Pod 不支援 owner
+func validateFlags(raw string) error {
+	specs, err := flaggen.ParseFlags(raw)
+	if err != nil {
+		return err
+	}
+	paths := map[string]string{}
+	for _, spec := range specs {
+		switch {
+		case spec.Path == "" && spec.Env == "":
+			return fmt.Errorf("flag %s: requires a mount path (name=/path/to/flag) or env=NAME", spec.Name)
+		case spec.Path != "" && !strings.HasPrefix(spec.Path, "/"):
+			return fmt.Errorf("flag %s: mount path %q must be absolute", spec.Name, spec.Path)
+		case spec.Env != "" && !envNamePattern.MatchString(spec.Env):
+			return fmt.Errorf("flag %s: invalid env name %q", spec.Name, spec.Env)
+		case slices.Contains(reservedEnv, spec.Env):
+			return fmt.Errorf("flag %s: env %s is reserved", spec.Name, spec.Env)
+		case spec.Owner != "":
+			return fmt.Errorf("flag %s: owner= is not supported on pods (use the image's user / fsGroup)", spec.Name)
+		case spec.Mode != "" && spec.Path == "":
+			return fmt.Errorf("flag %s: mode= requires a mount path", spec.Name)
+		case spec.Mode != "" && !flagModePattern.MatchString(spec.Mode):
+			return fmt.Errorf("flag %s: invalid mode %q (want octal, e.g. 0400)", spec.Name, spec.Mode)
+		}
+		if spec.Path == "" {
+			continue
+		}
+		if other, ok := paths[spec.Path]; ok {
+			return fmt.Errorf("flag %s: path %s already used by flag %s", spec.Name, spec.Path, other)
+		}
+		paths[spec.Path] = spec.Name
+	}
+	return nil
+}
+
+// podFlags flags 在 Pod 中的投遞方式
+type podFlags struct {
+	Volumes corev1.VolumeArray
+	Mounts  corev1.VolumeMountArray
+	Env     corev1.EnvVarArray
+}
+
+// newPodFlags 有掛載路徑的 flag 放進 Secret ctf-<shortID>-flags，以 subPath 唯讀掛載到各自路徑；
+// env= 的 flag 加入容器環境變數。沒有宣告 flags 時不建立任何資源
+func newPodFlags(ctx *pulumi.Context, specs []flaggen.FlagSpec, sid string, namespace pulumi.StringOutput, named pulumi.StringMapOutput, own *ownership, opts ...pulumi.ResourceOption) (podFlags, error) {
+	var out podFlags
+	var items corev1.KeyToPathArray
+	for _, spec := range specs {
+		if spec.Env != "" {
+			out.Env = append(out.Env, &corev1.EnvVarArgs{
+				Name:  pulumi.String(spec.Env),
+				Value: named.MapIndex(pulumi.String(spec.Name)),
+			})
+		}
+		if spec.Path == "" {
+			continue
+		}
+		mode := int64(0o444)
+		if spec.Mode != "" {
+			// 格式已由 schema 驗證
+			mode, _ = strconv.ParseInt(spec.Mode, 8, 32)
+		}
+		items = append(items, &corev1.KeyToPathArgs{
+			Key:  pulumi.String(spec.Name),
+			Path: pulumi.String(spec.Name),
+			Mode: pulumi.Int(int(mode)),
+		})
+		out.Mounts = append(out.Mounts, &corev1.VolumeMountArgs{
+			Name:      pulumi.String("ctf-flags"),
+			MountPath: pulumi.String(spec.Path),
+			SubPath:   pulumi.String(spec.Name),
+			ReadOnly:  pulumi.Bool(true),
+		})
+	}
+	if len(items) == 0 {
+		return out, nil
+	}
+	secret, err := corev1.NewSecret(ctx, "flags", &corev1.SecretArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Namespace:   namespace,
+			Name:        pulumi.String(fmt.Sprintf("ctf-%s-flags", sid)),
+			Labels:      own.Labels(nil),
+			Annotations: own.Annotations(nil),
+		},
+		StringData: named,
+	}, opts...)
+	if err != nil {
+		return podFlags{}, fmt.Errorf("create flags secret: %w", err)
+	}
+	out.Volumes = corev1.VolumeArray{
+		&corev1.VolumeArgs{
+			Name: pulumi.String("ctf-flags"),
+			Secret: &corev1.SecretVolumeSourceArgs{
+				// 以 Secret 的 output 引用名稱，Pod 在 Secret 建立後才建立
+				SecretName: secret.Metadata.Name().Elem(),
+				Items:      items,
+			},
+		},
+	}
+	return out, nil
+}
@@ -4,8 +4,25 @@ go 1.25
 
 require (
 	github.com/ctfer-io/chall-manager/sdk v0.6.3
+	// scenarioconfig：共用的 additional config schema（見 ../scenarioconfig）
+	github.com/ctferio/scenarios/scenarioconfig v0.0.0
+	// scenarioplan：plan 子命令（mock runtime 離線輸出資源與 outputs，見 ../scenarioplan）
+	github.com/ctferio/scenarios/scenarioplan v0.0.0
+	// scenariotrace：OpenTelemetry trace（設定 OTEL_EXPORTER_OTLP_ENDPOINT 時啟用，見 ../scenariotrace）
+	github.com/ctferio/scenarios/scenariotrace v0.0.0
 	github.com/pulumi/pulumi-kubernetes/sdk/v4 v4.25.0
+	// pulumi-random：flag_strategy=random 的 nonce（存在 Pulumi state）
+	github.com/pulumi/pulumi-random/sdk/v4 v4.16.7
 	github.com/pulumi/pulumi/sdk/v3 v3.219.0
+	// yaml.v3：命名衝突檢查讀取 kubeconfig（見 kubeapi.go）
+	gopkg.in/yaml.v3 v3.0.1
 )
 
+// scenarioconfig / scenarioplan / scenariotrace 與 scenario 一起 rsync 到 build 主機，以相對路徑引用
+replace github.com/ctferio/scenarios/scenarioconfig => ../scenarioconfig
+
+replace github.com/ctferio/scenarios/scenarioplan => ../scenarioplan
+
+replace github.com/ctferio/scenarios/scenariotrace => ../scenariotrace
+
 // 執行 go mod tidy 自動補全間接依賴
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// pulumi-kubernetes 沒有提供的查詢（建立前檢查名稱是否被其他 identity 佔用、timings 的 pod Ready 時間）直接呼叫 Kubernetes API。
+// 連線設定與 provider 相同來源：KUBECONFIG（目前的 context，多個路徑取第一個）或 ~/.kube/config，
+// 都沒有時使用 in-cluster service account。只支援 token 與 client certificate 認證（k3s kubeconfig 即為後者），
+// exec / auth-provider plugin 的 kubeconfig 回傳 errKubeAPIUnavailable，由呼叫端略過檢查。
+
+const (
+	inClusterTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	inClusterCAPath    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+
+	kubeAPITimeout = 10 * time.Second
+)
+
+// errKubeAPIUnavailable 找不到可用的連線設定
+var errKubeAPIUnavailable = errors.New("no usable kubeconfig or in-cluster service account")
+
+// deployEnv run 依賴的外部環境：實際部署為 liveEnv，plan 子命令注入不連線的版本（見 plan.go）
+type deployEnv struct {
+	// newCluster 建立 Kubernetes API client；沒有可用的連線設定時回傳 errKubeAPIUnavailable
+	newCluster func() (cluster, error)
+	// now 部署時間（ownership 時間戳、帳密衍生）
+	now func() time.Time
+}
+
+var liveEnv = deployEnv{
+	newCluster: func() (cluster, error) { return newKubeAPI() },
+	now:        time.Now,
+}
+
+// cluster run 在 Pulumi 資源之外讀取的 Kubernetes 狀態，實作為 kubeAPI（plan 為 offlineCluster）
+type cluster interface {
+	labels(ctx context.Context, path string) (labels map[string]string, exists bool, err error)
+	podReadyAt(ctx context.Context, path string) (at time.Time, ready bool, err error)
+}
+
+// kubeAPI 最小的 Kubernetes REST client（只做 GET）
+type kubeAPI struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+// kubeconfig 只解析需要的欄位
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server   string `yaml:"server"`
+			CA       string `yaml:"certificate-authority"`
+			CAData   string `yaml:"certificate-authority-data"`
+			Insecure bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token     string `yaml:"token"`
+			TokenFile string `yaml:"tokenFile"`
+			Cert      string `yaml:"client-certificate"`
+			CertData  string `yaml:"client-certificate-data"`
+			Key       string `yaml:"client-key"`
+			KeyData   string `yaml:"client-key-data"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+// newKubeAPI 依 KUBECONFIG / ~/.kube/config / in-cluster 的順序建立 client
+func newKubeAPI() (*kubeAPI, error) {
+	path := strings.Split(os.Getenv("KUBECONFIG"), string(os.PathListSeparator))[0]
+	if path == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, ".kube", "config")
+		}
+	}
+	if path != "" {
+		if _, err := os.Stat(path); err == nil {
+			return kubeAPIFromKubeconfig(path)
+		}
+	}
+	return kubeAPIInCluster()
+}
+
+func kubeAPIFromKubeconfig(path string) (*kubeAPI, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read kubeconfig: %w", err)
+	}
+	var kc kubeconfig
+	if err := yaml.Unmarshal(raw, &kc); err != nil {
+		return nil, fmt.Errorf("parse kubeconfig %s: %w", path, err)
+	}
+	// 相對路徑以 kubeconfig 所在目錄為準
+	dir := filepath.Dir(path)
+	read := func(data, file string) ([]byte, error) {
+		if data != "" {
+			return base64.StdEncoding.DecodeString(data)
+		}
+		if file == "" {
+			return nil, nil
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		return os.ReadFile(file)
+	}
+
+	ctxName := kc.CurrentContext
+	if ctxName == "" && len(kc.Contexts) == 1 {
+		ctxName = kc.Contexts[0].Name
+	}
+	var clusterName, userName string
+	for _, c := range kc.Contexts {
+		if c.Name == ctxName {
+			clusterName, userName = c.Context.Cluster, c.Context.User
+		}
+	}
+	api := &kubeAPI{}
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	found := false
+	for _, c := range kc.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		found = true
+		api.server = c.Cluster.Server
+		tlsCfg.InsecureSkipVerify = c.Cluster.Insecure
+		ca, err := read(c.Cluster.CAData, c.Cluster.CA)
+		if err != nil {
+			return nil, fmt.Errorf("kubeconfig cluster %s: certificate authority: %w", c.Name, err)
+		}
+		if len(ca) > 0 {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("kubeconfig cluster %s: invalid certificate authority", c.Name)
+			}
+			tlsCfg.RootCAs = pool
+		}
+	}
+	if !found || api.server == "" {
+		return nil, fmt.Errorf("kubeconfig %s: context %q has no cluster server", path, ctxName)
+	}
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		api.token = u.User.Token
+		if api.token == "" && u.User.TokenFile != "" {
+			tok, err := read("", u.User.TokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("kubeconfig user %s: token file: %w", u.Name, err)
+			}
+			api.token = strings.TrimSpace(string(tok))
+		}
+		cert, err := read(u.User.CertData, u.User.Cert)
+		if err != nil {
+			return nil, fmt.Errorf("kubeconfig user %s: client certificate: %w", u.Name, err)
+		}
+		key, err := read(u.User.KeyData, u.User.Key)
+		if err != nil {
+			return nil, fmt.Errorf("kubeconfig user %s: client key: %w", u.Name, err)
+		}
+		if len(cert) > 0 && len(key) > 0 {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, fmt.Errorf("kubeconfig user %s: client certificate: %w", u.Name, err)
+			}
+			tlsCfg.Certificates = []tls.Certificate{pair}
+		}
+	}
+	if api.token == "" && len(tlsCfg.Certificates) == 0 {
+		return nil, errKubeAPIUnavailable
+	}
+	api.client = &http.Client{
+		Timeout:   kubeAPITimeout,
+		Transport: &http.Transport{TLSClientConfig: tlsCfg, Proxy: http.ProxyFromEnvironment},
+	}
+	return api, nil
+}
+
+func kubeAPIInCluster() (*kubeAPI, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errKubeAPIUnavailable
+	}
+	token, err := os.ReadFile(inClusterTokenPath)
+	if err != nil {
+		return nil, errKubeAPIUnavailable
+	}
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if ca, err := os.ReadFile(inClusterCAPath); err == nil {
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(ca)
+		tlsCfg.RootCAs = pool
+	}
+	return &kubeAPI{
+		server: "https://" + net.JoinHostPort(host, port),
+		token:  strings.TrimSpace(string(token)),
+		client: &http.Client{Timeout: kubeAPITimeout, Transport: &http.Transport{TLSClientConfig: tlsCfg}},
+	}, nil
+}
+
+// get 讀取物件並解析 JSON 到 out；物件不存在時 exists 為 false
+func (k *kubeAPI) get(ctx context.Context, path string, out any) (exists bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(k.server, "/")+path, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if k.token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.token)
+	}
+	res, err := k.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("GET %s: %w", path, err)
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return false, nil
+	case res.StatusCode != http.StatusOK:
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return false, fmt.Errorf("GET %s: %s: %s", path, res.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return false, fmt.Errorf("GET %s: %w", path, err)
+	}
+	return true, nil
+}
+
+// labels 讀取物件的 metadata.labels；物件不存在時 exists 為 false
+func (k *kubeAPI) labels(ctx context.Context, path string) (labels map[string]string, exists bool, err error) {
+	var obj struct {
+		Metadata struct {
+			Labels map[string]string `json:"labels"`
+		} `json:"metadata"`
+	}
+	if exists, err = k.get(ctx, path, &obj); !exists || err != nil {
+		return nil, exists, err
+	}
+	return obj.Metadata.Labels, true, nil
+}
+
+// podReadyAt 讀取 pod 的 Ready condition；Ready 時回傳 Kubernetes 記錄的轉換時間（秒精度）
+func (k *kubeAPI) podReadyAt(ctx context.Context, path string) (at time.Time, ready bool, err error) {
+	var obj struct {
+		Status struct {
+			Conditions []struct {
+				Type               string    `json:"type"`
+				Status             string    `json:"status"`
+				LastTransitionTime time.Time `json:"lastTransitionTime"`
+			} `json:"conditions"`
+		} `json:"status"`
+	}
+	if _, err := k.get(ctx, path, &obj); err != nil {
+		return time.Time{}, false, err
+	}
+	for _, c := range obj.Status.Conditions {
+		if c.Type == "Ready" && c.Status == "True" {
+			return c.LastTransitionTime, true, nil
+		}
+	}
+	return time.Time{}, false, nil
+}
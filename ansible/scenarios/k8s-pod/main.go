@@ -4,9 +4,12 @@
 // 使用 chall-manager SDK 模式：
 //   - identity 由 SDK 從 Pulumi config 自動讀取
 //   - 題目設定透過 additional（per-challenge）讀取，fallback 到環境變數（全域）
+//   - additional 在建立任何資源前依 schema.go 驗證，型別錯誤與未知 key 一次回報
 //   - connection_info 和 flag 透過 sdk.Response 回傳
+//   - 各資源的 requested / created / ready 時間匯出為 stack output "timings"（見 timing.go）
+//   - 設定 OTEL_EXPORTER_OTLP_ENDPOINT 時每次部署送出一條 OpenTelemetry trace（見 trace.go）
 //
-// additional 支援的 key（可在 CTFd Advanced 區塊設定）：
+// additional 支援的 key（可在 CTFd Advanced 區塊設定；完整清單以 schema.go 為準，./main schema 輸出 JSON Schema）：
 //   image          靶機 container image（預設 ubuntu:22.04）
 //                  若 CHALLENGE_REGISTRY 已設定且 image 不含 registry prefix，
 //                  會自動加上 prefix（如 "exchange:latest" → "192.168.x.x:5000/exchange:latest"）
@@ -14,240 +17,369 @@
 //   command        覆蓋 entrypoint（逗號分隔，如 "sleep,infinity"）
 //   base_flag      flag 衍生基礎值
 //   flag_prefix    flag 前綴（預設 CTF）
+//   flag_strategy  variate（預設）/ static / hmac / random / format（flag_format 模板），見 flagstrategy.go
+//   flags          多個具名 flag（"user=/flag/user.txt mode=0400, root env=ROOT_FLAG"），
+//                  掛載為檔案及 / 或放進 env，stack output "named_flags"（見 flagstrategy.go）
 //   cpu_request    CPU request（預設 100m）
 //   cpu_limit      CPU limit（預設 500m）
 //   memory_request Memory request（預設 128Mi）
 //   memory_limit   Memory limit（預設 512Mi）
-//   connection_info       連線資訊模板（支援 {ip} {port} 佔位符，預設 "nc {ip} {port}"）
+//   connection_info       連線資訊模板（Go text/template，相容 {ip} {port} 佔位符，預設 "nc {ip} {port}"，見 connection.go）
+//   ports                 額外的具名 port（"web=80,debug=9000"），各自建立 NodePort，模板以 {{.Ports.web}} 取得
+//   credentials_user      產生 per-instance 帳密（CTF_USERNAME / CTF_PASSWORD，模板 {{.Credentials.Password}}）
 //   use_shared_namespace  使用共用 namespace（預設 "true"，省一次 K8s API call，加速 boot + destroy）
 //   shared_namespace      共用 namespace 名稱（預設 "challenges"，由 Ansible k3s role 預建）
+//   dns_backend / dns_zone / dns_ttl
+//                         建立 <shortID>.<dns_zone> 記錄（rfc2136，經 ExternalDNS），{ip} 改為 hostname（見 dns.go）
+//   instance_id_hash / instance_id_length / instance_id_scope
+//                         shortID 的 hash（預設 md5）、長度（預設 8）與是否加入 challenge_id（見 naming.go）
+//   readiness_timeout     等待 pod Ready 的上限（預設 "0" 不等待，只記錄 timings 當下的狀態，見 timing.go）
+//   challenge_id / source_id / instance_timeout
+//                         ownership labels / annotations（見 ownership.go；registration script 注入）
 //
 // 建立的 Kubernetes 資源（每位玩家一組，以 shortID 隔離）：
 //   - Namespace  challenges（共用）或 ctf-<shortID>（獨立，use_shared_namespace=false）
 //   - Pod        ctf-<shortID>          （靶機本體，resource limited）
 //   - Service    ctf-<shortID>-svc      （NodePort，玩家連線入口）
+//   - DNSEndpoint ctf-<shortID>-dns     （僅 dns_backend 設定時，ExternalDNS 同步到 DNS server）
+//   - RandomId   ctf-<shortID>-flag-nonce（僅 flag_strategy=random 時，亂數存在 Pulumi state）
+//   - Secret     ctf-<shortID>-flags    （僅 flags 有掛載路徑時，以 subPath 掛進 Pod）
 package main
 
 import (
-	"crypto/md5"
 	"fmt"
 	"os"
-	"strconv"
 	"strings"
 
 	"github.com/ctfer-io/chall-manager/sdk"
+	"github.com/ctferio/scenarios/scenarioconfig/conninfo"
+	"github.com/ctferio/scenarios/scenarioconfig/flaggen"
+	"github.com/ctferio/scenarios/scenarioconfig/timing"
 	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/core/v1"
 	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v4/go/kubernetes/meta/v1"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
-// shortID 從玩家 identity 產生 8 字元識別碼（MD5 前 8 hex）。
-// 用途：Kubernetes 資源命名（需符合 DNS 規範且夠短）。
-func shortID(identity string) string {
-	h := md5.Sum([]byte(identity))
-	return fmt.Sprintf("%x", h)[:8]
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		// schema 子命令：輸出 additional 的 JSON Schema，不執行 Pulumi（見 schema.go）
+		case "schema":
+			os.Exit(schemaCommand())
+		// plan 子命令：以 mock runtime 離線執行，輸出資源、pod spec 與 outputs（見 plan.go）
+		case "plan":
+			os.Exit(planCommand(os.Args[2:]))
+		}
+	}
+	sdk.Run(liveEnv.run)
 }
 
-func main() {
-	sdk.Run(func(req *sdk.Request, resp *sdk.Response, opts ...pulumi.ResourceOption) error {
-		ctx := req.Ctx
-		identity := req.Config.Identity
-		sid := shortID(identity)
+func (e deployEnv) run(req *sdk.Request, resp *sdk.Response, opts ...pulumi.ResourceOption) (err error) {
+	ctx := req.Ctx
+	identity := req.Config.Identity
 
-		// ── 題目設定（additional 優先，fallback 到環境變數）────
-		baseFlag := configOrEnv(req, "base_flag", "CHALLENGE_BASE_FLAG", "default_base_flag")
-		flagPrefix := configOrEnv(req, "flag_prefix", "CHALLENGE_FLAG_PREFIX", "CTF")
-		rawImage := configOrEnv(req, "image", "CHALLENGE_IMAGE", "ubuntu:22.04")
-		registry := envOrDefault("CHALLENGE_REGISTRY", "")
-		image := resolveImage(rawImage, registry)
-		challengePortStr := configOrEnv(req, "port", "CHALLENGE_PORT", "22")
+	// OpenTelemetry trace（未設定 OTLP endpoint 時為 nil，見 trace.go）；run 回傳 error 時帶 error 結束
+	tr := startTrace(ctx, identity)
+	defer func() {
+		if err != nil {
+			finishTrace(tr, err)
+		}
+	}()
 
-		connTpl := configOrEnv(req, "connection_info", "", "nc {ip} {port}")
+	// ── 題目設定（additional 優先，fallback 到環境變數）────
+	// 在建立任何資源前驗證所有 key（型別、範圍、未知 key），一次回報全部問題（見 schema.go）
+	endConfig := tr.Step("config")
+	conf, err := configSchema.Parse(req.Config.Additional)
+	endConfig(err)
+	if err != nil {
+		return err
+	}
+	tr.SetChallenge(conf.String("challenge_id"))
+	// 各資源的 requested / created / ready 時間（stack output "timings"，見 timing.go）
+	tm := newTimings(ctx, conf, identity, tr, e)
+	registry := envOrDefault("CHALLENGE_REGISTRY", "")
+	image := resolveImage(conf.String("image"), registry)
+	challengePort := conf.Int("port")
 
-		challengePort, _ := strconv.Atoi(challengePortStr)
-		if challengePort == 0 {
-			challengePort = 22
-		}
+	// ── shortID：Kubernetes 資源命名（需符合 DNS 規範且夠短，見 naming.go）──
+	names := parseNaming(conf, conf.String("image"))
+	sid := names.ShortID(identity)
 
-		// ── 資源限制（additional 可覆蓋）─────────────────────
-		cpuRequest := configOrEnv(req, "cpu_request", "", "100m")
-		cpuLimit := configOrEnv(req, "cpu_limit", "", "500m")
-		memRequest := configOrEnv(req, "memory_request", "", "128Mi")
-		memLimit := configOrEnv(req, "memory_limit", "", "512Mi")
+	// ── connection_info 模板 / 具名 port / per-instance 帳密（見 connection.go）──
+	now := e.now()
+	conn, err := parseConnectionConfig(conf, identity, now)
+	if err != nil {
+		return err
+	}
 
-		// ── 可選指令覆蓋（comma-separated）────────────────────
-		// 測試時設 command="sleep,infinity" 讓容器持續運行
-		var containerCommand pulumi.StringArray
-		if rawCmd := configOrEnv(req, "command", "CHALLENGE_COMMAND", ""); rawCmd != "" {
-			for _, part := range strings.Split(rawCmd, ",") {
-				containerCommand = append(containerCommand, pulumi.String(strings.TrimSpace(part)))
-			}
+	// ── 資源限制（additional 可覆蓋）─────────────────────
+	cpuRequest := conf.String("cpu_request")
+	cpuLimit := conf.String("cpu_limit")
+	memRequest := conf.String("memory_request")
+	memLimit := conf.String("memory_limit")
+
+	// ── 可選指令覆蓋（comma-separated）────────────────────
+	// 測試時設 command="sleep,infinity" 讓容器持續運行
+	var containerCommand pulumi.StringArray
+	if rawCmd := conf.String("command"); rawCmd != "" {
+		for _, part := range strings.Split(rawCmd, ",") {
+			containerCommand = append(containerCommand, pulumi.String(strings.TrimSpace(part)))
 		}
+	}
 
-		// ── 動態 flag（使用 SDK Variate，統一演算法）─────────
-		flag := fmt.Sprintf("%s{%s}", flagPrefix, sdk.Variate(identity, baseFlag))
+	// ── 動態 flag（flag_strategy，預設 SDK Variate，見 flagstrategy.go）──
+	flags, err := flaggen.NewSource(conf, identity, func(identity, base string) string { return sdk.Variate(identity, base) })
+	if err != nil {
+		return err
+	}
 
-		// worker IPs（逗號分隔，取第一個供連線資訊使用）
-		rawWorkerIPs := envOrDefault("K3S_WORKER_IPS", "")
-		workerIPs := strings.Split(rawWorkerIPs, ",")
-		workerIP := strings.TrimSpace(workerIPs[0])
+	// worker IPs（逗號分隔，取第一個供連線資訊使用）
+	rawWorkerIPs := envOrDefault("K3S_WORKER_IPS", "")
+	workerIPs := strings.Split(rawWorkerIPs, ",")
+	workerIP := strings.TrimSpace(workerIPs[0])
 
-		// ── 共用 Namespace 設定 ─────────────────────────────
-		useSharedNS := configOrEnv(req, "use_shared_namespace", "", "true") == "true"
-		sharedNSName := configOrEnv(req, "shared_namespace", "", "challenges")
+	// ── 每位玩家的 DNS 記錄 <shortID>.<dns_zone>（見 dns.go）──
+	dnsCfg := parseDNSConfig(conf)
+	connHostname := ""
 
-		// ── Kubernetes 資源名稱 ────────────────────────────
-		podName := fmt.Sprintf("ctf-%s", sid)
-		svcName := fmt.Sprintf("ctf-%s-svc", sid)
+	// ── 共用 Namespace 設定 ─────────────────────────────
+	useSharedNS := conf.Bool("use_shared_namespace")
+	sharedNSName := conf.String("shared_namespace")
 
-		// ── Namespace ────────────────────────────────────
-		// 共用模式（預設）：用 Ansible 預建的 challenges namespace，省一次 K8s API call
-		// 獨立模式：每位玩家建專屬 namespace（較慢但隔離更強）
-		var namespaceName pulumi.StringOutput
-		if useSharedNS {
-			namespaceName = pulumi.String(sharedNSName).ToStringOutput()
-		} else {
-			nsName := fmt.Sprintf("ctf-%s", sid)
-			ns, nsErr := corev1.NewNamespace(ctx, "ns", &corev1.NamespaceArgs{
-				Metadata: &metav1.ObjectMetaArgs{
-					Name: pulumi.String(nsName),
-					Labels: pulumi.StringMap{
-						"managed-by":   pulumi.String("chall-manager"),
-						"ctf-id":       pulumi.String(sid),
-						"ctf-scenario": pulumi.String("k8s-pod"),
-					},
-					Annotations: pulumi.StringMap{
-						"pulumi.com/skipAwait": pulumi.String("true"),
-					},
-				},
-			}, opts...)
-			if nsErr != nil {
-				return fmt.Errorf("create namespace: %w", nsErr)
-			}
-			namespaceName = ns.Metadata.Name().Elem()
-		}
+	// ── Ownership labels / annotations（見 ownership.go）────
+	own := newOwnership(conf, identity, sid, now)
+	ownOpts := append(opts[:len(opts):len(opts)], own.IgnoreTimestamps())
+
+	// ── Kubernetes 資源名稱 ────────────────────────────
+	podName := fmt.Sprintf("ctf-%s", sid)
+	svcName := fmt.Sprintf("ctf-%s-svc", sid)
+
+	// 同名物件屬於其他 identity 時直接失敗，不接管也不覆蓋（見 naming.go）
+	objNS := sharedNSName
+	owned := []kubeObject{}
+	if !useSharedNS {
+		objNS = fmt.Sprintf("ctf-%s", sid)
+		owned = append(owned, kubeObject{Kind: "namespace", Name: objNS})
+	}
+	owned = append(owned,
+		kubeObject{Kind: "pod", Namespace: objNS, Name: podName},
+		kubeObject{Kind: "service", Namespace: objNS, Name: svcName},
+	)
+	if err := checkNameOwners(ctx.Context(), e.newCluster, names, identity, owned...); err != nil {
+		return err
+	}
 
-		// ── Challenge Pod ──────────────────────────────────
-		_, err := corev1.NewPod(ctx, "pod", &corev1.PodArgs{
+	// ── Namespace ────────────────────────────────────
+	// 共用模式（預設）：用 Ansible 預建的 challenges namespace，省一次 K8s API call
+	// 獨立模式：每位玩家建專屬 namespace（較慢但隔離更強）
+	var namespaceName pulumi.StringOutput
+	nsName := sharedNSName
+	if useSharedNS {
+		namespaceName = pulumi.String(sharedNSName).ToStringOutput()
+	} else {
+		nsName = fmt.Sprintf("ctf-%s", sid)
+		ns, nsErr := corev1.NewNamespace(ctx, "ns", &corev1.NamespaceArgs{
 			Metadata: &metav1.ObjectMetaArgs{
-				Namespace: namespaceName,
-				Name:      pulumi.String(podName),
-				Labels: pulumi.StringMap{
-					"app":          pulumi.String("ctf-challenge"),
-					"ctf-id":       pulumi.String(sid),
-					"ctf-scenario": pulumi.String("k8s-pod"),
-				},
-				// ✅ skipAwait：不等 Pod Running，Pulumi 建完即繼續
-				Annotations: pulumi.StringMap{
-					"pulumi.com/skipAwait": pulumi.String("true"),
-				},
+				Name:   pulumi.String(nsName),
+				Labels: own.Labels(nil),
+				Annotations: own.Annotations(map[string]string{
+					"pulumi.com/skipAwait": "true",
+				}),
 			},
-			Spec: &corev1.PodSpecArgs{
-				// ✅ 設為 0：跳過 graceful shutdown，Pod 立即強制刪除
-				TerminationGracePeriodSeconds: pulumi.Int(0),
-				Containers: corev1.ContainerArray{
-					&corev1.ContainerArgs{
-						Name:            pulumi.String("challenge"),
-						Image:           pulumi.String(image),
-						ImagePullPolicy: pulumi.String("IfNotPresent"),
-						Command:         containerCommand, // nil = 使用 image 預設 entrypoint
-						Resources: &corev1.ResourceRequirementsArgs{
-							Requests: pulumi.StringMap{
-								"cpu":    pulumi.String(cpuRequest),
-								"memory": pulumi.String(memRequest),
-							},
-							Limits: pulumi.StringMap{
-								"cpu":    pulumi.String(cpuLimit),
-								"memory": pulumi.String(memLimit),
-							},
-						},
-						Env: corev1.EnvVarArray{
-							&corev1.EnvVarArgs{
-								Name:  pulumi.String("CTF_FLAG"),
-								Value: pulumi.String(flag),
-							},
-							&corev1.EnvVarArgs{
-								Name:  pulumi.String("CTF_IDENTITY"),
-								Value: pulumi.String(identity),
-							},
+		}, ownOpts...)
+		if nsErr != nil {
+			return fmt.Errorf("create namespace: %w", nsErr)
+		}
+		tm.track("namespace", ns)
+		namespaceName = ns.Metadata.Name().Elem()
+	}
+
+	flag, namedFlags, err := flagOutputs(ctx, flags, fmt.Sprintf("ctf-%s-flag-nonce", sid), opts...)
+	if err != nil {
+		return fmt.Errorf("create flag nonce: %w", err)
+	}
+	// 具名 flag（flags）：掛載為檔案及 / 或放進 env（見 flagstrategy.go）
+	named, err := newPodFlags(ctx, flags.Specs, sid, namespaceName, namedFlags, own, ownOpts...)
+	if err != nil {
+		return err
+	}
+
+	// ── Container / Service ports：主要 port 名為 challenge，其餘為 ports 宣告的具名 port ──
+	containerPorts := corev1.ContainerPortArray{
+		&corev1.ContainerPortArgs{
+			Name:          pulumi.String(conninfo.PrimaryPort),
+			ContainerPort: pulumi.Int(challengePort),
+			Protocol:      pulumi.String("TCP"),
+		},
+	}
+	servicePorts := corev1.ServicePortArray{
+		&corev1.ServicePortArgs{
+			Name:       pulumi.String(conninfo.PrimaryPort),
+			Port:       pulumi.Int(challengePort),
+			TargetPort: pulumi.Int(challengePort),
+			Protocol:   pulumi.String("TCP"),
+		},
+	}
+	for _, p := range conn.Ports {
+		containerPorts = append(containerPorts, &corev1.ContainerPortArgs{
+			Name:          pulumi.String(p.Name),
+			ContainerPort: pulumi.Int(p.Port),
+			Protocol:      pulumi.String("TCP"),
+		})
+		servicePorts = append(servicePorts, &corev1.ServicePortArgs{
+			Name:       pulumi.String(p.Name),
+			Port:       pulumi.Int(p.Port),
+			TargetPort: pulumi.Int(p.Port),
+			Protocol:   pulumi.String("TCP"),
+		})
+	}
+
+	env := corev1.EnvVarArray{
+		&corev1.EnvVarArgs{
+			Name:  pulumi.String("CTF_FLAG"),
+			Value: flag,
+		},
+		&corev1.EnvVarArgs{
+			Name:  pulumi.String("CTF_IDENTITY"),
+			Value: pulumi.String(identity),
+		},
+	}
+	if conn.Credentials.Username != "" {
+		env = append(env,
+			&corev1.EnvVarArgs{
+				Name:  pulumi.String("CTF_USERNAME"),
+				Value: pulumi.String(conn.Credentials.Username),
+			},
+			&corev1.EnvVarArgs{
+				Name:  pulumi.String("CTF_PASSWORD"),
+				Value: pulumi.String(conn.Credentials.Password),
+			},
+		)
+	}
+	env = append(env, named.Env...)
+
+	// ── Challenge Pod ──────────────────────────────────
+	pod, err := corev1.NewPod(ctx, "pod", &corev1.PodArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Namespace: namespaceName,
+			Name:      pulumi.String(podName),
+			Labels: own.Labels(map[string]string{
+				"app": "ctf-challenge",
+			}),
+			// ✅ skipAwait：不等 Pod Running，Pulumi 建完即繼續
+			Annotations: own.Annotations(map[string]string{
+				"pulumi.com/skipAwait": "true",
+			}),
+		},
+		Spec: &corev1.PodSpecArgs{
+			// ✅ 設為 0：跳過 graceful shutdown，Pod 立即強制刪除
+			TerminationGracePeriodSeconds: pulumi.Int(0),
+			Containers: corev1.ContainerArray{
+				&corev1.ContainerArgs{
+					Name:            pulumi.String("challenge"),
+					Image:           pulumi.String(image),
+					ImagePullPolicy: pulumi.String("IfNotPresent"),
+					Command:         containerCommand, // nil = 使用 image 預設 entrypoint
+					Resources: &corev1.ResourceRequirementsArgs{
+						Requests: pulumi.StringMap{
+							"cpu":    pulumi.String(cpuRequest),
+							"memory": pulumi.String(memRequest),
 						},
-						Ports: corev1.ContainerPortArray{
-							&corev1.ContainerPortArgs{
-								ContainerPort: pulumi.Int(challengePort),
-								Protocol:      pulumi.String("TCP"),
-							},
+						Limits: pulumi.StringMap{
+							"cpu":    pulumi.String(cpuLimit),
+							"memory": pulumi.String(memLimit),
 						},
 					},
+					Env:          env,
+					Ports:        containerPorts,
+					VolumeMounts: named.Mounts,
 				},
-				RestartPolicy: pulumi.String("Never"),
 			},
-		}, opts...)
-		if err != nil {
-			return fmt.Errorf("create pod: %w", err)
-		}
+			Volumes:       named.Volumes,
+			RestartPolicy: pulumi.String("Never"),
+		},
+	}, ownOpts...)
+	if err != nil {
+		return fmt.Errorf("create pod: %w", err)
+	}
+	tm.track("pod", pod, namespaceName, flag, namedFlags)
 
-		// ── NodePort Service（玩家連線入口）──────────────
-		svc, err := corev1.NewService(ctx, "svc", &corev1.ServiceArgs{
-			Metadata: &metav1.ObjectMetaArgs{
-				Namespace: namespaceName,
-				Name:      pulumi.String(svcName),
-				Annotations: pulumi.StringMap{
-					"pulumi.com/skipAwait": pulumi.String("true"),
-				},
+	// ── NodePort Service（玩家連線入口）──────────────
+	svc, err := corev1.NewService(ctx, "svc", &corev1.ServiceArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Namespace: namespaceName,
+			Name:      pulumi.String(svcName),
+			Labels:    own.Labels(nil),
+			Annotations: own.Annotations(map[string]string{
+				"pulumi.com/skipAwait": "true",
+			}),
+		},
+		Spec: &corev1.ServiceSpecArgs{
+			Type: pulumi.String("NodePort"),
+			Selector: pulumi.StringMap{
+				"app":    pulumi.String("ctf-challenge"),
+				"ctf-id": pulumi.String(sid),
 			},
-			Spec: &corev1.ServiceSpecArgs{
-				Type: pulumi.String("NodePort"),
-				Selector: pulumi.StringMap{
-					"app":    pulumi.String("ctf-challenge"),
-					"ctf-id": pulumi.String(sid),
-				},
-				Ports: corev1.ServicePortArray{
-					&corev1.ServicePortArgs{
-						Name:       pulumi.String("challenge"),
-						Port:       pulumi.Int(challengePort),
-						TargetPort: pulumi.Int(challengePort),
-						Protocol:   pulumi.String("TCP"),
-					},
-				},
-			},
-		}, opts...)
+			Ports: servicePorts,
+		},
+	}, ownOpts...)
+	if err != nil {
+		return fmt.Errorf("create service: %w", err)
+	}
+	tm.track("service", svc, namespaceName)
+	// pod Ready：service 建立後讀取，readiness_timeout > 0 時等待（見 timing.go）
+	tm.podReady(ctx, kubeObject{Kind: "pod", Namespace: objNS, Name: podName}, conf.Duration("readiness_timeout"), pod.ID(), svc.ID())
+
+	// ── DNS 記錄：指向所有 worker（NodePort 在每個 node 都開放），destroy 時移除 ──
+	if dnsCfg.Enabled() {
+		backend, err := newDNSBackend(dnsCfg, namespaceName, own)
 		if err != nil {
-			return fmt.Errorf("create service: %w", err)
+			return err
+		}
+		var targets []string
+		for _, ip := range workerIPs {
+			if ip = strings.TrimSpace(ip); ip != "" {
+				targets = append(targets, ip)
+			}
+		}
+		connHostname = dnsCfg.Hostname(sid)
+		if err := backend.Register(ctx, fmt.Sprintf("ctf-%s-dns", sid), dnsRecord{
+			FQDN:      connHostname,
+			Addresses: targets,
+			TTL:       dnsCfg.TTL,
+		}, ownOpts...); err != nil {
+			return fmt.Errorf("create dns record: %w", err)
 		}
+	}
 
-		// ── Response（SDK 自動 export connection_info 和 flag）───
-		resp.ConnectionInfo = svc.Spec.ApplyT(func(spec corev1.ServiceSpec) string {
-			if len(spec.Ports) == 0 || spec.Ports[0].NodePort == nil {
-				return fmt.Sprintf("Service initializing... worker=%s", workerIP)
+	// ── Response（SDK 自動 export connection_info 和 flag）───
+	// 模板資料見 connection.go；Ports 為每個具名 port 的 NodePort
+	resp.ConnectionInfo = svc.Spec.ApplyT(func(spec corev1.ServiceSpec) (string, error) {
+		nodePorts := map[string]int{}
+		for _, p := range spec.Ports {
+			if p.Name == nil || p.NodePort == nil {
+				return fmt.Sprintf("Service initializing... worker=%s", workerIP), nil
 			}
-			nodePort := *spec.Ports[0].NodePort
-			return formatConnectionInfo(connTpl, workerIP, int(nodePort))
-		}).(pulumi.StringOutput)
+			nodePorts[*p.Name] = int(*p.NodePort)
+		}
+		if len(nodePorts) == 0 {
+			return fmt.Sprintf("Service initializing... worker=%s", workerIP), nil
+		}
+		tm.rec.Mark("service", timing.Ready)
+		info, err := conn.Template.Render(conn.data(workerIP, connHostname, nodePorts, sid, nsName))
+		return info, tm.fail(err)
+	}).(pulumi.StringOutput)
 
-		resp.Flag = pulumi.String(flag).ToStringOutput()
+	// 具名 flag（flags）：name → flag，對應 CTFd 子題目；主要 flag 仍為 resp.Flag。
+	// 不使用 "flags"，避免與 SDK 輸出的 key 衝突
+	ctx.Export("named_flags", namedFlags)
 
-		return nil
-	})
-}
+	// 各階段時間：等 connection_info 產生（service ready）與 pod readiness 後才匯出
+	tm.export(ctx, resp.ConnectionInfo)
 
-// configOrEnv 從 additional config 讀取，fallback 到環境變數，再 fallback 到預設值
-func configOrEnv(req *sdk.Request, key, envKey, defaultVal string) string {
-	if v, ok := req.Config.Additional[key]; ok && v != "" {
-		return v
-	}
-	if envKey != "" {
-		if v := os.Getenv(envKey); v != "" {
-			return v
-		}
-	}
-	return defaultVal
-}
+	resp.Flag = flag
 
-// formatConnectionInfo 根據模板產生連線資訊
-// 支援 {ip} 和 {port} 佔位符，例如 "http://{ip}:{port}" → "http://1.2.3.4:8080"
-func formatConnectionInfo(tpl, ip string, port int) string {
-	r := strings.NewReplacer("{ip}", ip, "{port}", strconv.Itoa(port))
-	return r.Replace(tpl)
+	return nil
 }
 
 func envOrDefault(key, def string) string {
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/ctferio/scenarios/scenarioconfig"
+	"github.com/ctferio/scenarios/scenarioconfig/naming"
+)
+
+// instance 命名：Pod / Service / Namespace 名稱 ctf-<shortID>、Service selector 的 ctf-id、
+// DNS 記錄 <shortID>.<dns_zone> 都由此產生（hash 與長度見 scenarioconfig/naming）
+//
+// additional keys（通常以環境變數設為全域預設）：
+//
+//	instance_id_hash    md5（預設）/ sha256（CHALLENGE_INSTANCE_ID_HASH）
+//	instance_id_length  shortID 長度 8-32（預設 8，CHALLENGE_INSTANCE_ID_LENGTH）
+//	instance_id_scope   challenge（預設，hash 加入 challenge_id，沒有時用 image）/ none（舊版）
+//	                    （CHALLENGE_INSTANCE_ID_SCOPE）
+//
+// shortID 是 Pulumi 資源名稱的 prefix，改變設定會讓既有 stack 的資源改名重建：預設含題目範圍，
+// 升級前已有 instance 的部署需設定 instance_id_scope=none 保留舊版的 md5(identity)[:8]，
+// 在沒有存活 instance 時再移除（見 README「instance 命名」）。
+//
+// pulumi-kubernetes（server-side apply）遇到同名物件會直接接管，共用 namespace 撞名時兩個 instance
+// 互相覆蓋。因此建立資源前以 Kubernetes API 查詢同名的 Namespace / Pod / Service，
+// ctf-identity label 屬於其他 identity 時直接失敗（見 kubeapi.go；無法連線 API 時只 log warning）。
+
+// parseNaming 由 additional 建立 naming.Config
+func parseNaming(conf *scenarioconfig.Values, image string) naming.Config {
+	cfg := naming.Config{
+		Hash:   conf.String("instance_id_hash"),
+		Length: conf.Int("instance_id_length"),
+	}
+	if conf.String("instance_id_scope") == naming.ScopeChallenge {
+		cfg.Scope = conf.String("challenge_id")
+		if cfg.Scope == "" {
+			cfg.Scope = image
+		}
+	}
+	return cfg
+}
+
+// kubeObject 要檢查的 Kubernetes 物件
+type kubeObject struct {
+	Kind      string
+	Namespace string // cluster-scoped 物件為空
+	Name      string
+}
+
+func (o kubeObject) path() string {
+	switch o.Kind {
+	case "namespace":
+		return "/api/v1/namespaces/" + url.PathEscape(o.Name)
+	case "service":
+		return "/api/v1/namespaces/" + url.PathEscape(o.Namespace) + "/services/" + url.PathEscape(o.Name)
+	}
+	return "/api/v1/namespaces/" + url.PathEscape(o.Namespace) + "/pods/" + url.PathEscape(o.Name)
+}
+
+// ref namespace/name（cluster-scoped 物件只有 name）
+func (o kubeObject) ref() string {
+	if o.Namespace == "" {
+		return o.Name
+	}
+	return o.Namespace + "/" + o.Name
+}
+
+// checkNameOwners 任一物件已存在且不屬於此 identity 時回傳錯誤
+func checkNameOwners(ctx context.Context, newCluster func() (cluster, error), names naming.Config, identity string, objs ...kubeObject) error {
+	api, err := newCluster()
+	if errors.Is(err, errKubeAPIUnavailable) {
+		fmt.Printf("WARNING: skip instance name ownership check: %v\n", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, o := range objs {
+		labels, exists, err := api.labels(ctx, o.path())
+		if err != nil {
+			return fmt.Errorf("check %s %s ownership: %w", o.Kind, o.ref(), err)
+		}
+		if !exists {
+			continue
+		}
+		if err := names.CheckOwner(o.Kind, o.ref(), labels["ctf-identity"], identity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
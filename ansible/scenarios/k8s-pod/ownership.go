@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ctferio/scenarios/scenarioconfig"
+	"github.com/ctferio/scenarios/scenarioconfig/naming"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// scenarioVersion 由 Ansible build 時以 -ldflags "-X main.scenarioVersion=<src hash>" 注入
+var scenarioVersion = "dev"
+
+const scenarioName = "k8s-pod"
+
+// Ownership metadata：每個 Kubernetes 資源都標記擁有者，當 chall-manager state 與
+// 實際資源不一致時（例如 janitor 沒清掉的 Pod）仍能以 label selector 歸屬與清理：
+//
+//	kubectl get pods -n challenges -l ctf-challenge-id=<id>
+//
+// labels（可 select，值需符合 label 格式）：
+//
+//	managed-by / ctf-id / ctf-scenario / ctf-scenario-version /
+//	ctf-challenge-id / ctf-source-id / ctf-identity（sha256(identity) 前 16 hex）
+//
+// annotations（時間戳含 ":"，不能放在 label）：
+//
+//	ctf-created-at / ctf-expires-at（建立時間 + instance_timeout）
+type ownership struct {
+	labels      map[string]string
+	annotations map[string]string
+}
+
+func newOwnership(conf *scenarioconfig.Values, identity, sid string, now time.Time) *ownership {
+	o := &ownership{
+		labels: map[string]string{
+			"managed-by":           "chall-manager",
+			"ctf-id":               sid,
+			"ctf-scenario":         scenarioName,
+			"ctf-scenario-version": labelValue(scenarioVersion),
+			"ctf-identity":         naming.IdentityHash(identity),
+		},
+		annotations: map[string]string{
+			"ctf-created-at": now.UTC().Format(time.RFC3339),
+		},
+	}
+	if v := conf.String("challenge_id"); v != "" {
+		o.labels["ctf-challenge-id"] = labelValue(v)
+	}
+	if v := conf.String("source_id"); v != "" {
+		o.labels["ctf-source-id"] = labelValue(v)
+	}
+	if secs := conf.Int("instance_timeout"); secs > 0 {
+		o.annotations["ctf-expires-at"] = now.Add(time.Duration(secs) * time.Second).UTC().Format(time.RFC3339)
+	}
+	return o
+}
+
+// Labels 回傳 ownership labels，extra 會覆蓋同名 key（如 Pod 的 app label）
+func (o *ownership) Labels(extra map[string]string) pulumi.StringMap {
+	m := pulumi.StringMap{}
+	for k, v := range o.labels {
+		m[k] = pulumi.String(v)
+	}
+	for k, v := range extra {
+		m[k] = pulumi.String(v)
+	}
+	return m
+}
+
+// Annotations 回傳 ownership annotations，extra 會覆蓋同名 key（如 pulumi.com/skipAwait）
+func (o *ownership) Annotations(extra map[string]string) pulumi.StringMap {
+	m := pulumi.StringMap{}
+	for k, v := range o.annotations {
+		m[k] = pulumi.String(v)
+	}
+	for k, v := range extra {
+		m[k] = pulumi.String(v)
+	}
+	return m
+}
+
+// IgnoreTimestamps 讓時間戳只在建立時寫入（否則每次 pulumi up 都會產生 diff）
+func (o *ownership) IgnoreTimestamps() pulumi.ResourceOption {
+	return pulumi.IgnoreChanges([]string{
+		`metadata.annotations["ctf-created-at"]`,
+		`metadata.annotations["ctf-expires-at"]`,
+	})
+}
+
+// labelValue 轉成合法的 label value（英數、-_.，最長 63 字元，頭尾需為英數）
+func labelValue(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('-')
+		}
+	}
+	out := b.String()
+	if len(out) > 63 {
+		out = out[:63]
+	}
+	return strings.Trim(out, "-_.")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/ctfer-io/chall-manager/sdk"
+	"github.com/ctferio/scenarios/scenarioplan"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// plan 子命令：以 Pulumi mock runtime 離線執行 run，不需要 kubeconfig，也不建立任何資源（見 scenarioplan）
+//
+//	./main plan -additional challenge.yml [-identity team-1] [-set use_shared_namespace=false]
+//
+// 輸出 JSON：宣告的資源與 inputs、rendered.pod_spec、stack outputs 與 connection_info / flag。
+// run 以 planEnv 執行，不連線 Kubernetes API：名稱視為未被佔用，pod 視為在部署時間 Ready；
+// Service 的 NodePort 依宣告順序由 30000 起分配，未設定 K3S_WORKER_IPS 時 worker IP 使用文件保留網段（RFC 5737）的位址。
+
+// planWorkerIP 未設定 K3S_WORKER_IPS 時的 worker IP（TEST-NET-1）
+const planWorkerIP = "192.0.2.20"
+
+// planNodePortBase 第一個 NodePort
+const planNodePortBase = 30000
+
+func planCommand(args []string) int {
+	if os.Getenv("K3S_WORKER_IPS") == "" {
+		os.Setenv("K3S_WORKER_IPS", planWorkerIP)
+	}
+	mocks := scenarioplan.Mocks{Outputs: planOutputs, Render: planRender}
+	return scenarioplan.Command("k8s-pod", scenarioVersion, args, mocks, func(in scenarioplan.Input) scenarioplan.Body {
+		return func(ctx *pulumi.Context) (map[string]pulumi.Output, error) {
+			req := &sdk.Request{Ctx: ctx, Config: &sdk.Configuration{Identity: in.Identity, Additional: in.Additional}}
+			resp := &sdk.Response{}
+			if err := planEnv(in.Now).run(req, resp); err != nil {
+				return nil, err
+			}
+			return map[string]pulumi.Output{"connection_info": resp.ConnectionInfo, "flag": resp.Flag}, nil
+		}
+	})
+}
+
+// planEnv 不連線的 deployEnv；部署時間固定為 -now，輸出可重現
+func planEnv(now time.Time) deployEnv {
+	return deployEnv{
+		newCluster: func() (cluster, error) { return offlineCluster{now: now}, nil },
+		now:        func() time.Time { return now },
+	}
+}
+
+// offlineCluster plan 使用的 cluster：不連線，物件都不存在
+type offlineCluster struct {
+	now time.Time
+}
+
+func (offlineCluster) labels(context.Context, string) (map[string]string, bool, error) {
+	return nil, false, nil
+}
+
+// podReadyAt pod 在部署時間即 Ready（timings 不等待）
+func (c offlineCluster) podReadyAt(context.Context, string) (time.Time, bool, error) {
+	return c.now, true, nil
+}
+
+// planOutputs Service 的 NodePort（connection_info 由此產生）
+func planOutputs(r scenarioplan.Resource) map[string]any {
+	if r.Type != "kubernetes:core/v1:Service" {
+		return nil
+	}
+	spec, ok := r.Inputs["spec"].(map[string]any)
+	if !ok {
+		return nil
+	}
+	ports, _ := spec["ports"].([]any)
+	out := make([]any, len(ports))
+	for i, p := range ports {
+		port := map[string]any{}
+		if m, ok := p.(map[string]any); ok {
+			for k, v := range m {
+				port[k] = v
+			}
+		}
+		port["nodePort"] = float64(planNodePortBase + i)
+		out[i] = port
+	}
+	withPorts := map[string]any{}
+	for k, v := range spec {
+		withPorts[k] = v
+	}
+	withPorts["ports"] = out
+	return map[string]any{"spec": withPorts}
+}
+
+// planRender Pod 的 spec（container、env、volume）
+func planRender(r scenarioplan.Resource) map[string]any {
+	if r.Type != "kubernetes:core/v1:Pod" {
+		return nil
+	}
+	return map[string]any{"pod_spec": r.Inputs["spec"]}
+}
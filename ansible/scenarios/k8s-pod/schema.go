@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+
+	sc "github.com/ctferio/scenarios/scenarioconfig"
+	"github.com/ctferio/scenarios/scenarioconfig/conninfo"
+	"github.com/ctferio/scenarios/scenarioconfig/flaggen"
+	"github.com/ctferio/scenarios/scenarioconfig/naming"
+)
+
+// quantityPattern Kubernetes resource quantity（"100m"、"0.5"、"512Mi"、"1G"）
+var quantityPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?(m|[kMGTPE]|[KMGTPE]i)?$`)
+
+// usernamePattern credentials_user 的格式（Linux 使用者名稱）
+var usernamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+// configSchema k8s-pod 支援的 additional keys（型別、預設值、環境變數 fallback、限制）。
+// 建立任何資源前以此驗證 additional，所有錯誤與未知的 key 一次回報。
+var configSchema = &sc.Schema{
+	Scenario: scenarioName,
+	Keys: []sc.Key{
+		// ── 題目 ──
+		{Name: "image", Kind: sc.String, Env: "CHALLENGE_IMAGE", Default: "ubuntu:22.04",
+			Description: "靶機 container image（不含 registry 時自動加上 CHALLENGE_REGISTRY）"},
+		{Name: "port", Kind: sc.Int, Env: "CHALLENGE_PORT", Default: "22", Min: sc.Ptr(1), Max: sc.Ptr(65535),
+			Description: "靶機服務 port"},
+		{Name: "command", Kind: sc.String, Env: "CHALLENGE_COMMAND",
+			Description: "覆蓋 entrypoint（逗號分隔，如 sleep,infinity）"},
+		{Name: "base_flag", Kind: sc.String, Env: "CHALLENGE_BASE_FLAG", Default: "default_base_flag",
+			Description: "flag 衍生基礎值（依 flag_strategy 加工）"},
+		{Name: "flag_prefix", Kind: sc.String, Env: "CHALLENGE_FLAG_PREFIX", Default: "CTF",
+			Description: "flag 前綴"},
+		{Name: "flag_strategy", Kind: sc.String, Env: "CHALLENGE_FLAG_STRATEGY", Default: flaggen.Variate, Enum: flaggen.Names,
+			Description: "flag 產生策略（variate / static / hmac / random / format，見 flagstrategy.go）"},
+		{Name: "flag_format", Kind: sc.String, Validate: flaggen.ValidateFormat,
+			Description: "flag_strategy=format 的 Go text/template（如 {{.Prefix}}{ {{leet .Base}}_{{hash 6}} }）"},
+		{Name: "flags", Kind: sc.String, Validate: validateFlags,
+			Description: "多個具名 flag（user=/flag/user.txt mode=0400, root env=ROOT_FLAG），掛載為檔案或放進 env，stack output named_flags"},
+		{Name: "connection_info", Kind: sc.String, Default: "nc {ip} {port}", Validate: conninfo.Validate(),
+			Description: "連線資訊模板（Go text/template，相容 {ip} {port} 佔位符，見 connection.go）"},
+		{Name: "ports", Kind: sc.String, Validate: conninfo.ValidatePorts,
+			Description: "額外的具名 port（name=port 逗號分隔，如 web=80,debug=9000），各自建立 NodePort"},
+		{Name: "credentials_user", Kind: sc.String, Pattern: usernamePattern,
+			Description: "產生 per-instance 帳密的使用者名稱（CTF_USERNAME / CTF_PASSWORD）"},
+
+		// ── 資源限制 ──
+		{Name: "cpu_request", Kind: sc.String, Default: "100m", Pattern: quantityPattern,
+			Description: "CPU request"},
+		{Name: "cpu_limit", Kind: sc.String, Default: "500m", Pattern: quantityPattern,
+			Description: "CPU limit"},
+		{Name: "memory_request", Kind: sc.String, Default: "128Mi", Pattern: quantityPattern,
+			Description: "Memory request"},
+		{Name: "memory_limit", Kind: sc.String, Default: "512Mi", Pattern: quantityPattern,
+			Description: "Memory limit"},
+
+		// ── Namespace / DNS ──
+		{Name: "use_shared_namespace", Kind: sc.Bool, Default: "true",
+			Description: "使用共用 namespace（false 時每位玩家建立 ctf-<shortID>）"},
+		{Name: "shared_namespace", Kind: sc.String, Default: "challenges",
+			Description: "共用 namespace 名稱（由 Ansible k3s role 預建）"},
+		{Name: "dns_backend", Kind: sc.String, Env: "CHALLENGE_DNS_BACKEND", Enum: []string{dnsBackendRFC2136},
+			Description: "per-instance DNS 記錄 backend（經 ExternalDNS）"},
+		{Name: "dns_zone", Kind: sc.String, Env: "CHALLENGE_DNS_ZONE",
+			Description: "DNS 記錄所在 zone"},
+		{Name: "dns_ttl", Kind: sc.Int, Env: "CHALLENGE_DNS_TTL", Default: strconv.Itoa(defaultDNSTTL), Min: sc.Ptr(1),
+			Description: "DNS 記錄 TTL 秒數"},
+
+		// ── Readiness / timings（timing.go）──
+		{Name: "readiness_timeout", Kind: sc.Duration, Env: "CHALLENGE_READINESS_TIMEOUT", Default: "0",
+			Description: "等待 pod Ready 的上限（0 不等待，只記錄當下狀態到 timings）"},
+
+		// ── 命名（naming.go）──
+		{Name: "instance_id_hash", Kind: sc.String, Env: "CHALLENGE_INSTANCE_ID_HASH", Default: naming.DefaultHash, Enum: naming.Hashes,
+			Description: "shortID（資源名稱 ctf-<shortID>、Service selector）的 hash"},
+		{Name: "instance_id_length", Kind: sc.Int, Env: "CHALLENGE_INSTANCE_ID_LENGTH", Default: strconv.Itoa(naming.DefaultLength),
+			Min: sc.Ptr(naming.MinLength), Max: sc.Ptr(naming.MaxLength),
+			Description: "shortID 長度（hex 字元）"},
+		{Name: "instance_id_scope", Kind: sc.String, Env: "CHALLENGE_INSTANCE_ID_SCOPE", Default: naming.DefaultScope, Enum: naming.Scopes,
+			Description: "challenge：shortID 加入 challenge_id（沒有時用 image）；none：只依 identity（升級前既有 stack 的舊名稱）"},
+
+		// ── Ownership（registration script 注入）──
+		{Name: "challenge_id", Kind: sc.String,
+			Description: "題目識別"},
+		{Name: "source_id", Kind: sc.String,
+			Description: "玩家 / 隊伍識別"},
+		{Name: "instance_timeout", Kind: sc.Int, Min: sc.Ptr(0),
+			Description: "instance 存活秒數"},
+	},
+	Constraints: []func(*sc.Values) error{
+		func(v *sc.Values) error {
+			if v.String("flag_strategy") == flaggen.Format && v.String("flag_format") == "" {
+				return fmt.Errorf("flag_strategy=format requires flag_format")
+			}
+			return nil
+		},
+		func(v *sc.Values) error {
+			if v.String("dns_backend") != "" && v.String("dns_zone") == "" {
+				return fmt.Errorf("dns_backend=%s requires dns_zone", v.String("dns_backend"))
+			}
+			return nil
+		},
+	},
+}
+
+// schemaCommand 實作 schema 子命令：把 configSchema 以 JSON Schema 輸出到 stdout，
+// 供 registration tooling 以實際部署的版本驗證 challenge.yml 的 additional
+func schemaCommand() int {
+	if err := configSchema.WriteJSONSchema(os.Stdout, scenarioVersion); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
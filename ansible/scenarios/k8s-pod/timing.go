@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ctferio/scenarios/scenarioconfig"
+	"github.com/ctferio/scenarios/scenarioconfig/naming"
+	"github.com/ctferio/scenarios/scenarioconfig/timing"
+	"github.com/ctferio/scenarios/scenariotrace"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// 各階段的時間（見 scenarioconfig/timing），stack output "timings"，每個時間點另以一行 JSON 寫到 log：
+//
+//	namespace / pod / service
+//	            requested：依賴的輸入都已解析；created：API server 接受（skipAwait，不等 Running）
+//	service     ready：所有 NodePort 已分配（connection_info 可產生）
+//	pod         ready：Ready condition 的轉換時間（Kubernetes 記錄，秒精度）
+//	readiness   readiness_timeout > 0 時等待 pod Ready（requested 開始等待，ready 通過；逾時只 log warning）
+//
+// readiness_timeout=0（預設）不延後部署：service 建立後只讀一次 pod 狀態，尚未 Ready 時沒有 pod 的 ready。
+// preview 不寫 log；plan 不查詢 Kubernetes API（pod 視為在部署時間 Ready，見 plan.go）。
+// 啟用 trace（見 trace.go）時每個階段也是一個 span，每次讀取 pod 狀態是 readiness.pod span，log 行附帶 trace_id。
+
+// podReadyPollInterval 等待 pod Ready 的輪詢間隔
+const podReadyPollInterval = 1 * time.Second
+
+// timings 以 Pulumi output 的解析時間標記各階段
+type timings struct {
+	rec  *timing.Recorder
+	tr   *scenariotrace.Deployment
+	deps []pulumi.Output
+	// newCluster 讀取 pod Ready 時間的 Kubernetes API（見 kubeapi.go）
+	newCluster func() (cluster, error)
+}
+
+func newTimings(ctx *pulumi.Context, conf *scenarioconfig.Values, identity string, tr *scenariotrace.Deployment, env deployEnv) *timings {
+	var out io.Writer = os.Stdout
+	if ctx.DryRun() {
+		out = nil
+	}
+	fields := map[string]string{
+		"scenario":      scenarioName,
+		"challenge_id":  conf.String("challenge_id"),
+		"identity_hash": naming.IdentityHash(identity),
+	}
+	if tr != nil {
+		fields["trace_id"] = tr.TraceID()
+	}
+	t := &timings{rec: timing.New(env.now, out, fields), tr: tr, newCluster: env.newCluster}
+	if tr != nil {
+		t.rec.Observe(tr.Observe)
+	}
+	return t
+}
+
+// track 標記資源的 requested（after 全部解析時，沒有 after 時立即）與 created（ID 已知時）
+func (t *timings) track(phase string, res pulumi.CustomResource, after ...pulumi.Output) {
+	if len(after) == 0 {
+		t.rec.Mark(phase, timing.Requested)
+	} else {
+		t.deps = append(t.deps, pulumi.All(outputsAny(after)...).ApplyT(func([]any) int {
+			t.rec.Mark(phase, timing.Requested)
+			return 0
+		}))
+	}
+	t.deps = append(t.deps, res.ID().ApplyT(func(id pulumi.ID) pulumi.ID {
+		t.rec.Mark(phase, timing.Created)
+		return id
+	}))
+}
+
+// podReady 在 after 解析後記錄 pod 的 ready（見上方說明）
+func (t *timings) podReady(ctx *pulumi.Context, pod kubeObject, timeout time.Duration, after ...pulumi.Output) {
+	t.deps = append(t.deps, pulumi.All(outputsAny(after)...).ApplyTWithContext(ctx.Context(), func(c context.Context, _ []any) int {
+		t.waitPodReady(c, pod, timeout)
+		return 0
+	}))
+}
+
+func (t *timings) waitPodReady(ctx context.Context, pod kubeObject, timeout time.Duration) {
+	api, err := t.newCluster()
+	if err != nil {
+		fmt.Printf("WARNING: skip pod readiness: %v\n", err)
+		return
+	}
+	if timeout > 0 {
+		t.rec.Mark("readiness", timing.Requested)
+	}
+	// 每次讀取是 readiness 階段（timeout=0 時為 root）下的 readiness.pod span
+	ctx = t.tr.Context(ctx, "readiness")
+	deadline := time.Now().Add(timeout)
+	for {
+		end := scenariotrace.Step(ctx, "readiness.pod")
+		at, ready, err := api.podReadyAt(ctx, pod.path())
+		end(err)
+		if err != nil {
+			fmt.Printf("WARNING: pod %s readiness: %v\n", pod.ref(), err)
+			return
+		}
+		if ready {
+			t.rec.MarkAt("pod", timing.Ready, at)
+			if timeout > 0 {
+				t.rec.Mark("readiness", timing.Ready)
+			}
+			return
+		}
+		if !time.Now().Before(deadline) {
+			if timeout > 0 {
+				fmt.Printf("WARNING: pod %s not Ready after %s\n", pod.ref(), timeout)
+			}
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(podReadyPollInterval):
+		}
+	}
+}
+
+// export 在所有標記與 done 解析後匯出 stack output "timings"，寫 summary log 並送出 trace
+func (t *timings) export(ctx *pulumi.Context, done ...pulumi.Output) {
+	deps := append(t.deps, done...)
+	ctx.Export("timings", pulumi.All(outputsAny(deps)...).ApplyT(func([]any) map[string]any {
+		s := t.rec.Summary()
+		finishTrace(t.tr, nil)
+		return s.Map()
+	}).(pulumi.MapOutput))
+}
+
+// fail err 不為 nil 時以 err 結束 trace，回傳 err（apply 失敗時 Pulumi 直接結束 process，不會再匯出 timings）
+func (t *timings) fail(err error) error {
+	if err != nil {
+		finishTrace(t.tr, err)
+	}
+	return err
+}
+
+func outputsAny(outs []pulumi.Output) []any {
+	all := make([]any, len(outs))
+	for i, o := range outs {
+		all[i] = o
+	}
+	return all
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ctferio/scenarios/scenarioconfig"
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/flavors"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+)
+
+// 開機 fallback：並發量高時 compute node RAM 不足，Nova 排程失敗讓 VM 進入 ERROR
+// （"No valid host was found"）。設定備援 flavor / AZ 後，scenario 改為直接呼叫 Nova API 開機，
+// 偵測到 ERROR 就刪除該 instance 並換下一個候選重試，全部失敗才讓部署失敗。
+// 開機成功的 instance 以 pulumi.Import 納入 stack，destroy 時照常刪除。
+//
+// 開機參數（cloud_init、主 flavor / AZ、開機磁碟、port、scheduler hints）的 hash 記在 metadata ctf-boot-spec。
+// 重複執行 pulumi up 時同名 ACTIVE instance 的 hash 相同才沿用，否則刪除後重新開機，
+// 回傳的新 ID 讓 Pulumi 以新 instance 取代 stack 中的舊 instance（與 provider 建立時變更這些參數的行為一致）。
+// preview 只查詢可沿用的 instance，不開機（要開機時 instance ID 為 unknown）。
+//
+// additional keys：
+//
+//	fallback_flavors             備援 flavor，逗號分隔（依序嘗試，主 flavor 為 flavor）
+//	fallback_availability_zones  備援 AZ，逗號分隔（主 AZ 為 availability_zone）
+//	boot_attempts                總嘗試次數上限（預設為候選組合數；較大時循環重試候選）
+//	boot_retry_delay             每次失敗後等待時間（預設 5s）
+//	boot_timeout                 單次開機等待 ACTIVE 的上限（預設 10m）
+//
+// 候選順序：flavor 為外層、AZ 為內層，即先在所有 AZ 嘗試主 flavor，再換備援 flavor。
+// 未設定任何 fallback 且 boot_attempts <= 1 時維持原本由 provider 建立 instance 的流程。
+const (
+	defaultBootRetryDelay = 5 * time.Second
+	defaultBootTimeout    = 10 * time.Minute
+	// bootDeleteTimeout 等待失敗 instance 刪除（釋放 port）的上限
+	bootDeleteTimeout = 2 * time.Minute
+	// bootMicroversion 開機時帶 tags 需要 compute microversion 2.52+，BDM volume_type 需要 2.67+
+	bootMicroversion = "2.67"
+)
+
+// bootSpecMetadataKey instance metadata 中開機參數 hash 的 key
+const bootSpecMetadataKey = "ctf-boot-spec"
+
+// adoptedInstanceIgnore 以 Import 納入的 instance 只忽略 provider 無法從 Nova 讀回的欄位，
+// 否則 import 時程式輸入與讀回的狀態不一致會失敗。這些欄位的變更改由 ctf-boot-spec 比對（見 bootSpec.hash）；
+// flavor / AZ 以實際開機的值作為輸入，image 與讀回的值相同，metadata / tags 由 ownership 忽略。
+var adoptedInstanceIgnore = []string{
+	"blockDevices",   // Nova 不回傳 BDM 參數
+	"configDrive",    // provider 不讀回
+	"forceDelete",    // 只影響 destroy，沒有對應的 Nova 狀態
+	"networks",       // 讀回的 network 不含建立時指定的 port
+	"schedulerHints", // Nova 不回傳
+	"userData",       // Nova 不回傳
+}
+
+// bootCandidate 一組開機候選
+type bootCandidate struct {
+	Flavor           string
+	AvailabilityZone string
+}
+
+func (c bootCandidate) String() string {
+	az := c.AvailabilityZone
+	if az == "" {
+		az = "(default)"
+	}
+	return fmt.Sprintf("flavor=%s az=%s", c.Flavor, az)
+}
+
+// bootPolicy 由 additional 解析出的 fallback 設定
+type bootPolicy struct {
+	Candidates []bootCandidate
+	Attempts   int
+	RetryDelay time.Duration
+	Timeout    time.Duration
+}
+
+func parseBootPolicy(conf *scenarioconfig.Values, flavor, availabilityZone string) bootPolicy {
+	flavorList := append([]string{flavor}, conf.List("fallback_flavors")...)
+	azList := append([]string{availabilityZone}, conf.List("fallback_availability_zones")...)
+
+	p := bootPolicy{
+		RetryDelay: conf.Duration("boot_retry_delay"),
+		Timeout:    conf.Duration("boot_timeout"),
+	}
+	seen := map[bootCandidate]bool{}
+	for _, f := range flavorList {
+		for _, az := range azList {
+			c := bootCandidate{Flavor: f, AvailabilityZone: az}
+			if !seen[c] {
+				seen[c] = true
+				p.Candidates = append(p.Candidates, c)
+			}
+		}
+	}
+
+	// boot_attempts 未設定時每個候選試一次
+	p.Attempts = len(p.Candidates)
+	if conf.IsSet("boot_attempts") {
+		p.Attempts = conf.Int("boot_attempts")
+	}
+	return p
+}
+
+// Enabled 是否改由 scenario 直接開機（有備援候選或要求重試）
+func (p bootPolicy) Enabled() bool {
+	return len(p.Candidates) > 1 || p.Attempts > 1
+}
+
+// bootSpec 直接呼叫 Nova API 開機所需的參數（與 compute.InstanceArgs 對應）
+type bootSpec struct {
+	Name      string
+	Device    bootDevice
+	PortID    string
+	UserData  string
+	Metadata  map[string]string
+	Tags      []string
+	Hints     servers.SchedulerHintOptsBuilder
+	DiagLines int
+}
+
+// hash 需要重新開機的參數（ctf-boot-spec）。候選只取主 flavor / AZ：
+// 調整備援候選不影響已開機的 instance。
+func (s bootSpec) hash(p bootPolicy) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "user_data=%s\nport=%s\ndevice=%+v\nhints=%+v\nprimary=%+v\n",
+		s.UserData, s.PortID, s.Device, s.Hints, p.Candidates[0])
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// metadata 開機時寫入的 metadata（ownership 加上 ctf-boot-spec）
+func (s bootSpec) metadata(hash string) map[string]string {
+	md := make(map[string]string, len(s.Metadata)+1)
+	for k, v := range s.Metadata {
+		md[k] = v
+	}
+	md[bootSpecMetadataKey] = hash
+	return md
+}
+
+// bootResult 開機（或沿用）的 instance。Flavor / AvailabilityZone 為實際的值，
+// 作為 instance 的輸入，與 import 時 provider 讀回的狀態一致。
+type bootResult struct {
+	ID               string
+	Flavor           string
+	AvailabilityZone string
+}
+
+// adoptable 同名 instance 是否可沿用：ACTIVE 且開機參數未變。
+// 沒有 ctf-boot-spec 的 instance（加入比對前開機）視為未變，避免升級後全部重新開機。
+func adoptable(s *servers.Server, hash string) bool {
+	if s.Status != "ACTIVE" {
+		return false
+	}
+	h, ok := s.Metadata[bootSpecMetadataKey]
+	return !ok || h == hash
+}
+
+// previewBoot preview 時查詢可沿用的 instance（不開機）；pulumi up 會重新開機時回傳 nil
+func (c *cloudAPI) previewBoot(ctx context.Context, spec bootSpec, p bootPolicy) (*bootResult, error) {
+	s, err := c.findServer(ctx, spec.Name)
+	if err != nil || s == nil {
+		return nil, err
+	}
+	if !adoptable(s, spec.hash(p)) {
+		fmt.Printf("instance %s (%s) will be replaced: status %s or boot spec changed\n", spec.Name, s.ID, s.Status)
+		return nil, nil
+	}
+	r, err := c.bootResultOf(ctx, s)
+	return &r, err
+}
+
+// bootWithFallback 依序嘗試候選直到 instance 變成 ACTIVE。
+// 同名 instance 已是 ACTIVE 且開機參數未變時（重複執行 pulumi up）直接沿用。
+// instance 建立後任何步驟失敗都會刪除該 instance，不留下 stack 之外的 VM。
+func (c *cloudAPI) bootWithFallback(ctx context.Context, spec bootSpec, p bootPolicy) (bootResult, error) {
+	hash := spec.hash(p)
+	if s, err := c.findServer(ctx, spec.Name); err != nil {
+		return bootResult{}, err
+	} else if s != nil {
+		if adoptable(s, hash) {
+			return c.bootResultOf(ctx, s)
+		}
+		// 上一次執行殘留（ERROR / 中斷的 BUILD），或開機參數已變更：清掉再重新開機
+		if s.Status == "ACTIVE" {
+			fmt.Printf("instance %s boot spec changed, replacing %s\n", spec.Name, s.ID)
+		}
+		if err := c.deleteServer(ctx, s.ID); err != nil {
+			return bootResult{}, err
+		}
+	}
+	spec.Metadata = spec.metadata(hash)
+
+	var failures []string
+	for i := 0; i < p.Attempts; i++ {
+		cand := p.Candidates[i%len(p.Candidates)]
+		s, err := c.bootOnce(ctx, spec, cand, p.Timeout)
+		if err == nil {
+			if i > 0 {
+				fmt.Printf("instance %s booted on attempt %d/%d (%s)\n", spec.Name, i+1, p.Attempts, cand)
+			}
+			r, err := c.bootResultOf(ctx, s)
+			if err != nil {
+				if derr := c.deleteServer(ctx, s.ID); derr != nil {
+					err = fmt.Errorf("%w (cleanup: %v)", err, derr)
+				}
+				return bootResult{}, fmt.Errorf("boot %s: %w", spec.Name, err)
+			}
+			return r, nil
+		}
+		failures = append(failures, fmt.Sprintf("attempt %d/%d (%s): %v", i+1, p.Attempts, cand, err))
+		fmt.Printf("WARNING: instance %s boot attempt %d/%d (%s) failed, trying next candidate\n",
+			spec.Name, i+1, p.Attempts, cand)
+
+		if s != nil {
+			if err := c.deleteServer(ctx, s.ID); err != nil {
+				failures = append(failures, err.Error())
+				break
+			}
+		}
+		if i+1 < p.Attempts {
+			select {
+			case <-ctx.Done():
+				return bootResult{}, fmt.Errorf("boot %s: %w\n%s", spec.Name, ctx.Err(), strings.Join(failures, "\n"))
+			case <-time.After(p.RetryDelay):
+			}
+		}
+	}
+	return bootResult{}, fmt.Errorf("boot %s: all %d attempts failed\n%s", spec.Name, p.Attempts, strings.Join(failures, "\n"))
+}
+
+// bootResultOf 讀出 instance 實際的 flavor 名稱與 AZ
+func (c *cloudAPI) bootResultOf(ctx context.Context, s *servers.Server) (bootResult, error) {
+	r := bootResult{ID: s.ID, AvailabilityZone: s.AvailabilityZone}
+	// compute microversion 2.47+ 直接回傳 flavor 名稱，較舊的只有 flavor ID
+	if name, ok := s.Flavor["original_name"].(string); ok && name != "" {
+		r.Flavor = name
+		return r, nil
+	}
+	id, _ := s.Flavor["id"].(string)
+	client, err := c.computeClient(ctx)
+	if err != nil {
+		return bootResult{}, err
+	}
+	f, err := flavors.Get(ctx, client, id).Extract()
+	if err != nil {
+		return bootResult{}, fmt.Errorf("get flavor %s of server %s: %w", id, s.ID, err)
+	}
+	r.Flavor = f.Name
+	return r, nil
+}
+
+// bootOnce 以單一候選開機並等待 ACTIVE，回傳 ACTIVE 的 instance。
+// 失敗但 instance 已建立時同時回傳該 instance（至少有 ID）以便清理。
+func (c *cloudAPI) bootOnce(ctx context.Context, spec bootSpec, cand bootCandidate, timeout time.Duration) (*servers.Server, error) {
+	client, err := c.computeClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	flavorID, err := c.flavorID(ctx, cand.Flavor)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := servers.CreateOpts{
+		Name:             spec.Name,
+		FlavorRef:        flavorID,
+		UserData:         []byte(spec.UserData),
+		ConfigDrive:      gophercloud.Enabled,
+		AvailabilityZone: cand.AvailabilityZone,
+		Networks:         []servers.Network{{Port: spec.PortID}},
+		Metadata:         spec.Metadata,
+		Tags:             spec.Tags,
+	}
+	// clone 模式每次嘗試各自 clone（失敗的 instance 刪除時 volume 隨 delete_on_termination 一併刪除）
+	cloneID := ""
+	if spec.Device.IsClone() {
+		if cloneID, err = c.cloneVolume(ctx, spec.Name+"-vol", spec.Device, cand.AvailabilityZone, spec.Metadata); err != nil {
+			if cloneID != "" {
+				_ = c.deleteVolume(ctx, cloneID)
+			}
+			return nil, err
+		}
+	}
+	spec.Device.apply(&opts, cloneID)
+
+	sc := *client
+	sc.Microversion = bootMicroversion
+	s, err := servers.Create(ctx, &sc, opts, spec.Hints).Extract()
+	if err != nil {
+		if cloneID != "" {
+			_ = c.deleteVolume(ctx, cloneID)
+		}
+		return nil, fmt.Errorf("create server: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(buildPollInterval)
+	defer ticker.Stop()
+	for {
+		cur, err := servers.Get(ctx, &sc, s.ID).Extract()
+		switch {
+		case err != nil && ctx.Err() == nil:
+			return s, fmt.Errorf("get server %s: %w", s.ID, err)
+		case err == nil && cur.Status == "ACTIVE":
+			return cur, nil
+		case err == nil && cur.Status == "ERROR":
+			return s, fmt.Errorf("entered ERROR\n%s", c.diagnose(ctx, s.ID, spec.DiagLines))
+		}
+		select {
+		case <-ctx.Done():
+			return s, fmt.Errorf("not ACTIVE after %s\n%s", timeout, c.diagnose(ctx, s.ID, spec.DiagLines))
+		case <-ticker.C:
+		}
+	}
+}
+
+// flavorID 將 flavor 名稱轉成 ID（也接受直接給 ID）
+func (c *cloudAPI) flavorID(ctx context.Context, nameOrID string) (string, error) {
+	client, err := c.computeClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	pages, err := flavors.ListDetail(client, flavors.ListOpts{}).AllPages(ctx)
+	if err != nil {
+		return "", fmt.Errorf("list flavors: %w", err)
+	}
+	list, err := flavors.ExtractFlavors(pages)
+	if err != nil {
+		return "", fmt.Errorf("list flavors: %w", err)
+	}
+	for _, f := range list {
+		if f.Name == nameOrID || f.ID == nameOrID {
+			return f.ID, nil
+		}
+	}
+	return "", fmt.Errorf("flavor %q not found", nameOrID)
+}
+
+// deleteServer 刪除 instance 並等待消失（port 釋放後才能給下一個候選使用）。
+// 不跟隨 parent 的取消：部署中斷或逾時時仍要清掉已建立的 instance。
+func (c *cloudAPI) deleteServer(parent context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(parent), bootDeleteTimeout)
+	defer cancel()
+	client, err := c.computeClient(ctx)
+	if err != nil {
+		return err
+	}
+	if err := servers.Delete(ctx, client, id).ExtractErr(); err != nil {
+		if gophercloud.ResponseCodeIs(err, http.StatusNotFound) {
+			return nil
+		}
+		return fmt.Errorf("delete server %s: %w", id, err)
+	}
+	ticker := time.NewTicker(buildPollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := servers.Get(ctx, client, id).Extract(); gophercloud.ResponseCodeIs(err, http.StatusNotFound) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("delete server %s: still present after %s", id, bootDeleteTimeout)
+		case <-ticker.C:
+		}
+	}
+}
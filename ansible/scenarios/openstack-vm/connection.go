@@ -0,0 +1,147 @@
+package main
+
+import (
+	"time"
+
+	"github.com/ctferio/scenarios/scenarioconfig"
+	"github.com/ctferio/scenarios/scenarioconfig/conninfo"
+)
+
+// connection_info：Go text/template，資料模型與輔助函式見 scenarioconfig/conninfo
+//
+// additional keys：
+//
+//	connection_info   模板（預設 "nc {ip} {port}"；舊 {ip} {port} {console} 佔位符仍可用）
+//	ports             額外的具名 port，"name=port" 逗號分隔（如 "web=80,admin=8443"）。
+//	                  per-player SG 各自開放；port_forward 模式各自分配外部 port；
+//	                  模板以 {{.Ports.web}} 取得對外 port（loadbalancer 模式不支援）
+//	credentials_user  產生 per-instance 帳密：cloud-init 建立此使用者並允許 SSH 密碼登入，
+//	                  模板以 {{.Credentials.Username}} {{.Credentials.Password}} 取得
+//	                  （cloud_init 模板也可用 {{.Credentials.Password}}）
+//
+// openstack-vm 特有欄位：
+//
+//	{{.Extra.console}}    console URL（console_access 未設定時為空，見 console.go）
+//	{{.Extra.console_expires}}  console URL 到期時間（"2006-01-02 15:04 UTC"，未提供 console 時為空）
+//	{{.Extra.server_id}}  Nova instance ID
+//	{{.Extra.fixed_ip}}   VM 內網 IP
+//
+// loadbalancer 模式沒有對外 IP：.IP 與 .Hostname 都是 <shortID>.<lb_domain>。
+// 模板在建立任何資源前以範例資料試渲染，引用不存在的欄位 / port 直接讓 deployment 失敗。
+
+// consoleExtra console URL 在 Extra 中的 key，同時是舊版 {console} 佔位符的名稱
+const (
+	consoleExtra        = "console"
+	consoleExpiresExtra = "console_expires"
+)
+
+// connectionConfig 由 additional 解析出的 connection_info 設定
+type connectionConfig struct {
+	Template    *conninfo.Template
+	Ports       []conninfo.NamedPort
+	Credentials conninfo.Credentials
+	ExpiresAt   time.Time
+	// Console 是否提供 console URL；模板沒有引用時附加在最後一行
+	Console bool
+}
+
+// parseConnectionConfig tpl 為已套用 loadbalancer / console 預設值的模板
+func parseConnectionConfig(conf *scenarioconfig.Values, tpl, identity string, console consoleConfig, now time.Time) (connectionConfig, error) {
+	cfg := connectionConfig{
+		Credentials: conninfo.NewCredentials(conf.String("credentials_user"), identity, conf.String("base_flag")),
+		Console:     console.Enabled(),
+	}
+	if secs := conf.Int("instance_timeout"); secs > 0 {
+		cfg.ExpiresAt = now.Add(time.Duration(secs) * time.Second)
+	}
+	var err error
+	// 格式已由 schema 驗證
+	if cfg.Ports, err = conninfo.ParsePorts(conf.String("ports")); err != nil {
+		return cfg, err
+	}
+	if cfg.Template, err = conninfo.Parse(tpl, consoleExtra); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Template.Check(cfg.data("192.0.2.1", "", cfg.InternalPorts(conf.Int("port")), "", connectionExtra{})); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// InternalPorts 回傳 port 名稱 → VM 上的 port（主要 port 名為 challenge）
+func (c connectionConfig) InternalPorts(challengePort int) map[string]int {
+	ports := map[string]int{conninfo.PrimaryPort: challengePort}
+	for _, p := range c.Ports {
+		ports[p.Name] = p.Port
+	}
+	return ports
+}
+
+// connectionExtra openstack-vm 特有的模板欄位
+type connectionExtra struct {
+	ConsoleURL       string
+	ConsoleExpiresAt time.Time
+	ServerID         string
+	FixedIP          string
+}
+
+// data 組出模板資料；ports 為 port 名稱 → 對外 port
+func (c connectionConfig) data(ip, hostname string, ports map[string]int, shortID string, extra connectionExtra) conninfo.Data {
+	return conninfo.Data{
+		IP:          ip,
+		Hostname:    hostname,
+		Port:        ports[conninfo.PrimaryPort],
+		Ports:       ports,
+		ShortID:     shortID,
+		Credentials: c.Credentials,
+		ExpiresAt:   c.ExpiresAt,
+		Extra: map[string]string{
+			consoleExtra:        extra.ConsoleURL,
+			consoleExpiresExtra: formatConsoleExpires(extra.ConsoleExpiresAt),
+			"server_id":         extra.ServerID,
+			"fixed_ip":          extra.FixedIP,
+		},
+	}
+}
+
+// Render 渲染 connection_info；提供 console 但模板沒有引用時，把 URL 與到期時間附加在最後一行
+func (c connectionConfig) Render(d conninfo.Data) (string, error) {
+	info, err := c.Template.Render(d)
+	if err != nil || !c.Console {
+		return info, err
+	}
+	// 以不同的 console 值再渲染一次，結果相同代表模板沒有引用 console
+	probe := d
+	probe.Extra = map[string]string{}
+	for k, v := range d.Extra {
+		probe.Extra[k] = v
+	}
+	probe.Extra[consoleExtra] = d.Extra[consoleExtra] + "-probe"
+	other, err := c.Template.Render(probe)
+	if err != nil {
+		return "", err
+	}
+	if other == info {
+		info += "\nConsole: " + d.Extra[consoleExtra] + " (valid until " + d.Extra[consoleExpiresExtra] + ")"
+	}
+	return info, nil
+}
+
+// addCredentialsUser 加入 credentials_user（保留 image 預設使用者與出題者的 users），並允許 SSH 密碼登入
+func (c *cloudConfig) addCredentialsUser(cred conninfo.Credentials) {
+	users, _ := c.Extra["users"].([]any)
+	if users == nil {
+		users = []any{"default"}
+	}
+	users = append(users, map[string]any{
+		"name":              cred.Username,
+		"shell":             "/bin/bash",
+		"lock_passwd":       false,
+		"plain_text_passwd": cred.Password,
+	})
+	if c.Extra == nil {
+		c.Extra = map[string]any{}
+	}
+	c.Extra["users"] = users
+	c.Extra["ssh_pwauth"] = true
+}
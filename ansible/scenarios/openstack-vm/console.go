@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ctferio/scenarios/scenarioconfig"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/remoteconsoles"
+)
+
+// 玩家 console 存取：GUI 鑑識、開機階段操作等題目需要的是 VM console 而不是網路 port。
+// 設定 console_access 後，scenario 在 instance 就緒後向 Nova 要一個 remote console URL，
+// 放進 connection_info（{{.Extra.console}} 或舊 {console} 佔位符；模板沒有引用時附加在最後一行，
+// 未設定 connection_info 時只回傳 URL，見 connection.go）。
+//
+// additional keys：
+//
+//	console_access  novnc / spice-html5；未設定時不提供 console
+//
+// 全域設定（環境變數，出題者無法覆蓋）：
+//
+//	CHALLENGE_CONSOLE_CHALLENGES  允許使用 console_access 的 challenge_id，逗號分隔（"*" 為全部）；
+//	                              未設定時一律拒絕
+//	CHALLENGE_CONSOLE_TOKEN_TTL   Nova [consoleauth] token_ttl（預設 600s），用來計算 URL 的到期時間
+//
+// console URL 內含 Nova consoleauth token，到期前需要建立連線（已建立的連線不受影響），
+// 到期時間以 {{.Extra.console_expires}} 放進 connection_info（模板沒有引用時附加在 URL 之後）。
+// 玩家無法自行換發，到期後由管理者重新取得：
+//   - 每次 stack update（pulumi up）都會重新要求 URL 並更新 connection_info
+//   - scenario binary 的 console-url 子命令可直接換發：./main console-url [-type novnc] <instance 名稱或 ID>
+const (
+	consoleNoVNC = "novnc"
+	consoleSPICE = "spice-html5"
+
+	// consoleMicroversion remote-consoles API 需要 compute microversion 2.6+
+	consoleMicroversion = "2.6"
+	// consoleAllowEnv 允許 console_access 的 challenge 清單
+	consoleAllowEnv = "CHALLENGE_CONSOLE_CHALLENGES"
+	// consoleTokenTTLEnv Nova consoleauth token 有效期（與 Nova 設定一致）
+	consoleTokenTTLEnv     = "CHALLENGE_CONSOLE_TOKEN_TTL"
+	defaultConsoleTokenTTL = 600 * time.Second
+	// consolePlaceholder 未設定 connection_info 時的模板（console URL 與到期時間）
+	consolePlaceholder = "{console}\n(console link valid until {{.Extra.console_expires}}; ask an admin for a new one after that)"
+	// consoleExpiresFormat console_expires 的格式（UTC）
+	consoleExpiresFormat = "2006-01-02 15:04 MST"
+)
+
+// consoleConfig 由 additional 解析出的 console 設定
+type consoleConfig struct {
+	Type string
+	// TokenTTL console URL 的有效期（CHALLENGE_CONSOLE_TOKEN_TTL）
+	TokenTTL time.Duration
+}
+
+func parseConsoleConfig(conf *scenarioconfig.Values) (consoleConfig, error) {
+	cfg := consoleConfig{Type: conf.String("console_access")}
+	if cfg.Type == "" {
+		return cfg, nil
+	}
+	challengeID := conf.String("challenge_id")
+	if !consoleAllowed(os.Getenv(consoleAllowEnv), challengeID) {
+		return cfg, fmt.Errorf("console_access is not allowed for challenge %q (add it to %s)", challengeID, consoleAllowEnv)
+	}
+	var err error
+	cfg.TokenTTL, err = consoleTokenTTL()
+	return cfg, err
+}
+
+// consoleTokenTTL 讀取 CHALLENGE_CONSOLE_TOKEN_TTL：秒數（與 Nova token_ttl 相同）或 Go duration（如 10m）
+func consoleTokenTTL() (time.Duration, error) {
+	raw := os.Getenv(consoleTokenTTLEnv)
+	if raw == "" {
+		return defaultConsoleTokenTTL, nil
+	}
+	if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+		return time.Duration(n) * time.Second, nil
+	}
+	if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("%s: %q is not a positive duration (want seconds or e.g. 10m)", consoleTokenTTLEnv, raw)
+}
+
+// ExpiresAt 回傳在 issued 取得的 console URL 的到期時間
+func (c consoleConfig) ExpiresAt(issued time.Time) time.Time {
+	return issued.Add(c.TokenTTL)
+}
+
+// formatConsoleExpires 以 UTC 格式化 console URL 到期時間（zero 時為空字串）
+func formatConsoleExpires(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(consoleExpiresFormat)
+}
+
+// Enabled 是否提供 console URL
+func (c consoleConfig) Enabled() bool {
+	return c.Type != ""
+}
+
+// consoleAllowed 檢查 challengeID 是否在允許清單中
+func consoleAllowed(allowList, challengeID string) bool {
+	for _, v := range scenarioconfig.SplitList(allowList) {
+		if v == "*" || (challengeID != "" && v == challengeID) {
+			return true
+		}
+	}
+	return false
+}
+
+// consoleProtocol 依 console type 決定 protocol
+func consoleProtocol(typ string) (remoteconsoles.ConsoleProtocol, error) {
+	switch typ {
+	case consoleNoVNC:
+		return remoteconsoles.ConsoleProtocolVNC, nil
+	case consoleSPICE:
+		return remoteconsoles.ConsoleProtocolSPICE, nil
+	}
+	return "", fmt.Errorf("invalid console_access %q (want novnc or spice-html5)", typ)
+}
+
+// remoteConsoleURL 向 Nova 要求新的 remote console URL
+func (c *cloudAPI) remoteConsoleURL(ctx context.Context, serverID, typ string) (string, error) {
+	protocol, err := consoleProtocol(typ)
+	if err != nil {
+		return "", err
+	}
+	client, err := c.computeClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	sc := *client
+	sc.Microversion = consoleMicroversion
+	rc, err := remoteconsoles.Create(ctx, &sc, serverID, remoteconsoles.CreateOpts{
+		Protocol: protocol,
+		Type:     remoteconsoles.ConsoleType(typ),
+	}).Extract()
+	if err != nil {
+		return "", fmt.Errorf("get %s console of %s: %w", typ, serverID, err)
+	}
+	return rc.URL, nil
+}
+
+// consoleURLCommand 實作 console-url 子命令：以 OS_* 環境變數認證，換發 instance 的 console URL
+func consoleURLCommand(args []string) int {
+	fs := flag.NewFlagSet("console-url", flag.ContinueOnError)
+	typ := fs.String("type", consoleNoVNC, "console type (novnc or spice-html5)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: main console-url [-type novnc|spice-html5] <instance name or ID>")
+		return 2
+	}
+	target := fs.Arg(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	auth, err := resolveAuth()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	api := newCloudAPI(auth)
+
+	// 以名稱（ctf-<shortID>）查詢，找不到時視為 ID
+	serverID := target
+	if s, err := api.findServer(ctx, target); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	} else if s != nil {
+		serverID = s.ID
+	}
+	url, err := api.remoteConsoleURL(ctx, serverID, *typ)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(url)
+	// 到期時間印到 stderr，stdout 只有 URL（方便腳本使用）
+	if ttl, err := consoleTokenTTL(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	} else {
+		fmt.Fprintf(os.Stderr, "valid until %s\n", formatConsoleExpires(time.Now().Add(ttl)))
+	}
+	return 0
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+)
+
+// 部署失敗診斷：VM 進入 ERROR 或 readiness 逾時時，向 Nova 取得 fault 與 console log 尾端，
+// 附在回傳的 error 與 stack output "diagnostics" 中，讓 "No valid host"、kernel panic
+// 等問題可直接從 chall-manager log 看到，而不是只有 Pulumi 的通用錯誤。
+//
+// additional keys：
+//
+//	diagnostics_console_lines  附帶的 console log 行數（預設 50，0 表示不取 console log）
+const (
+	defaultDiagnosticsLines = 50
+
+	// buildPollInterval 監看 instance 建立狀態的起始輪詢間隔，每次加倍直到 buildPollMaxInterval
+	buildPollInterval    = 3 * time.Second
+	buildPollMaxInterval = 30 * time.Second
+	// buildAppearTimeout instance 在此時間內未出現在 Nova 時停止監看（建立請求本身失敗）
+	buildAppearTimeout = 2 * time.Minute
+	// buildWatchTimeout 監看上限（與 provider 預設 create timeout 一致）
+	buildWatchTimeout = 30 * time.Minute
+	// diagnoseTimeout 收集診斷資訊本身的上限，避免在已經失敗的部署上再卡住
+	diagnoseTimeout = 15 * time.Second
+)
+
+// serverDiagnostics 單一 instance 的診斷資訊（收集過程的錯誤也一併記錄，不中斷）
+type serverDiagnostics struct {
+	ServerID     string
+	Status       string
+	FaultCode    int
+	FaultMessage string
+	FaultDetails string
+	Console      string
+	Errors       []string
+}
+
+func (d serverDiagnostics) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "instance %s status=%s", d.ServerID, d.Status)
+	if d.FaultMessage != "" {
+		fmt.Fprintf(&b, "\nnova fault (%d): %s", d.FaultCode, d.FaultMessage)
+		if d.FaultDetails != "" {
+			fmt.Fprintf(&b, "\n%s", truncate(d.FaultDetails, 2048))
+		}
+	}
+	for _, e := range d.Errors {
+		fmt.Fprintf(&b, "\n(diagnostics: %s)", e)
+	}
+	if d.Console != "" {
+		fmt.Fprintf(&b, "\nconsole log (last %d lines):\n%s", strings.Count(d.Console, "\n")+1, d.Console)
+	}
+	return b.String()
+}
+
+// diagnose 收集 instance 的狀態、Nova fault 與 console log 尾端（best effort）
+func (c *cloudAPI) diagnose(parent context.Context, serverID string, lines int) serverDiagnostics {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(parent), diagnoseTimeout)
+	defer cancel()
+
+	d := serverDiagnostics{ServerID: serverID, Status: "UNKNOWN"}
+	client, err := c.computeClient(ctx)
+	if err != nil {
+		d.Errors = append(d.Errors, err.Error())
+		return d
+	}
+	s, err := servers.Get(ctx, client, serverID).Extract()
+	if err != nil {
+		d.Errors = append(d.Errors, fmt.Sprintf("get server: %v", err))
+	} else {
+		d.Status = s.Status
+		d.FaultCode = s.Fault.Code
+		d.FaultMessage = s.Fault.Message
+		d.FaultDetails = s.Fault.Details
+	}
+	if lines > 0 {
+		out, err := c.consoleOutput(ctx, serverID, lines)
+		if err != nil {
+			d.Errors = append(d.Errors, err.Error())
+		} else {
+			d.Console = tailLines(out, lines)
+		}
+	}
+	return d
+}
+
+// findServer 以完整名稱查詢 instance，同名多台時取最新建立者（replace 期間新舊並存）
+func (c *cloudAPI) findServer(ctx context.Context, name string) (*servers.Server, error) {
+	client, err := c.computeClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pages, err := servers.List(client, servers.ListOpts{
+		Name: "^" + regexp.QuoteMeta(name) + "$",
+	}).AllPages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list servers: %w", err)
+	}
+	list, err := servers.ExtractServers(pages)
+	if err != nil {
+		return nil, fmt.Errorf("list servers: %w", err)
+	}
+	var latest *servers.Server
+	for i := range list {
+		if latest == nil || list[i].Created.After(latest.Created) {
+			latest = &list[i]
+		}
+	}
+	return latest, nil
+}
+
+// getServer 以 ID 查詢 instance，不存在時回傳 nil
+func (c *cloudAPI) getServer(ctx context.Context, id string) (*servers.Server, error) {
+	client, err := c.computeClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s, err := servers.Get(ctx, client, id).Extract()
+	if gophercloud.ResponseCodeIs(err, http.StatusNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get server %s: %w", id, err)
+	}
+	return s, nil
+}
+
+// watchBuild 在 provider 建立 instance 的同時監看 Nova 狀態。
+// provider 遇到 ERROR 只回傳通用錯誤，這裡改回傳帶 fault 與 console log 的 error。
+// instance 變成 ACTIVE、在 buildAppearTimeout 內沒出現、被刪除或 ctx 結束時回傳 nil。
+//
+// instance 出現前以名稱查詢（server list），取得 ID 後改以 servers.Get 查詢單一 instance；
+// 輪詢間隔從 buildPollInterval 指數成長到 buildPollMaxInterval，開機較久時不會持續對 Nova 發出請求。
+func watchBuild(ctx context.Context, api cloud, name string, lines int) error {
+	ctx, cancel := context.WithTimeout(ctx, buildWatchTimeout)
+	defer cancel()
+
+	appearBy := time.Now().Add(buildAppearTimeout)
+	interval := buildPollInterval
+	var id string
+	for {
+		var s *servers.Server
+		var err error
+		if id == "" {
+			s, err = api.findServer(ctx, name)
+		} else {
+			s, err = api.getServer(ctx, id)
+		}
+		switch {
+		case err != nil:
+			// 監看本身失敗不影響部署
+			fmt.Printf("WARNING: watch instance %s: %v\n", name, err)
+			return nil
+		case s == nil:
+			// 已取得 ID 後查不到：instance 被刪除（例如 provider 建立失敗後清除）
+			if id != "" || time.Now().After(appearBy) {
+				return nil
+			}
+		case s.Status == "ACTIVE":
+			return nil
+		case s.Status == "ERROR":
+			d := api.diagnose(ctx, s.ID, lines)
+			return fmt.Errorf("instance %s entered ERROR\n%s", name, d)
+		default:
+			id = s.ID
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		interval = min(interval*2, buildPollMaxInterval)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/ctferio/scenarios/scenarioconfig"
+	"github.com/gophercloud/gophercloud/v2/openstack/dns/v2/zones"
+	"github.com/pulumi/pulumi-openstack/sdk/v3/go/openstack/dns"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// 每個 instance 的 DNS 記錄：connection_info 預設只有 IP，依賴 Host header 或憑證的題目無法使用，
+// 玩家也不好記。設定 dns_backend 後為每個 instance 建立 <shortID>.<dns_zone> 的 A/AAAA 記錄，
+// 指向對外位址（FIP / 共用 FIP / 內網 IP），connection_info 的 {ip} 改為 hostname。
+//
+// additional keys：
+//
+//	dns_backend  designate（OpenStack DNS）；未設定時不建立記錄
+//	dns_zone     記錄所在 zone（例："ctf.example.com"，需已在 Designate 建立）
+//	dns_zone_id  zone UUID（選填，提供時跳過以名稱查詢 zone）
+//	dns_ttl      記錄 TTL 秒數（預設 60）
+//
+// 記錄屬於玩家的 stack，destroy 時刪除。readiness 仍直接檢查 IP，不受 DNS 傳播影響。
+// expose_mode=loadbalancer 已由 wildcard DNS 提供 hostname，不另建記錄。
+const (
+	dnsBackendDesignate = "designate"
+
+	defaultDNSTTL = 60
+)
+
+// dnsConfig 由 additional 解析出的 DNS 設定
+type dnsConfig struct {
+	Backend string
+	Zone    string
+	ZoneID  string
+	TTL     int
+}
+
+func parseDNSConfig(conf *scenarioconfig.Values) dnsConfig {
+	return dnsConfig{
+		Backend: conf.String("dns_backend"),
+		Zone:    strings.TrimSuffix(conf.String("dns_zone"), "."),
+		ZoneID:  conf.String("dns_zone_id"),
+		TTL:     conf.Int("dns_ttl"),
+	}
+}
+
+// Enabled 是否建立 DNS 記錄
+func (c dnsConfig) Enabled() bool {
+	return c.Backend != ""
+}
+
+// Hostname 回傳玩家的 hostname（不含結尾的 "."）
+func (c dnsConfig) Hostname(shortID string) string {
+	return shortID + "." + c.Zone
+}
+
+// dnsRecord 一筆要建立的記錄
+type dnsRecord struct {
+	// FQDN 不含結尾的 "."
+	FQDN        string
+	Address     pulumi.StringInput
+	TTL         int
+	Description string
+}
+
+// dnsBackend 建立 DNS 記錄的實作；記錄必須是 stack 內的資源，destroy 時才會一併刪除
+type dnsBackend interface {
+	Register(ctx *pulumi.Context, name string, rec dnsRecord, opts ...pulumi.ResourceOption) error
+}
+
+// newDNSBackend 依 dns_backend 建立 backend（designate 需要 zone ID，未提供時以名稱查詢）
+func newDNSBackend(ctx context.Context, cfg dnsConfig, api cloud) (dnsBackend, error) {
+	switch cfg.Backend {
+	case dnsBackendDesignate:
+		zoneID := cfg.ZoneID
+		if zoneID == "" {
+			id, err := api.dnsZoneID(ctx, cfg.Zone)
+			if err != nil {
+				return nil, err
+			}
+			zoneID = id
+		}
+		return designateBackend{ZoneID: zoneID}, nil
+	}
+	return nil, fmt.Errorf("unsupported dns_backend %q", cfg.Backend)
+}
+
+// designateBackend 以 Designate record set 建立記錄
+type designateBackend struct {
+	ZoneID string
+}
+
+func (b designateBackend) Register(ctx *pulumi.Context, name string, rec dnsRecord, opts ...pulumi.ResourceOption) error {
+	_, err := dns.NewRecordSet(ctx, name, &dns.RecordSetArgs{
+		ZoneId:      pulumi.String(b.ZoneID),
+		Name:        pulumi.String(rec.FQDN + "."),
+		Type:        rec.Address.ToStringOutput().ApplyT(dnsRecordType).(pulumi.StringOutput),
+		Records:     pulumi.StringArray{rec.Address},
+		Ttl:         pulumi.Int(rec.TTL),
+		Description: pulumi.String(rec.Description),
+	}, opts...)
+	return err
+}
+
+// dnsRecordType 依位址決定記錄類型（A / AAAA）
+func dnsRecordType(addr string) (string, error) {
+	ip := net.ParseIP(addr)
+	switch {
+	case ip == nil:
+		return "", fmt.Errorf("dns record target %q is not an IP address", addr)
+	case ip.To4() != nil:
+		return "A", nil
+	default:
+		return "AAAA", nil
+	}
+}
+
+// dnsZoneID 以名稱查詢 Designate zone ID
+func (c *cloudAPI) dnsZoneID(ctx context.Context, zone string) (string, error) {
+	client, err := c.dnsClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	pages, err := zones.List(client, zones.ListOpts{Name: zone + "."}).AllPages(ctx)
+	if err != nil {
+		return "", fmt.Errorf("find dns zone %s: %w", zone, err)
+	}
+	list, err := zones.ExtractZones(pages)
+	if err != nil {
+		return "", fmt.Errorf("find dns zone %s: %w", zone, err)
+	}
+	if len(list) == 0 {
+		return "", fmt.Errorf("dns zone %s not found", zone)
+	}
+	return list[0].ID, nil
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ctferio/scenarios/scenarioconfig"
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/extensions/attributestags"
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/extensions/layer3/floatingips"
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/extensions/layer3/portforwarding"
+)
+
+// 對外曝露方式。每台 VM 一個 floating IP 會受平台 FIP quota（60）限制，
+// port_forward 模式讓多台 VM 共用一個 FIP，以 Neutron floating IP port forwarding
+// 把共用 FIP 上分配到的外部 port 轉到玩家 VM 的 fixed IP + 題目 port。
+//
+// additional keys：
+//
+//	expose_mode         fip（每台一個 FIP）/ fixed（只用內網 IP）/ port_forward（共用 FIP）/
+//	                    loadbalancer（共用 Octavia listener + L7 host rule，見 lb.go）
+//	                    未設定時沿用 use_fip（true → fip，false → fixed）
+//	port_forward_fip    port_forward 模式共用的 floating IP 位址（需掛在 VM 網路的 router 上）
+//	port_forward_range  外部 port 分配範圍（預設 20000-39999）
+//
+// port forwarding 由 scenario 透過 API 建立而非 Pulumi：Neutron 對 (FIP, external port, protocol)
+// 保證唯一，建立請求本身就是原子的分配動作，衝突時換下一個 port 重試，並發部署不會拿到同一個 port。
+// forwarding 綁在 stack 管理的 port 上，destroy 刪除 port 時 Neutron 會一併刪除。
+// forwarding 不在 Pulumi state 中，ports 移除具名 port 後，下一次 pulumi up 以 releasePortForwards
+// 刪除該 port 在共用 FIP 上多餘的 forwarding。使用過 port_forward 的 port 帶有 portForwardTag，
+// expose_mode 改為其他模式時只有帶此標記的 port 才清理（見 leavePortForward）。
+const (
+	exposeFIP          = "fip"
+	exposeFixed        = "fixed"
+	exposePortForward  = "port_forward"
+	exposeLoadBalancer = "loadbalancer"
+
+	defaultPortForwardRange = "20000-39999"
+	// portForwardMaxConflicts 分配時容忍的衝突次數（超過代表範圍幾乎用盡或並發過高）
+	portForwardMaxConflicts = 20
+
+	// portForwardTag port 在共用 FIP 上有 forwarding 的標記（"ctf-port-forward=<FIP 位址>"）。
+	// port_forward 模式建立 port 時寫入 tags，既有 port 改為 port_forward 時以 API 補上
+	// （port tags 以 IgnoreChanges 忽略，Pulumi 不會移除 API 補上的 tag）
+	portForwardTag = "ctf-port-forward"
+)
+
+// errFloatingIPNotFound 共用 FIP 已不存在（forwarding 隨 FIP 一併刪除）
+var errFloatingIPNotFound = errors.New("floating ip not found")
+
+// exposeConfig 由 additional 解析出的曝露設定
+type exposeConfig struct {
+	Mode      string
+	SharedFIP string
+	PortMin   int
+	PortMax   int
+	LB        loadBalancerConfig
+}
+
+func parseExposeConfig(conf *scenarioconfig.Values) exposeConfig {
+	cfg := exposeConfig{Mode: conf.String("expose_mode")}
+	if cfg.Mode == "" {
+		cfg.Mode = exposeFixed
+		if conf.Bool("use_fip") {
+			cfg.Mode = exposeFIP
+		}
+	}
+	switch cfg.Mode {
+	case exposeLoadBalancer:
+		cfg.LB = parseLoadBalancerConfig(conf)
+	case exposePortForward:
+		cfg.SharedFIP = conf.String("port_forward_fip")
+		cfg.PortMin, cfg.PortMax, _ = parsePortRange(conf.String("port_forward_range"))
+	}
+	return cfg
+}
+
+// parsePortRange 解析 port_forward_range（"min-max"，1-65535 內）
+func parsePortRange(raw string) (int, int, error) {
+	lo, hi, ok := strings.Cut(raw, "-")
+	portMin, errLo := strconv.Atoi(strings.TrimSpace(lo))
+	portMax, errHi := strconv.Atoi(strings.TrimSpace(hi))
+	if !ok || errLo != nil || errHi != nil || portMin < 1 || portMax > 65535 || portMin > portMax {
+		return 0, 0, fmt.Errorf("invalid port_forward_range %q (want min-max within 1-65535)", raw)
+	}
+	return portMin, portMax, nil
+}
+
+// portForwardRequest 一次 port forwarding 分配
+type portForwardRequest struct {
+	InternalPortID string
+	InternalIP     string
+	InternalPort   int
+	Description    string
+	// Key 決定在範圍內開始搜尋的位置（identity），同一玩家重新部署時傾向拿到相同 port
+	Key string
+	// DryRun（preview）只查詢既有 forwarding，不建立
+	DryRun bool
+}
+
+// allocatePortForward 在共用 FIP 上分配外部 port，回傳外部 port。
+// 同一個 internal port 已有 forwarding 時（重複執行 pulumi up）直接沿用。
+func (c *cloudAPI) allocatePortForward(ctx context.Context, cfg exposeConfig, r portForwardRequest) (int, error) {
+	client, err := c.networkClient(ctx)
+	if err != nil {
+		return 0, err
+	}
+	fipID, err := c.floatingIPID(ctx, cfg.SharedFIP)
+	if err != nil {
+		return 0, err
+	}
+
+	pages, err := portforwarding.List(client, portforwarding.ListOpts{}, fipID).AllPages(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("list port forwardings of %s: %w", cfg.SharedFIP, err)
+	}
+	existing, err := portforwarding.ExtractPortForwardings(pages)
+	if err != nil {
+		return 0, fmt.Errorf("list port forwardings of %s: %w", cfg.SharedFIP, err)
+	}
+	used := map[int]bool{}
+	for _, pf := range existing {
+		if pf.InternalPortID == r.InternalPortID && pf.InternalPort == r.InternalPort && pf.Protocol == "tcp" {
+			return pf.ExternalPort, nil
+		}
+		if pf.Protocol == "tcp" {
+			used[pf.ExternalPort] = true
+		}
+	}
+
+	if r.DryRun {
+		return 0, nil
+	}
+
+	size := cfg.PortMax - cfg.PortMin + 1
+	start := portForwardStart(cfg, r.Key)
+	conflicts := 0
+	for i := 0; i < size; i++ {
+		ext := cfg.PortMin + (start+i)%size
+		if used[ext] {
+			continue
+		}
+		_, err := portforwarding.Create(ctx, client, fipID, portforwarding.CreateOpts{
+			Description:       r.Description,
+			InternalPortID:    r.InternalPortID,
+			InternalIPAddress: r.InternalIP,
+			InternalPort:      r.InternalPort,
+			ExternalPort:      ext,
+			Protocol:          "tcp",
+		}).Extract()
+		if err == nil {
+			return ext, nil
+		}
+		if !isPortForwardConflict(err) {
+			return 0, fmt.Errorf("create port forwarding %s:%d: %w", cfg.SharedFIP, ext, err)
+		}
+		// 其他部署同時拿走這個 port，換下一個
+		conflicts++
+		if conflicts >= portForwardMaxConflicts {
+			return 0, fmt.Errorf("create port forwarding on %s: %d conflicts, range %d-%d nearly exhausted",
+				cfg.SharedFIP, conflicts, cfg.PortMin, cfg.PortMax)
+		}
+	}
+	return 0, fmt.Errorf("no free external port on %s in range %d-%d", cfg.SharedFIP, cfg.PortMin, cfg.PortMax)
+}
+
+// releasePortForwards 刪除共用 FIP 上指向 portID、internal port 不在 keep 中的 forwarding
+// （expose_mode 離開 port_forward 時 keep 為空，全部刪除）。dryRun（preview）只列出會刪除的 forwarding。
+func (c *cloudAPI) releasePortForwards(ctx context.Context, sharedFIP, portID string, keep map[int]bool, dryRun bool) error {
+	client, err := c.networkClient(ctx)
+	if err != nil {
+		return err
+	}
+	fipID, err := c.floatingIPID(ctx, sharedFIP)
+	if err != nil {
+		return err
+	}
+	pages, err := portforwarding.List(client, portforwarding.ListOpts{InternalPortID: portID}, fipID).AllPages(ctx)
+	if err != nil {
+		return fmt.Errorf("list port forwardings of %s: %w", sharedFIP, err)
+	}
+	existing, err := portforwarding.ExtractPortForwardings(pages)
+	if err != nil {
+		return fmt.Errorf("list port forwardings of %s: %w", sharedFIP, err)
+	}
+	for _, pf := range existing {
+		if pf.InternalPortID != portID || keep[pf.InternalPort] {
+			continue
+		}
+		if dryRun {
+			fmt.Printf("stale port forwarding %s:%d -> %s:%d will be deleted\n",
+				sharedFIP, pf.ExternalPort, pf.InternalIPAddress, pf.InternalPort)
+			continue
+		}
+		if err := portforwarding.Delete(ctx, client, fipID, pf.ID).ExtractErr(); err != nil &&
+			!gophercloud.ResponseCodeIs(err, http.StatusNotFound) {
+			return fmt.Errorf("delete port forwarding %s:%d: %w", sharedFIP, pf.ExternalPort, err)
+		}
+		fmt.Printf("deleted stale port forwarding %s:%d -> %s:%d\n",
+			sharedFIP, pf.ExternalPort, pf.InternalIPAddress, pf.InternalPort)
+	}
+	return nil
+}
+
+// portForwardMarker 回傳共用 FIP 的 port_forward 標記 tag
+func portForwardMarker(sharedFIP string) string {
+	return portForwardTag + "=" + sharedFIP
+}
+
+// portForwardFIPs 回傳 tags 中 port_forward 標記記錄的共用 FIP
+func portForwardFIPs(tags []string) []string {
+	var fips []string
+	for _, t := range tags {
+		if v, ok := strings.CutPrefix(t, portForwardTag+"="); ok && v != "" {
+			fips = append(fips, v)
+		}
+	}
+	return fips
+}
+
+// markPortForward 確保 port 帶有 sharedFIP 的標記；stateTags（Pulumi state 中的 port tags）已有時不呼叫 API
+func markPortForward(ctx context.Context, api cloud, portID, sharedFIP string, stateTags []string, dryRun bool) error {
+	marker := portForwardMarker(sharedFIP)
+	if dryRun || slices.Contains(stateTags, marker) {
+		return nil
+	}
+	return api.tagPort(ctx, portID, marker)
+}
+
+// leavePortForward 在非 port_forward 模式刪除 port 在共用 FIP 上留下的 forwarding，並移除標記。
+// port 在此次部署建立（stateTags 含 createdTag）時不可能有 forwarding，不呼叫 API；
+// 既有 port 以 API 讀取目前的 tags（state 不含 API 補上的標記），只清理標記記錄的共用 FIP。
+// 共用 FIP 已被刪除時 forwarding 也已不存在，只移除標記。
+func leavePortForward(ctx context.Context, api cloud, portID, createdTag string, stateTags []string, dryRun bool) error {
+	if slices.Contains(stateTags, createdTag) {
+		return nil
+	}
+	tags, err := api.portTags(ctx, portID)
+	if err != nil {
+		return err
+	}
+	for _, fip := range portForwardFIPs(tags) {
+		err := api.releasePortForwards(ctx, fip, portID, nil, dryRun)
+		if err != nil && !errors.Is(err, errFloatingIPNotFound) {
+			return err
+		}
+		if dryRun {
+			continue
+		}
+		if err := api.untagPort(ctx, portID, portForwardMarker(fip)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// portTags 取得 port 目前的 tags
+func (c *cloudAPI) portTags(ctx context.Context, portID string) ([]string, error) {
+	client, err := c.networkClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := attributestags.List(ctx, client, "ports", portID).Extract()
+	if err != nil {
+		return nil, fmt.Errorf("list tags of port %s: %w", portID, err)
+	}
+	return tags, nil
+}
+
+// tagPort 在 port 加上 tag（已存在時 Neutron 不會重複加入）
+func (c *cloudAPI) tagPort(ctx context.Context, portID, tag string) error {
+	client, err := c.networkClient(ctx)
+	if err != nil {
+		return err
+	}
+	if err := attributestags.Add(ctx, client, "ports", portID, tag).ExtractErr(); err != nil {
+		return fmt.Errorf("tag port %s: %w", portID, err)
+	}
+	return nil
+}
+
+// untagPort 移除 port 的 tag（不存在時視為成功）
+func (c *cloudAPI) untagPort(ctx context.Context, portID, tag string) error {
+	client, err := c.networkClient(ctx)
+	if err != nil {
+		return err
+	}
+	if err := attributestags.Delete(ctx, client, "ports", portID, tag).ExtractErr(); err != nil &&
+		!gophercloud.ResponseCodeIs(err, http.StatusNotFound) {
+		return fmt.Errorf("untag port %s: %w", portID, err)
+	}
+	return nil
+}
+
+// portForwardStart 依 key 決定在 port 範圍內開始搜尋的位置（0 起算）
+func portForwardStart(cfg exposeConfig, key string) int {
+	h := sha256.Sum256([]byte(key))
+	return int(binary.BigEndian.Uint32(h[:4]) % uint32(cfg.PortMax-cfg.PortMin+1))
+}
+
+// floatingIPID 以位址查詢 floating IP ID
+func (c *cloudAPI) floatingIPID(ctx context.Context, address string) (string, error) {
+	client, err := c.networkClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	pages, err := floatingips.List(client, floatingips.ListOpts{FloatingIP: address}).AllPages(ctx)
+	if err != nil {
+		return "", fmt.Errorf("find floating ip %s: %w", address, err)
+	}
+	fips, err := floatingips.ExtractFloatingIPs(pages)
+	if err != nil {
+		return "", fmt.Errorf("find floating ip %s: %w", address, err)
+	}
+	if len(fips) == 0 {
+		return "", fmt.Errorf("%w: %s", errFloatingIPNotFound, address)
+	}
+	return fips[0].ID, nil
+}
+
+// isPortForwardConflict 判斷是否為外部 port 已被使用：Neutron 對 (FIP, external port, protocol) 重複回 409。
+// 其他錯誤（包含 400）不重試，直接讓部署失敗。
+func isPortForwardConflict(err error) bool {
+	return gophercloud.ResponseCodeIs(err, http.StatusConflict)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/v2"
+)
+
+func TestPortForwardStart(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max int
+	}{
+		{name: "default range", min: 20000, max: 39999},
+		{name: "single port", min: 31337, max: 31337},
+		{name: "small range", min: 1, max: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := exposeConfig{PortMin: tt.min, PortMax: tt.max}
+			for _, key := range []string{"", "1a2b3c4d", "1a2b3c4d/web"} {
+				got := portForwardStart(cfg, key)
+				if got < 0 || got > tt.max-tt.min {
+					t.Errorf("start(%q) = %d, want within [0, %d]", key, got, tt.max-tt.min)
+				}
+				if again := portForwardStart(cfg, key); again != got {
+					t.Errorf("start(%q) not stable: %d then %d", key, got, again)
+				}
+			}
+		})
+	}
+}
+
+func TestIsPortForwardConflict(t *testing.T) {
+	status := func(code int, body string) error {
+		return gophercloud.ErrUnexpectedResponseCode{Expected: []int{http.StatusCreated}, Actual: code, Body: []byte(body)}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "conflict", err: status(http.StatusConflict, ""), want: true},
+		{name: "wrapped conflict", err: fmt.Errorf("create: %w", status(http.StatusConflict, "")), want: true},
+		{name: "bad request mentioning duplicate", err: status(http.StatusBadRequest, "duplicate entry already exists")},
+		{name: "not found", err: status(http.StatusNotFound, "")},
+		{name: "other error", err: errors.New("409 conflict")},
+		{name: "nil", err: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPortForwardConflict(tt.err); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// portTagCloud 記錄 leavePortForward 對 port tags 與 forwarding 的操作
+type portTagCloud struct {
+	offlineCloud
+	tags       []string
+	missingFIP string
+	calls      []string
+}
+
+func (c *portTagCloud) portTags(context.Context, string) ([]string, error) {
+	c.calls = append(c.calls, "tags")
+	return c.tags, nil
+}
+
+func (c *portTagCloud) releasePortForwards(_ context.Context, sharedFIP, _ string, _ map[int]bool, dryRun bool) error {
+	c.calls = append(c.calls, fmt.Sprintf("release %s dry=%v", sharedFIP, dryRun))
+	if sharedFIP == c.missingFIP {
+		return fmt.Errorf("%w: %s", errFloatingIPNotFound, sharedFIP)
+	}
+	return nil
+}
+
+func (c *portTagCloud) untagPort(_ context.Context, _, tag string) error {
+	c.calls = append(c.calls, "untag "+tag)
+	return nil
+}
+
+func TestLeavePortForward(t *testing.T) {
+	const created = "ctf-created-at=2000-01-01T00:00:00Z"
+	tests := []struct {
+		name       string
+		stateTags  []string
+		tags       []string
+		missingFIP string
+		dryRun     bool
+		want       []string
+	}{
+		{
+			name:      "created in this deployment",
+			stateTags: []string{"ctf-managed-by=chall-manager", created},
+		},
+		{
+			name:      "never used port_forward",
+			stateTags: []string{"ctf-created-at=1999-01-01T00:00:00Z"},
+			tags:      []string{"ctf-created-at=1999-01-01T00:00:00Z"},
+			want:      []string{"tags"},
+		},
+		{
+			name: "marked port",
+			tags: []string{portForwardMarker("203.0.113.10")},
+			want: []string{"tags", "release 203.0.113.10 dry=false", "untag ctf-port-forward=203.0.113.10"},
+		},
+		{
+			name:   "preview only lists",
+			tags:   []string{portForwardMarker("203.0.113.10")},
+			dryRun: true,
+			want:   []string{"tags", "release 203.0.113.10 dry=true"},
+		},
+		{
+			name:       "shared fip already deleted",
+			tags:       []string{portForwardMarker("203.0.113.10")},
+			missingFIP: "203.0.113.10",
+			want:       []string{"tags", "release 203.0.113.10 dry=false", "untag ctf-port-forward=203.0.113.10"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &portTagCloud{tags: tt.tags, missingFIP: tt.missingFIP}
+			if err := leavePortForward(context.Background(), api, "port-id", created, tt.stateTags, tt.dryRun); err != nil {
+				t.Fatalf("leavePortForward: %v", err)
+			}
+			if !reflect.DeepEqual(api.calls, tt.want) {
+				t.Errorf("calls = %q, want %q", api.calls, tt.want)
+			}
+		})
+	}
+}
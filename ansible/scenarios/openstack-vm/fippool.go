@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+
+	"github.com/ctferio/scenarios/scenarioconfig"
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/extensions/layer3/floatingips"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// 預分配 FIP pool：fip_address 需要每題手動填一個位址，多位玩家並發時無法使用。
+// 設定 fip_pool_tag / fip_pool_description 後，scenario 從一組預先分配、帶 tag 的 FIP 中
+// 挑一個未關聯的使用（省下建立 FIP 的 2-3s），destroy 時只解除關聯、不刪除 FIP。
+//
+// additional keys：
+//
+//	fip_pool_tag          預分配 FIP 的 Neutron tag（例："ctf-fip-pool"）
+//	fip_pool_description  預分配 FIP 的 description（完全比對，可與 tag 併用）
+//
+// 認領（claim）以 Neutron revision_number 做 compare-and-swap：帶 If-Match 的關聯請求
+// 只有在 FIP 自列出後沒被其他部署修改時才會成功（否則 412），並發部署不會搶到同一個 FIP。
+// 關聯後再由 Pulumi FloatingIpAssociate 納入 stack，destroy 時解除關聯，FIP 回到 pool。
+type fipPoolConfig struct {
+	Tag         string
+	Description string
+}
+
+func parseFIPPoolConfig(conf *scenarioconfig.Values) fipPoolConfig {
+	return fipPoolConfig{
+		Tag:         conf.String("fip_pool_tag"),
+		Description: conf.String("fip_pool_description"),
+	}
+}
+
+// Enabled 是否從預分配 pool 選 FIP
+func (c fipPoolConfig) Enabled() bool {
+	return c.Tag != "" || c.Description != ""
+}
+
+func (c fipPoolConfig) String() string {
+	return fmt.Sprintf("tag=%q description=%q", c.Tag, c.Description)
+}
+
+// unknownString preview 時尚未決定的值（例如尚未認領的 FIP 位址）：
+// 下游的 FloatingIpAssociate 與 connection_info 顯示為 computed，而不是空字串
+func unknownString() pulumi.StringOutput {
+	return pulumi.UnsafeUnknownOutput(nil).ApplyT(func(any) string { return "" }).(pulumi.StringOutput)
+}
+
+// pooledFloatingIP 列出 FIP 時需要的欄位（gophercloud FloatingIP 沒有 revision_number）
+type pooledFloatingIP struct {
+	ID             string `json:"id"`
+	FloatingIP     string `json:"floating_ip_address"`
+	PortID         string `json:"port_id"`
+	RevisionNumber int    `json:"revision_number"`
+}
+
+// claimFloatingIP 從 pool 認領一個未關聯的 FIP 並關聯到 portID，回傳位址。
+// portID 已關聯 pool 中的 FIP 時（重複執行 pulumi up）直接沿用；dryRun 只查詢不認領，
+// 尚未關聯時回傳空字串（呼叫端改為 unknown，見 unknownString）。
+func (c *cloudAPI) claimFloatingIP(ctx context.Context, cfg fipPoolConfig, portID, key string, dryRun bool) (string, error) {
+	client, err := c.networkClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	pages, err := floatingips.List(client, floatingips.ListOpts{
+		Tags:        cfg.Tag,
+		Description: cfg.Description,
+	}).AllPages(ctx)
+	if err != nil {
+		return "", fmt.Errorf("list pooled floating ips (%s): %w", cfg, err)
+	}
+	var all []pooledFloatingIP
+	if err := floatingips.ExtractFloatingIPsInto(pages, &all); err != nil {
+		return "", fmt.Errorf("list pooled floating ips (%s): %w", cfg, err)
+	}
+
+	var free []pooledFloatingIP
+	for _, fip := range all {
+		if fip.PortID == portID {
+			return fip.FloatingIP, nil
+		}
+		if fip.PortID == "" {
+			free = append(free, fip)
+		}
+	}
+	if dryRun {
+		return "", nil
+	}
+	if len(free) == 0 {
+		return "", fmt.Errorf("no unassociated floating ip left in pool (%s, %d total)", cfg, len(all))
+	}
+
+	// 依 key 決定起點，降低並發部署一開始就搶同一個 FIP 的機率
+	h := sha256.Sum256([]byte(key))
+	start := int(binary.BigEndian.Uint32(h[:4]) % uint32(len(free)))
+	for i := range free {
+		fip := free[(start+i)%len(free)]
+		_, err := client.Put(ctx, client.ServiceURL("floatingips", fip.ID), map[string]any{
+			"floatingip": map[string]any{"port_id": portID},
+		}, nil, &gophercloud.RequestOpts{
+			MoreHeaders: map[string]string{"If-Match": fmt.Sprintf("revision_number=%d", fip.RevisionNumber)},
+			OkCodes:     []int{200},
+		})
+		if err == nil {
+			return fip.FloatingIP, nil
+		}
+		// 412：列出之後被其他部署認領；409：已有關聯。換下一個
+		if gophercloud.ResponseCodeIs(err, http.StatusPreconditionFailed) || gophercloud.ResponseCodeIs(err, http.StatusConflict) {
+			continue
+		}
+		return "", fmt.Errorf("claim floating ip %s: %w", fip.FloatingIP, err)
+	}
+	return "", fmt.Errorf("all %d unassociated floating ips in pool (%s) were claimed concurrently", len(free), cfg)
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ctferio/scenarios/scenarioconfig"
+	"github.com/ctferio/scenarios/scenarioconfig/flaggen"
+	"golang.org/x/crypto/ssh"
+)
+
+// Flag 投遞方式。預設 flag 寫在 user_data，VM 上任何使用者都能從 config drive 或
+// 169.254.169.254 讀回 user_data，提權題形同虛設。
+//
+// additional keys：
+//
+//	flag_delivery        user_data（預設）/ scrub / ssh
+//	flag_owner           flag 檔擁有者（預設 root:root）
+//	flag_mode            flag 檔權限（預設 0444；提權題通常設 0400）
+//	                     flags 宣告的具名 flag 檔未指定 owner= / mode= 時同樣套用（見 flagstrategy.go）
+//	flag_inject_cidr     ssh 模式 per-player SG 開放 22 port 的來源（ssh 模式必填，見下方）
+//	flag_inject_timeout  ssh 模式等待 VM 可注入的上限（預設 5m）
+//
+// scrub：flag 仍在 user_data，但 cloud-init 每次開機（bootcmd，在使用者登入前）以 iptables
+// 擋下非 root 對 metadata service 的連線、把 config drive 裝置改為 root-only，
+// 並在所有步驟完成後刪除 cloud-init 快取的 user_data 與腳本。Nova 上的 user_data 仍在，
+// 能讀 Nova API 的人（專案成員）看得到。
+//
+// ssh：flag 完全不經過 metadata。user_data 只建立一次性的 ctf-inject 使用者（公鑰 + sudo），
+// VM 開機後 scenario 以 SSH 寫入 flag 檔，接著撤銷該使用者的公鑰與 sudo 權限。
+// 撤銷前 ctf-inject 有 NOPASSWD sudo，22 port 只開放給 flag_inject_cidr（chall-manager 的位址），
+// 不提供 0.0.0.0/0 預設，未設定時部署失敗。
+// server host key 以 cloud-init 印在 console log 的 host keys 驗證（需要 Nova console 權限，
+// image 不可關閉 ssh keys_to_console），雙向都經過驗證。
+// 注入金鑰以 chall-manager 的 secret（CHALLENGE_FLAG_SECRET，與 hmac flag 策略共用）對 identity + flag
+// 做 HMAC 衍生：重複執行 pulumi up 時 user_data 不變，flag_strategy=static 時也推不出金鑰。
+// 注入完成時在 console 印出確認碼（secret 對 instance ID 與 flag 檔的 HMAC，不含 flag），
+// 公鑰撤銷後再次執行 pulumi up 時，認證被拒絕且 console 上有確認碼才判定為已投遞，否則部署失敗。
+// VM 的 SG 需允許 chall-manager 連 22 port（per-player SG 會自動加規則；共用 SG 需自行設定）。
+// vendor-data 同樣由 metadata service 提供，無法對一般使用者隱藏，因此不提供此模式。
+const (
+	flagDeliveryUserData = "user_data"
+	flagDeliveryScrub    = "scrub"
+	flagDeliverySSH      = "ssh"
+
+	// flagInjectUser ssh 模式建立的一次性使用者
+	flagInjectUser = "ctf-inject"
+	// flagInjectSudoers ssh 模式給 flagInjectUser 的 sudo 設定檔（注入完成後刪除）
+	flagInjectSudoers = "/etc/sudoers.d/90-ctf-inject"
+	// flagInjectPort 注入使用的 SSH port
+	flagInjectPort = 22
+
+	defaultFlagInjectTimeout = 5 * time.Minute
+	flagInjectPollInterval   = 5 * time.Second
+
+	// flagInjectConfirmPrefix 注入完成時印在 console 的確認碼前綴（見 injectConfirmation）
+	flagInjectConfirmPrefix = "ctf-flag-injected:"
+
+	// cloud-init keys_to_console 在 console 印出 host public keys 的區塊
+	consoleHostKeysBegin = "-----BEGIN SSH HOST KEY KEYS-----"
+	consoleHostKeysEnd   = "-----END SSH HOST KEY KEYS-----"
+)
+
+var (
+	flagOwnerPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*(:[A-Za-z0-9_][A-Za-z0-9_.-]*)?$`)
+	flagModePattern  = regexp.MustCompile(`^0?[0-7]{3,4}$`)
+
+	// errFlagAlreadyInjected 注入金鑰已被撤銷，且 console 上有此次 flag 檔的確認碼（先前的部署已投遞）
+	errFlagAlreadyInjected = errors.New("inject key already revoked")
+	// errInjectKeyRejected host key 驗證通過後注入金鑰被拒絕（已撤銷，或注入使用者未建立）
+	errInjectKeyRejected = errors.New("inject key rejected")
+)
+
+// flagDelivery 由 additional 解析出的 flag 投遞設定
+type flagDelivery struct {
+	Mode          string
+	Owner         string
+	Perms         string
+	InjectCIDR    string
+	InjectTimeout time.Duration
+	// InjectSecret ssh 模式衍生注入金鑰與確認碼的 secret（flaggen.LoadSecret）
+	InjectSecret string
+}
+
+func parseFlagDelivery(conf *scenarioconfig.Values) (flagDelivery, error) {
+	d := flagDelivery{
+		Mode:          conf.String("flag_delivery"),
+		Owner:         conf.String("flag_owner"),
+		Perms:         conf.String("flag_mode"),
+		InjectCIDR:    conf.String("flag_inject_cidr"),
+		InjectTimeout: conf.Duration("flag_inject_timeout"),
+	}
+	if !strings.HasPrefix(d.Perms, "0") {
+		d.Perms = "0" + d.Perms
+	}
+	if !d.Injected() {
+		return d, nil
+	}
+	if d.InjectCIDR == "" {
+		return d, errors.New("flag_delivery=ssh requires flag_inject_cidr (or CHALLENGE_FLAG_INJECT_CIDR): " +
+			"the source address of chall-manager, e.g. 10.0.0.5/32")
+	}
+	secret, err := flaggen.LoadSecret()
+	if err != nil {
+		return d, err
+	}
+	if secret == "" {
+		return d, fmt.Errorf("flag_delivery=ssh requires %s or %s on chall-manager (inject key derivation)",
+			flaggen.SecretEnv, flaggen.SecretFileEnv)
+	}
+	d.InjectSecret = secret
+	return d, nil
+}
+
+// Injected 是否由 scenario 在開機後以 SSH 寫入 flag（user_data 不含 flag）
+func (d flagDelivery) Injected() bool {
+	return d.Mode == flagDeliverySSH
+}
+
+// ── cloud-config ────────────────────────────────────────────
+
+// scrubBootCmd scrub 模式每次開機執行：擋下非 root 連 metadata service、config drive 改為 root-only
+var scrubBootCmd = []any{
+	`command -v iptables >/dev/null && { iptables -C OUTPUT -d 169.254.169.254 -m owner ! --uid-owner 0 -j REJECT 2>/dev/null || iptables -I OUTPUT -d 169.254.169.254 -m owner ! --uid-owner 0 -j REJECT; } || echo "ctf: failed to block metadata service for non-root users" >/dev/console`,
+	`command -v ip6tables >/dev/null && { ip6tables -C OUTPUT -d fe80::a9fe:a9fe -m owner ! --uid-owner 0 -j REJECT 2>/dev/null || ip6tables -I OUTPUT -d fe80::a9fe:a9fe -m owner ! --uid-owner 0 -j REJECT; } || true`,
+	`dev=$(blkid -L config-2 2>/dev/null) && chmod 0600 "$dev" || true`,
+}
+
+// scrubRunCmd scrub 模式在所有 runcmd / 出題者腳本之後刪除 cloud-init 快取的 user_data
+var scrubRunCmd = []any{
+	`rm -f /var/lib/cloud/instances/*/user-data.txt /var/lib/cloud/instances/*/user-data.txt.i /var/lib/cloud/instances/*/scripts/part-*`,
+}
+
+// addScrub 加入 scrub 模式的 bootcmd / runcmd（runcmd 附加在最後）
+func (c *cloudConfig) addScrub() {
+	c.BootCmd = append(c.BootCmd, scrubBootCmd...)
+	c.RunCmd = append(c.RunCmd, scrubRunCmd...)
+}
+
+// addInjectUser 加入 ssh 模式的一次性注入使用者（保留 image 預設使用者與出題者的 users）
+func (c *cloudConfig) addInjectUser(publicKey string) {
+	users, _ := c.Extra["users"].([]any)
+	if users == nil {
+		users = []any{"default"}
+	}
+	users = append(users, map[string]any{
+		"name":                flagInjectUser,
+		"shell":               "/bin/sh",
+		"lock_passwd":         true,
+		"ssh_authorized_keys": []any{publicKey},
+	})
+	if c.Extra == nil {
+		c.Extra = map[string]any{}
+	}
+	c.Extra["users"] = users
+	c.addFile(writeFile{
+		Path:        flagInjectSudoers,
+		Content:     flagInjectUser + " ALL=(root) NOPASSWD:ALL\n",
+		Owner:       "root:root",
+		Permissions: "0440",
+	})
+}
+
+// ── SSH 注入 ────────────────────────────────────────────────
+
+// injectMAC 以 InjectSecret 對 parts 計算 HMAC-SHA256（parts 以 NUL 分隔）
+func (d flagDelivery) injectMAC(parts ...string) []byte {
+	m := hmac.New(sha256.New, []byte(d.InjectSecret))
+	m.Write([]byte(strings.Join(parts, "\x00")))
+	return m.Sum(nil)
+}
+
+// injectSigner 由 secret + identity + flag 衍生注入金鑰（deterministic，user_data 不會因重跑而改變）
+func (d flagDelivery) injectSigner(identity, flag string) (ssh.Signer, error) {
+	seed := d.injectMAC("ctf-flag-inject", identity, flag)
+	return ssh.NewSignerFromKey(ed25519.NewKeyFromSeed(seed))
+}
+
+// injectPublicKey 回傳注入金鑰的 authorized_keys 格式公鑰
+func (d flagDelivery) injectPublicKey(identity, flag string) (string, error) {
+	signer, err := d.injectSigner(identity, flag)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))) + " " + flagInjectUser, nil
+}
+
+// flagFile 要寫入 VM 的 flag 檔
+type flagFile struct {
+	Path    string
+	Content string
+	Owner   string
+	Perms   string
+}
+
+// flagFiles 主要 flag 檔與 flags 宣告的具名 flag 檔；具名 flag 未指定 owner / mode 時沿用 flag_owner / flag_mode
+func (d flagDelivery) flagFiles(flagPath, flag string, specs []flaggen.FlagSpec, named map[string]string) []flagFile {
+	files := []flagFile{{Path: flagPath, Content: flag + "\n", Owner: d.Owner, Perms: d.Perms}}
+	for _, spec := range specs {
+		f := flagFile{Path: spec.Path, Content: named[spec.Name] + "\n", Owner: spec.Owner, Perms: spec.Mode}
+		if f.Owner == "" {
+			f.Owner = d.Owner
+		}
+		if f.Perms == "" {
+			f.Perms = d.Perms
+		} else if !strings.HasPrefix(f.Perms, "0") {
+			f.Perms = "0" + f.Perms
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+// injectConfirmation 注入完成時印在 console 的確認碼：綁定 instance 與所有 flag 檔，
+// 不知道 secret 無法偽造，也推不出 flag
+func (d flagDelivery) injectConfirmation(serverID string, files []flagFile) string {
+	parts := []string{"ctf-flag-inject-confirm", serverID}
+	for _, f := range files {
+		parts = append(parts, f.Path, f.Content)
+	}
+	return flagInjectConfirmPrefix + hex.EncodeToString(d.injectMAC(parts...)[:16])
+}
+
+// injectScript 以 sudo 執行：依序從 stdin 讀出每個 flag 檔（長度固定，見 injectStdin），
+// 全部寫入後把 confirmation 印到 console，最後撤銷注入使用者的公鑰與 sudo 權限
+func injectScript(files []flagFile, confirmation string) string {
+	lines := []string{"set -e", "umask 077"}
+	for _, f := range files {
+		p := shellQuote(f.Path)
+		tmp := shellQuote(f.Path + ".ctf-inject")
+		lines = append(lines,
+			"mkdir -p "+shellQuote(path.Dir(f.Path)),
+			"dd bs=1 count="+strconv.Itoa(len(f.Content))+" of="+tmp+" 2>/dev/null",
+			"chown "+shellQuote(f.Owner)+" "+tmp,
+			"chmod "+f.Perms+" "+tmp,
+			"mv -f "+tmp+" "+p,
+		)
+	}
+	return strings.Join(append(lines,
+		"echo "+shellQuote(confirmation)+" >/dev/console",
+		"rm -f "+flagInjectSudoers+" ~"+flagInjectUser+"/.ssh/authorized_keys",
+		"usermod -L -s /usr/sbin/nologin "+flagInjectUser+" 2>/dev/null || true",
+	), "\n")
+}
+
+// injectStdin 所有 flag 檔內容依序串接（injectScript 以 dd 逐檔讀取）
+func injectStdin(files []flagFile) string {
+	var b strings.Builder
+	for _, f := range files {
+		b.WriteString(f.Content)
+	}
+	return b.String()
+}
+
+// injectFlag 等待 VM 在 console 印出 host keys 後，以 SSH 在同一個 session 寫入所有 flag 檔。
+// 注入金鑰被拒絕（重複執行 pulumi up）時，只有 console 上有此次 flag 檔的確認碼才回傳 nil，
+// 否則持續重試到 InjectTimeout 後回傳 error。
+func (c *cloudAPI) injectFlag(parent context.Context, d flagDelivery, host, serverID string, signer ssh.Signer, files []flagFile) error {
+	ctx, cancel := context.WithTimeout(parent, d.InjectTimeout)
+	defer cancel()
+
+	confirmation := d.injectConfirmation(serverID, files)
+	var lastErr error
+	for {
+		lastErr = c.injectFlagOnce(ctx, host, serverID, signer, files, confirmation)
+		if lastErr == nil {
+			return nil
+		}
+		if errors.Is(lastErr, errFlagAlreadyInjected) {
+			fmt.Printf("flag already injected into %s (inject key revoked, confirmation found on console)\n", serverID)
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("inject flag into %s: not done after %s: %w", serverID, d.InjectTimeout, lastErr)
+		case <-time.After(flagInjectPollInterval):
+		}
+	}
+}
+
+func (c *cloudAPI) injectFlagOnce(ctx context.Context, host, serverID string, signer ssh.Signer, files []flagFile, confirmation string) error {
+	// host keys 由 cloud-init final stage 印出，此時注入使用者已建立
+	out, err := c.consoleOutput(ctx, serverID, 0)
+	if err != nil {
+		return err
+	}
+	hostKeys := consoleHostKeys(out)
+	if len(hostKeys) == 0 {
+		return errors.New("ssh host keys not printed on console yet")
+	}
+
+	conn, err := dialContext(ctx, readinessTarget{Host: host, Port: flagInjectPort})
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(flagInjectPort))
+	var hostKeyVerified atomic.Bool
+	sc, chans, reqs, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User: flagInjectUser,
+		Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			for _, k := range hostKeys {
+				if bytes.Equal(k.Marshal(), key.Marshal()) {
+					hostKeyVerified.Store(true)
+					return nil
+				}
+			}
+			return fmt.Errorf("ssh host key %s does not match console host keys", ssh.FingerprintSHA256(key))
+		},
+		Timeout: readinessDialTimeout,
+	})
+	if err != nil {
+		conn.Close()
+		if authRejected(err, hostKeyVerified.Load()) {
+			// 金鑰已撤銷不代表 flag 檔是此次的內容（也可能注入使用者根本沒建立），以 console 上的確認碼判定
+			if injectConfirmed(out, confirmation) {
+				return errFlagAlreadyInjected
+			}
+			return fmt.Errorf("ssh %s: %w, and no delivery confirmation on console", addr, errInjectKeyRejected)
+		}
+		return fmt.Errorf("ssh %s: %w", addr, err)
+	}
+	client := ssh.NewClient(sc, chans, reqs)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("ssh session: %w", err)
+	}
+	defer session.Close()
+	session.Stdin = strings.NewReader(injectStdin(files))
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	if err := session.Run("sudo -n sh -c " + shellQuote(injectScript(files, confirmation))); err != nil {
+		return fmt.Errorf("write flag files: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// authRejected NewClientConn 的錯誤是否為公鑰被拒絕（注入完成後公鑰已撤銷）。
+// x/crypto/ssh client 端的認證失敗沒有型別（*ssh.ServerAuthError 只用於 server 端），改以握手階段判斷：
+// host key 已驗證表示金鑰交換完成，之後不是連線中斷 / 逾時的錯誤只可能來自認證階段。
+func authRejected(err error, hostKeyVerified bool) bool {
+	if !hostKeyVerified {
+		return false
+	}
+	var netErr net.Error
+	return !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.As(err, &netErr)
+}
+
+// injectConfirmed console log 是否有完整一行的確認碼（同一行可能帶有時間戳等前綴）
+func injectConfirmed(console, confirmation string) bool {
+	for _, line := range strings.Split(console, "\n") {
+		if strings.HasSuffix(strings.TrimRight(line, "\r "), confirmation) {
+			return true
+		}
+	}
+	return false
+}
+
+// consoleHostKeys 解析 cloud-init 印在 console 的 SSH host public keys
+func consoleHostKeys(console string) []ssh.PublicKey {
+	var keys []ssh.PublicKey
+	in := false
+	for _, line := range strings.Split(console, "\n") {
+		switch {
+		case strings.Contains(line, consoleHostKeysBegin):
+			in, keys = true, nil // 多次開機時以最後一段為準
+			continue
+		case strings.Contains(line, consoleHostKeysEnd):
+			in = false
+			continue
+		case !in:
+			continue
+		}
+		// console 行可能帶有前綴（時間戳等），從 key type 開始解析
+		for _, prefix := range []string{"ssh-", "ecdsa-"} {
+			if i := strings.Index(line, prefix); i >= 0 {
+				if k, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line[i:])); err == nil {
+					keys = append(keys, k)
+				}
+				break
+			}
+		}
+	}
+	return keys
+}
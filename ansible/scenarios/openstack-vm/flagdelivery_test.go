@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestAuthRejected(t *testing.T) {
+	timeout := &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	authErr := errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey], no supported methods remain")
+	tests := []struct {
+		name     string
+		err      error
+		verified bool
+		want     bool
+	}{
+		{name: "auth failure after host key", err: authErr, verified: true, want: true},
+		{name: "auth failure before host key", err: authErr},
+		{name: "host key mismatch", err: errors.New("ssh: handshake failed: ssh host key does not match console host keys")},
+		{name: "connection closed", err: fmt.Errorf("ssh: handshake failed: %w", io.EOF), verified: true},
+		{name: "unexpected eof", err: fmt.Errorf("ssh: handshake failed: %w", io.ErrUnexpectedEOF), verified: true},
+		{name: "timeout", err: fmt.Errorf("ssh: handshake failed: %w", timeout), verified: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := authRejected(tt.err, tt.verified); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInjectConfirmed(t *testing.T) {
+	d := flagDelivery{InjectSecret: "s3cret"}
+	files := []flagFile{{Path: "/root/flag.txt", Content: "CTF{x}\n"}}
+	confirmation := d.injectConfirmation("server-1", files)
+	tests := []struct {
+		name    string
+		console string
+		want    bool
+	}{
+		{name: "own line", console: "boot\n" + confirmation + "\nlogin:", want: true},
+		{name: "timestamp prefix", console: "[  12.3] " + confirmation + "\r\n", want: true},
+		{name: "absent", console: "boot\nlogin:"},
+		{name: "other instance", console: d.injectConfirmation("server-2", files)},
+		{name: "other flag", console: d.injectConfirmation("server-1", []flagFile{{Path: "/root/flag.txt", Content: "CTF{y}\n"}})},
+		{name: "other secret", console: flagDelivery{InjectSecret: "other"}.injectConfirmation("server-1", files)},
+		{name: "truncated", console: confirmation[:len(confirmation)-1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := injectConfirmed(tt.console, confirmation); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInjectSignerUsesSecret(t *testing.T) {
+	key := func(secret string) []byte {
+		signer, err := flagDelivery{InjectSecret: secret}.injectSigner("team-1", "CTF{static}")
+		if err != nil {
+			t.Fatalf("injectSigner: %v", err)
+		}
+		return signer.PublicKey().Marshal()
+	}
+	if !bytes.Equal(key("a"), key("a")) {
+		t.Error("same secret derived different keys")
+	}
+	if bytes.Equal(key("a"), key("b")) {
+		t.Error("different secrets derived the same key")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ctferio/scenarios/scenarioconfig/flaggen"
+	"github.com/pulumi/pulumi-random/sdk/v4/go/random"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// flag 產生策略（策略說明見 scenarioconfig/flaggen）
+//
+// additional keys：
+//
+//	flag_strategy  variate（預設）/ static / hmac / random / format
+//	flag_format    format 策略的模板，例："{{.Prefix}}{ {{leet .Base}}_{{hash 6}} }"
+//	flags          多個具名 flag（見 scenarioconfig/flaggen/flags.go），各自以同一策略產生，
+//	               stack output "named_flags"（name → flag）；主要 flag 仍為 resp.Flag
+//
+// 全域設定（環境變數，出題者無法覆蓋）：
+//
+//	CHALLENGE_FLAG_SECRET / CHALLENGE_FLAG_SECRET_FILE  hmac 策略（與 flag_format 的 .HMAC）的 key，也用於 flag_delivery=ssh 的注入金鑰
+//
+// random 策略以 random.RandomId（ctf-<shortID>-flag-nonce）把亂數存在 Pulumi state，
+// 同一個 instance 重跑 pulumi up 得到相同 flag（user_data 不變）。產生的 flag 寫入 VM（flag_delivery）、
+// cloud_init 模板的 {{.Flag}} 與 resp.Flag。
+//
+// flags 的每個 flag 必須指定檔案路徑（name=path），owner= / mode= 未指定時沿用 flag_owner / flag_mode，
+// 以與主要 flag 相同的 flag_delivery 寫入；cloud_init 模板以 {{.Flags.<name>}} 取得。
+
+// flagOutputs 回傳主要 flag 與具名 flag（產生方式見 flaggen.Source）；
+// 需要 nonce 時建立 name 的 random.RandomId 資源（所有 flag 共用）
+func flagOutputs(ctx *pulumi.Context, flags flaggen.Source, name string, opts ...pulumi.ResourceOption) (pulumi.StringOutput, pulumi.StringMapOutput, error) {
+	if !flags.NeedsNonce() {
+		flag, err := flags.Flag("")
+		if err != nil {
+			return pulumi.StringOutput{}, pulumi.StringMapOutput{}, err
+		}
+		named, err := flags.Named("")
+		return pulumi.String(flag).ToStringOutput(), pulumi.ToStringMap(named).ToStringMapOutput(), err
+	}
+	nonce, err := random.NewRandomId(ctx, name, &random.RandomIdArgs{
+		ByteLength: pulumi.Int(flaggen.NonceBytes),
+	}, opts...)
+	if err != nil {
+		return pulumi.StringOutput{}, pulumi.StringMapOutput{}, err
+	}
+	return nonce.Hex.ApplyT(flags.Flag).(pulumi.StringOutput),
+		nonce.Hex.ApplyT(flags.Named).(pulumi.StringMapOutput), nil
+}
+
+// validateFlags 檢查 flags：每個 flag 需要絕對路徑，owner / mode 格式與 flag_owner / flag_mode 相同
+func validateFlags(raw string) error {
+	specs, err := flaggen.ParseFlags(raw)
+	if err != nil {
+		return err
+	}
+	paths := map[string]string{}
+	for _, spec := range specs {
+		switch {
+		case !strings.HasPrefix(spec.Path, "/"):
+			return fmt.Errorf("flag %s: requires an absolute file path (name=/path/to/flag)", spec.Name)
+		case spec.Env != "":
+			return fmt.Errorf("flag %s: env= is not supported on VMs", spec.Name)
+		case spec.Owner != "" && !flagOwnerPattern.MatchString(spec.Owner):
+			return fmt.Errorf("flag %s: invalid owner %q (want user or user:group)", spec.Name, spec.Owner)
+		case spec.Mode != "" && !flagModePattern.MatchString(spec.Mode):
+			return fmt.Errorf("flag %s: invalid mode %q (want octal, e.g. 0400)", spec.Name, spec.Mode)
+		}
+		if other, ok := paths[spec.Path]; ok {
+			return fmt.Errorf("flag %s: path %s already used by flag %s", spec.Name, spec.Path, other)
+		}
+		paths[spec.Path] = spec.Name
+	}
+	return nil
+}
@@ -4,10 +4,30 @@ go 1.25
 
 require (
 	github.com/ctfer-io/chall-manager/sdk v0.6.3
+	// scenarioconfig：共用的 additional config schema（見 ../scenarioconfig）
+	github.com/ctferio/scenarios/scenarioconfig v0.0.0
+	// scenarioplan：plan 子命令（mock runtime 離線輸出資源與 outputs，見 ../scenarioplan）
+	github.com/ctferio/scenarios/scenarioplan v0.0.0
+	// scenariotrace：OpenTelemetry trace（設定 OTEL_EXPORTER_OTLP_ENDPOINT 時啟用，見 ../scenariotrace）
+	github.com/ctferio/scenarios/scenariotrace v0.0.0
+	// gophercloud：pulumi-openstack 沒有提供的 API（console log 等）
+	github.com/gophercloud/gophercloud/v2 v2.4.0
 	// ✅ 使用 SDK v3（對應 pulumi-resource-openstack v3.x / terraform-provider-openstack v1.x）
 	// SDK v4.1.0 對應的 terraform-provider-openstack v2.1.0 有 GetRawConfig() nil panic bug
 	github.com/pulumi/pulumi-openstack/sdk/v3 v3.15.0
+	// pulumi-random：flag_strategy=random 的 nonce（存在 Pulumi state）
+	github.com/pulumi/pulumi-random/sdk/v4 v4.16.7
 	github.com/pulumi/pulumi/sdk/v3 v3.219.0
+	// x/crypto/ssh：flag_delivery=ssh 以 SSH 注入 flag
+	golang.org/x/crypto v0.47.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
+// scenarioconfig / scenarioplan / scenariotrace 與 scenario 一起 rsync 到 build 主機，以相對路徑引用
+replace github.com/ctferio/scenarios/scenarioconfig => ../scenarioconfig
+
+replace github.com/ctferio/scenarios/scenarioplan => ../scenarioplan
+
+replace github.com/ctferio/scenarios/scenariotrace => ../scenariotrace
+
 // 執行 go mod tidy 自動補全間接依賴
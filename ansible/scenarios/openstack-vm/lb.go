@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ctferio/scenarios/scenarioconfig"
+	"github.com/gophercloud/gophercloud/v2/openstack/loadbalancer/v2/listeners"
+	"github.com/pulumi/pulumi-openstack/sdk/v3/go/openstack/loadbalancer"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Octavia 前端（expose_mode=loadbalancer）：HTTP 題目的玩家 VM 全部掛在同一個共用 listener 後面，
+// 以 L7 host rule（<shortID>.<domain> → 該玩家的 member）分流，TLS 由 listener 以 wildcard 憑證終結，
+// VM 完全不需要 floating IP。
+//
+// additional keys：
+//
+//	lb_listener_id       預建的共用 listener（TERMINATED_HTTPS + *.<domain> 憑證，或 HTTP）
+//	lb_domain            玩家 hostname 的網域，需有 *.<domain> 指向 LB VIP 的 wildcard DNS
+//	lb_scheme            回傳 URL 的 scheme（預設 https；listener 為 HTTP 時填 http）
+//	lb_member_subnet_id  member 所在 subnet（選填，預設由 Octavia 使用 VIP subnet）
+//
+// 每個 instance 建立 pool + member + L7 policy（REDIRECT_TO_POOL）+ L7 rule（HOST_NAME EQUAL_TO），
+// 全部屬於玩家的 stack，destroy 時移除。listener / LB / 憑證由營運端預建並共用。
+// Octavia 在 LB 更新中（PENDING_UPDATE）會拒絕其他變更，provider 會在 409 時自動重試，
+// 並發部署只是排隊，不會失敗。
+type loadBalancerConfig struct {
+	ListenerID     string
+	Domain         string
+	Scheme         string
+	MemberSubnetID string
+}
+
+func parseLoadBalancerConfig(conf *scenarioconfig.Values) loadBalancerConfig {
+	return loadBalancerConfig{
+		ListenerID:     conf.String("lb_listener_id"),
+		Domain:         conf.String("lb_domain"),
+		Scheme:         conf.String("lb_scheme"),
+		MemberSubnetID: conf.String("lb_member_subnet_id"),
+	}
+}
+
+// Hostname 回傳玩家的 hostname
+func (c loadBalancerConfig) Hostname(shortID string) string {
+	return shortID + "." + c.Domain
+}
+
+// Port 回傳 listener 對外 port（依 scheme）
+func (c loadBalancerConfig) Port() int {
+	if c.Scheme == "http" {
+		return 80
+	}
+	return 443
+}
+
+// DefaultConnectionInfo 未設定 connection_info 時回傳的 URL（{ip} 在此模式為 hostname）
+func (c loadBalancerConfig) DefaultConnectionInfo() string {
+	return c.Scheme + "://{ip}"
+}
+
+// listenerLoadBalancer 查詢 listener 所屬的 load balancer（pool 需掛在 LB 上供 L7 policy 導向）
+func (c *cloudAPI) listenerLoadBalancer(ctx context.Context, listenerID string) (string, error) {
+	client, err := c.loadBalancerClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	l, err := listeners.Get(ctx, client, listenerID).Extract()
+	if err != nil {
+		return "", fmt.Errorf("get listener %s: %w", listenerID, err)
+	}
+	if len(l.Loadbalancers) == 0 {
+		return "", fmt.Errorf("listener %s is not attached to a load balancer", listenerID)
+	}
+	return l.Loadbalancers[0].ID, nil
+}
+
+// newLoadBalancerFrontend 為一個 instance 建立 pool / member / L7 policy / L7 rule
+func newLoadBalancerFrontend(ctx *pulumi.Context, prefix string, cfg loadBalancerConfig, lbID, host string,
+	memberAddr pulumi.StringInput, memberPort int, opts ...pulumi.ResourceOption,
+) error {
+	pool, err := loadbalancer.NewPool(ctx, prefix+"-pool", &loadbalancer.PoolArgs{
+		Name:           pulumi.String(prefix + "-pool"),
+		Protocol:       pulumi.String("HTTP"),
+		LbMethod:       pulumi.String("ROUND_ROBIN"),
+		LoadbalancerId: pulumi.String(lbID),
+	}, opts...)
+	if err != nil {
+		return err
+	}
+
+	memberArgs := &loadbalancer.MemberArgs{
+		Name:         pulumi.String(prefix + "-member"),
+		PoolId:       pool.ID(),
+		Address:      memberAddr,
+		ProtocolPort: pulumi.Int(memberPort),
+	}
+	if cfg.MemberSubnetID != "" {
+		memberArgs.SubnetId = pulumi.String(cfg.MemberSubnetID)
+	}
+	if _, err := loadbalancer.NewMember(ctx, prefix+"-member", memberArgs, opts...); err != nil {
+		return err
+	}
+
+	policy, err := loadbalancer.NewL7PolicyV2(ctx, prefix+"-l7policy", &loadbalancer.L7PolicyV2Args{
+		Name:           pulumi.String(prefix + "-l7policy"),
+		Action:         pulumi.String("REDIRECT_TO_POOL"),
+		ListenerId:     pulumi.String(cfg.ListenerID),
+		RedirectPoolId: pool.ID(),
+	}, opts...)
+	if err != nil {
+		return err
+	}
+
+	_, err = loadbalancer.NewL7RuleV2(ctx, prefix+"-l7rule", &loadbalancer.L7RuleV2Args{
+		L7policyId:  policy.ID(),
+		Type:        pulumi.String("HOST_NAME"),
+		CompareType: pulumi.String("EQUAL_TO"),
+		Value:       pulumi.String(host),
+	}, opts...)
+	return err
+}
@@ -4,24 +4,62 @@
 // 使用 chall-manager SDK 模式：
 //   - identity 由 SDK 從 Pulumi config 自動讀取
 //   - 題目設定透過 additional（per-challenge）讀取，fallback 到環境變數（全域）
+//   - additional 在建立任何資源前依 schema.go 驗證，型別錯誤與未知 key 一次回報
 //   - connection_info 和 flag 透過 sdk.Response 回傳
+//   - 各資源的 requested / created / ready 時間匯出為 stack output "timings"（見 timing.go）
+//   - 設定 OTEL_EXPORTER_OTLP_ENDPOINT 時每次部署送出一條 OpenTelemetry trace（見 trace.go）
 //
-// additional 支援的 key（可在 CTFd Advanced 區塊設定）：
+// additional 支援的 key（可在 CTFd Advanced 區塊設定；完整清單以 schema.go 為準，./main schema 輸出 JSON Schema）：
 //   image_id          OpenStack image ID（必填；使用 Packer snapshot 可大幅加速啟動）
 //   flavor            VM flavor（預設 general.small）
 //   port              題目服務 port（預設 8080）
 //   base_flag         flag 衍生基礎值
 //   flag_prefix       flag 前綴（預設 CTF）
+//   flag_strategy     variate（預設）/ static / hmac / random / format（flag_format 模板），見 flagstrategy.go
 //   fip_pool          Floating IP pool（預設 public）
 //   network_id        OpenStack network ID（通常為全域設定）
 //   security_group_id 預建的 Security Group ID（若提供則跳過 SG 建立，省 ~3-5s）
 //   flag_path         VM 內 flag 檔案路徑（預設 /opt/ctf/flag.txt）
-//   cloud_init        自訂 cloud-init 腳本（支援 {{FLAG}} {{PORT}} {{IDENTITY}} 佔位符）
+//   flag_owner / flag_mode  flag 檔擁有者與權限（預設 root:root / 0444）
+//   flags             多個具名 flag 檔（"user=/home/ctf/user.txt owner=ctf:ctf mode=0440, root=/root/root.txt mode=0400"），
+//                     stack output "named_flags"，cloud_init_template 的模板以 {{.Flags.user}} 取得（見 flagstrategy.go）
+//   flag_delivery     user_data（預設）/ scrub（擋 metadata、刪快取）/ ssh（開機後以 SSH 注入，見 flagdelivery.go）
+//   cloud_init        自訂 cloud-init（#cloud-config 或 shell script，與 flag 寫入步驟合併），替換 {{FLAG}} 等佔位符
+//   cloud_init_template  true 時 cloud_init 以 Go text/template 渲染：{{.Flag}} {{yaml .Flag}} {{shell .Flag}}
 //   fip_address       預分配的 Floating IP 位址（跳過 FIP 建立，省 ~2-3s）
-//   connection_info   連線資訊模板（支援 {ip} {port} 佔位符，預設 "nc {ip} {port}"）
-//                     範例："http://{ip}:{port}" / "ssh ubuntu@{ip}" / "nc {ip} {port}"
+//   fip_pool_tag      從帶此 tag 的預分配 FIP 中自動認領（fip_pool_description 亦可，見 fippool.go）
+//   expose_mode       fip（預設，依 use_fip）/ fixed / port_forward（多台共用 port_forward_fip，見 expose.go）
+//                     / loadbalancer（共用 Octavia listener，https://<shortID>.<lb_domain>，見 lb.go）
+//   boot_from_volume  從 image 建立 volume 開機（預設 false；volume_size 指定大小）
+//   boot_volume_source_id / boot_snapshot_source_id / volume_type
+//                     由預建 volume / snapshot clone 開機磁碟（見 volume.go）
+//   dns_backend / dns_zone / dns_zone_id / dns_ttl
+//                     每個 instance 建立 <shortID>.<dns_zone> 的 Designate 記錄，{ip} 改為 hostname（見 dns.go）
+//   connection_info   連線資訊模板（Go text/template，相容 {ip} {port} 佔位符，預設 "nc {ip} {port}"，見 connection.go）
+//                     範例："http://{ip}:{port}" / "ssh ubuntu@{ip}" / {{url "https" .Host .Ports.web "/login"}}
+//   ports             額外的具名 port（"web=80,admin=8443"），各自開放 SG / port forwarding，模板以 {{.Ports.web}} 取得
+//   credentials_user  產生 per-instance 帳密（cloud-init 建立使用者，模板 {{.Credentials.Password}}）
+//   console_access    novnc / spice-html5：connection_info 附上 Nova console URL（{{.Extra.console}} 或 {console}），到期時間 {{.Extra.console_expires}}
+//                     需在 CHALLENGE_CONSOLE_CHALLENGES 允許清單中（見 console.go）
 //   readiness_timeout 等待服務就緒的超時時間（預設 "0" 跳過檢查，最快啟動）
 //                     範例："0"（跳過）/ "30s"（等最多 30 秒）/ "120s"（原始行為）
+//   readiness_check   就緒檢查方式：tcp（預設）/ http / banner / ssh / console（見 readiness.go）
+//   readiness_failure 逾時處理：warn（預設，只 log）/ fail（deployment 失敗）
+//   diagnostics_console_lines 失敗時附帶的 console log 行數（預設 50，見 diagnostics.go）
+//   availability_zone Nova availability zone
+//   server_group      "auto"（每題共用 soft-anti-affinity group）或既有 group UUID（見 placement.go）
+//   scheduler_hints   其他 Nova scheduler hints（"key=value,key=value"）
+//   fallback_flavors / fallback_availability_zones / boot_attempts
+//                     Nova 排程失敗（No valid host）時的備援 flavor / AZ 與重試次數（見 boot.go）
+//   instance_id_hash / instance_id_length / instance_id_scope
+//                     shortID 的 hash（預設 md5）、長度（預設 8）與是否加入 challenge_id（見 naming.go）
+//   challenge_id      題目識別（registration script 注入），用於 ownership metadata 與共用資源命名
+//   source_id         玩家/隊伍識別（選填），寫入 ownership metadata
+//   instance_timeout  instance 存活秒數（registration script 注入），用於 ctf-expires-at
+//
+// OpenStack 認證（見 provider.go，只由 chall-manager 的環境變數設定，additional 無法指定）：
+//   clouds.yaml（OS_CLOUD + OS_CLIENT_CONFIG_FILE）、application credential、
+//   token 或 password（OS_USERNAME/OS_PASSWORD），缺少變數時回傳 error 而非 panic
 //
 // 啟動加速策略：
 //   1. Packer snapshot：出題者用 Packer 預先 bake 題目 image，VM 直接開機即可用
@@ -39,81 +77,86 @@
 package main
 
 import (
-	"crypto/md5"
+	"context"
 	"fmt"
-	"net"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/ctfer-io/chall-manager/sdk"
-	"github.com/pulumi/pulumi-openstack/sdk/v3/go/openstack"
+	"github.com/ctferio/scenarios/scenarioconfig/conninfo"
+	"github.com/ctferio/scenarios/scenarioconfig/flaggen"
+	"github.com/ctferio/scenarios/scenarioconfig/timing"
+	"github.com/pulumi/pulumi-openstack/sdk/v3/go/openstack/blockstorage"
 	"github.com/pulumi/pulumi-openstack/sdk/v3/go/openstack/compute"
 	"github.com/pulumi/pulumi-openstack/sdk/v3/go/openstack/networking"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
 func main() {
-	sdk.Run(run)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		// console-url 子命令：換發過期的 console URL（見 console.go）
+		case "console-url":
+			os.Exit(consoleURLCommand(os.Args[2:]))
+		// schema 子命令：輸出 additional 的 JSON Schema，不執行 Pulumi（見 schema.go）
+		case "schema":
+			os.Exit(schemaCommand())
+		// plan 子命令：以 mock runtime 離線執行，輸出資源、user-data 與 outputs（見 plan.go）
+		case "plan":
+			os.Exit(planCommand(os.Args[2:]))
+		}
+	}
+	sdk.Run(liveEnv.run)
 }
 
-func run(req *sdk.Request, resp *sdk.Response, opts ...pulumi.ResourceOption) error {
+func (e deployEnv) run(req *sdk.Request, resp *sdk.Response, opts ...pulumi.ResourceOption) (err error) {
 	ctx := req.Ctx
 	identity := req.Config.Identity
 
+	// OpenTelemetry trace（未設定 OTLP endpoint 時為 nil，見 trace.go）；run 回傳 error 時帶 error 結束
+	tr := startTrace(ctx, identity)
+	defer func() {
+		if err != nil {
+			finishTrace(tr, err)
+		}
+	}()
+
 	// ── 題目設定（additional 優先，fallback 到環境變數）────────
-	imageID := configOrEnv(req, "image_id", "CHALLENGE_IMAGE_ID", "")
-	if imageID == "" {
-		return fmt.Errorf("image_id is required (set via additional or CHALLENGE_IMAGE_ID env)")
-	}
-	networkID := configOrEnv(req, "network_id", "CHALLENGE_NETWORK_ID", "")
-	if networkID == "" {
-		return fmt.Errorf("network_id is required (set via additional or CHALLENGE_NETWORK_ID env)")
+	// 在建立任何資源前驗證所有 key（型別、範圍、未知 key），一次回報全部問題（見 schema.go）
+	endConfig := tr.Step("config")
+	conf, err := configSchema.Parse(req.Config.Additional)
+	endConfig(err)
+	if err != nil {
+		return err
 	}
-	flavorName := configOrEnv(req, "flavor", "CHALLENGE_FLAVOR", "general.small")
-	fipPool := configOrEnv(req, "fip_pool", "CHALLENGE_FIP_POOL", "public")
-	useFIP := configOrEnv(req, "use_fip", "CHALLENGE_USE_FIP", "true") == "true"
-	bootFromVolume := configOrEnv(req, "boot_from_volume", "CHALLENGE_BOOT_FROM_VOLUME", "false") == "true"
-	volumeSizeStr := configOrEnv(req, "volume_size", "CHALLENGE_VOLUME_SIZE", "10")
-	volumeSize, _ := strconv.Atoi(volumeSizeStr)
-	challengePortStr := configOrEnv(req, "port", "CHALLENGE_PORT", "8080")
-	baseFlag := configOrEnv(req, "base_flag", "CHALLENGE_BASE_FLAG", "change_me")
-	flagPrefix := configOrEnv(req, "flag_prefix", "CHALLENGE_FLAG_PREFIX", "CTF")
+	tr.SetChallenge(conf.String("challenge_id"))
+	// 各資源的 requested / created / ready 時間（stack output "timings"，見 timing.go）
+	tm := newTimings(ctx, conf, identity, tr, e.now)
+	imageID := conf.String("image_id")
+	networkID := conf.String("network_id")
+	flavorName := conf.String("flavor")
+	fipPool := conf.String("fip_pool")
+	challengePort := conf.Int("port")
 
 	// ── 啟動加速設定 ──────────────────────────────────────────
-	flagPath := configOrEnv(req, "flag_path", "", "/opt/ctf/flag.txt")
-	customCloudInit := configOrEnv(req, "cloud_init", "", "")
-	fipAddress := configOrEnv(req, "fip_address", "", "")
-	connTpl := configOrEnv(req, "connection_info", "", "nc {ip} {port}")
-	readinessTimeoutStr := configOrEnv(req, "readiness_timeout", "CHALLENGE_READINESS_TIMEOUT", "0")
-
-	// 解析 readiness_timeout：支援 "0"（跳過）/ "30s" / "120"（秒數）
-	var readinessTimeout time.Duration
-	if d, derr := time.ParseDuration(readinessTimeoutStr); derr == nil {
-		readinessTimeout = d
-	} else if secs, serr := strconv.Atoi(readinessTimeoutStr); serr == nil {
-		readinessTimeout = time.Duration(secs) * time.Second
-	}
+	flagPath := conf.String("flag_path")
+	customCloudInit := conf.String("cloud_init")
+	fipAddress := conf.String("fip_address")
+	connTpl := conf.String("connection_info")
 
-	challengePort, err := strconv.Atoi(challengePortStr)
+	// ── 明確配置 OpenStack provider（繞過 env auto-detect bug）──
+	// 支援 clouds.yaml / application credential / token / password，見 provider.go
+	auth, err := e.auth()
 	if err != nil {
-		return fmt.Errorf("invalid port %q: %w", challengePortStr, err)
+		return err
 	}
-
-	// ── 明確配置 OpenStack provider（繞過 env auto-detect bug）──
-	osProvider, err := openstack.NewProvider(ctx, "openstack", &openstack.ProviderArgs{
-		AuthUrl:           pulumi.StringPtr(requireEnv("OS_AUTH_URL")),
-		UserName:          pulumi.StringPtr(requireEnv("OS_USERNAME")),
-		Password:          pulumi.StringPtr(requireEnv("OS_PASSWORD")),
-		TenantName:        pulumi.StringPtr(requireEnv("OS_PROJECT_NAME")),
-		UserDomainName:    pulumi.StringPtr(envOrDefault("OS_USER_DOMAIN_NAME", "Default")),
-		ProjectDomainName: pulumi.StringPtr(envOrDefault("OS_PROJECT_DOMAIN_NAME", "Default")),
-		Region:            pulumi.StringPtr(envOrDefault("OS_REGION_NAME", "RegionOne")),
-	})
+	osProvider, err := newProvider(ctx, auth)
 	if err != nil {
-		return fmt.Errorf("failed to create openstack provider: %w", err)
+		return err
 	}
+	// provider 不支援的 API（console log 等）直接以 gophercloud 呼叫，見 openstackapi.go
+	api := e.newCloud(auth)
 	provOpt := pulumi.Provider(osProvider)
 
 	// 合併 SDK opts 與 OpenStack provider option
@@ -125,22 +168,123 @@ func run(req *sdk.Request, resp *sdk.Response, opts ...pulumi.ResourceOption) er
 		return all
 	}
 
-	// ── 資源唯一 prefix（MD5 hash 避免截斷衝突）──────────────
-	h := md5.Sum([]byte(identity))
-	shortID := fmt.Sprintf("%x", h)[:8]
+	// ── 資源唯一 prefix（instance_id_hash / length / scope，見 naming.go）──
+	names := parseNaming(conf, imageID)
+	shortID := names.ShortID(identity)
 	prefix := "ctf-" + shortID
+	// 同名 instance 屬於其他 identity 時直接失敗，不沿用也不覆蓋（舊版命名不查詢，見 naming.go）
+	if !names.Legacy() {
+		if err := api.checkNameOwner(ctx.Context(), names, prefix, identity); err != nil {
+			return err
+		}
+	}
+
+	// ── Ownership metadata（Nova metadata / Neutron tags，見 ownership.go）──
+	now := e.now()
+	own := newOwnership(conf, identity, now)
+	tagOpt := ignoreOwnership("tags")
+
+	// readiness_timeout / readiness_check / readiness_failure（見 readiness.go）
+	readyMarker := consoleReadyMarker(prefix)
+	readiness := parseReadinessConfig(conf, api, readyMarker)
+	if !readiness.usesConsole() {
+		readyMarker = ""
+	}
+	// 失敗時附帶的 Nova fault / console log（見 diagnostics.go）
+	diagLines := conf.Int("diagnostics_console_lines")
+
+	// ── 排程（AZ / server group / scheduler hints，見 placement.go）──
+	placement := parsePlacementConfig(conf)
+	serverGroupID, err := placement.resolveServerGroup(ctx.Context(), api,
+		"ctf-"+challengeKey(conf, imageID)+"-sg", ctx.DryRun())
+	if err != nil {
+		return err
+	}
+	// 備援 flavor / AZ 與重試次數（見 boot.go）
+	boot := parseBootPolicy(conf, flavorName, placement.AvailabilityZone)
+	// 對外曝露：fip / fixed / port_forward（見 expose.go）
+	expose := parseExposeConfig(conf)
+	pooledFIP := parseFIPPoolConfig(conf)
+	if expose.Mode == exposeLoadBalancer && !conf.IsSet("connection_info") {
+		connTpl = expose.LB.DefaultConnectionInfo()
+	}
+	// 每個 instance 的 DNS 記錄 <shortID>.<dns_zone>（見 dns.go）
+	dnsCfg := parseDNSConfig(conf)
+	// 玩家 console 存取（見 console.go）：未設定 connection_info 時只回傳 console URL
+	console, err := parseConsoleConfig(conf)
+	if err != nil {
+		return err
+	}
+	if console.Enabled() && !conf.IsSet("connection_info") {
+		connTpl = consolePlaceholder
+	}
+	// connection_info 模板 / 具名 port / per-instance 帳密（見 connection.go），建立資源前先試渲染
+	conn, err := parseConnectionConfig(conf, connTpl, identity, console, now)
+	if err != nil {
+		return err
+	}
+	// 開機磁碟：image / boot_from_volume / 預建 volume 或 snapshot clone（見 volume.go）
+	device, err := resolveBootDevice(ctx.Context(), conf, api, imageID)
+	if err != nil {
+		return err
+	}
 
-	// ── 動態 flag（per-player deterministic）─────────────────
-	flag := fmt.Sprintf("%s{%s}", flagPrefix, sdk.Variate(identity, baseFlag))
+	// ── 動態 flag（flag_strategy，預設 SDK Variate，見 flagstrategy.go）──
+	flags, err := flaggen.NewSource(conf, identity, func(identity, base string) string { return sdk.Variate(identity, base) })
+	if err != nil {
+		return err
+	}
+
+	// ── Flag 投遞：user_data / scrub / ssh 注入（見 flagdelivery.go）──
+	delivery, err := parseFlagDelivery(conf)
+	if err != nil {
+		return err
+	}
 
 	// ── User Data（cloud-init: 注入 flag 到 VM）──────────────
 	// 使用 snapshot 時 cloud-init 只寫 flag，啟動時間 < 5 秒
-	userData := generateUserData(flag, flagPath, challengePort, identity, customCloudInit)
+	// 出題者的 cloud_init 會與 flag 寫入步驟合併（見 userdata.go）
+	userDataFor := func(flag string, named map[string]string) (string, error) {
+		injectPub := ""
+		if delivery.Injected() {
+			pub, err := delivery.injectPublicKey(identity, flag)
+			if err != nil {
+				return "", err
+			}
+			injectPub = pub
+		}
+		return generateUserData(userDataInput{
+			Flag:     flag,
+			FlagPath: flagPath,
+			Port:     challengePort,
+			Identity: identity,
+			Flags:    named,
+			Files:    delivery.flagFiles(flagPath, flag, flags.Specs, named),
+			Custom:   customCloudInit,
+			Template: conf.Bool("cloud_init_template"),
+
+			Delivery:        delivery,
+			InjectPublicKey: injectPub,
+			Credentials:     conn.Credentials,
+			ReadyMarker:     readyMarker,
+		})
+	}
+	// random 策略的 flag 要等 nonce 資源建立後才知道，先以範例 flag 驗證 cloud_init，錯誤在建立資源前回報
+	if _, err := userDataFor(flags.Sample, flags.SampleNamed); err != nil {
+		return err
+	}
+	flag, namedFlags, err := flagOutputs(ctx, flags, prefix+"-flag-nonce", opts...)
+	if err != nil {
+		return err
+	}
+	userData := pulumi.All(flag, namedFlags).ApplyT(func(args []any) (string, error) {
+		return userDataFor(args[0].(string), args[1].(map[string]string))
+	}).(pulumi.StringOutput)
 
 	// ── Security Group ────────────────────────────────────────
 	// 若提供 security_group_id，使用預建的共用 SG（省 ~3-5s）
 	// 否則動態建立 per-player SG
-	sharedSGID := configOrEnv(req, "security_group_id", "CHALLENGE_SECURITY_GROUP_ID", "")
+	sharedSGID := conf.String("security_group_id")
 
 	var sgID pulumi.IDOutput
 	if sharedSGID != "" {
@@ -150,11 +294,13 @@ func run(req *sdk.Request, resp *sdk.Response, opts ...pulumi.ResourceOption) er
 		// 動態建立 per-player SG
 		sg, err := networking.NewSecGroup(ctx, prefix+"-sg", &networking.SecGroupArgs{
 			Name:        pulumi.String(prefix + "-sg"),
-			Description: pulumi.Sprintf("CTF sg for identity=%s", identity),
-		}, withProv()...)
+			Description: pulumi.String(own.Description()),
+			Tags:        own.Tags(),
+		}, withProv(tagOpt)...)
 		if err != nil {
 			return err
 		}
+		tm.track("security_group", sg)
 
 		// 允許題目 Port
 		if _, err = networking.NewSecGroupRule(ctx, prefix+"-sg-chall", &networking.SecGroupRuleArgs{
@@ -169,6 +315,36 @@ func run(req *sdk.Request, resp *sdk.Response, opts ...pulumi.ResourceOption) er
 			return err
 		}
 
+		// ports 宣告的具名 port
+		for _, p := range conn.Ports {
+			if _, err = networking.NewSecGroupRule(ctx, prefix+"-sg-"+p.Name, &networking.SecGroupRuleArgs{
+				Direction:       pulumi.String("ingress"),
+				Ethertype:       pulumi.String("IPv4"),
+				Protocol:        pulumi.String("tcp"),
+				PortRangeMin:    pulumi.Int(p.Port),
+				PortRangeMax:    pulumi.Int(p.Port),
+				RemoteIpPrefix:  pulumi.String("0.0.0.0/0"),
+				SecurityGroupId: sg.ID(),
+			}, withProv()...); err != nil {
+				return err
+			}
+		}
+
+		// ssh 注入模式：允許 chall-manager 以 SSH 寫入 flag（注入後公鑰即撤銷）
+		if delivery.Injected() {
+			if _, err = networking.NewSecGroupRule(ctx, prefix+"-sg-inject", &networking.SecGroupRuleArgs{
+				Direction:       pulumi.String("ingress"),
+				Ethertype:       pulumi.String("IPv4"),
+				Protocol:        pulumi.String("tcp"),
+				PortRangeMin:    pulumi.Int(flagInjectPort),
+				PortRangeMax:    pulumi.Int(flagInjectPort),
+				RemoteIpPrefix:  pulumi.String(delivery.InjectCIDR),
+				SecurityGroupId: sg.ID(),
+			}, withProv()...); err != nil {
+				return err
+			}
+		}
+
 		// 允許 ICMP
 		if _, err = networking.NewSecGroupRule(ctx, prefix+"-sg-icmp", &networking.SecGroupRuleArgs{
 			Direction:       pulumi.String("ingress"),
@@ -187,141 +363,352 @@ func run(req *sdk.Request, resp *sdk.Response, opts ...pulumi.ResourceOption) er
 	// Port 明確建立：SG 綁定 + 取得 IP + FIP 關聯都需要
 	// ConfigDrive: metadata 直接掛載為 ISO，cloud-init 不用等 DHCP 取 metadata（省 ~20s）
 	// ForceDelete: destroy 時跳過 graceful shutdown
+	// port_forward 模式建立時一併標記（portForwardTag，見 expose.go）
+	portTags := own.TagList()
+	if expose.Mode == exposePortForward {
+		portTags = append(portTags, portForwardMarker(expose.SharedFIP))
+	}
 	port, err := networking.NewPort(ctx, prefix+"-port", &networking.PortArgs{
 		NetworkId:        pulumi.String(networkID),
 		SecurityGroupIds: pulumi.StringArray{sgID},
 		AdminStateUp:     pulumi.Bool(true),
-	}, withProv()...)
+		Tags:             pulumi.ToStringArray(portTags),
+	}, withProv(tagOpt)...)
 	if err != nil {
 		return err
 	}
+	tm.track("port", port, sgID)
 
 	instanceArgs := &compute.InstanceArgs{
 		Name:        pulumi.String(prefix),
 		FlavorName:  pulumi.String(flavorName),
-		UserData:    pulumi.String(userData),
+		UserData:    userData,
 		ConfigDrive: pulumi.Bool(true),
 		ForceDelete: pulumi.Bool(true),
+		Metadata:    own.Metadata(),
+		Tags:        own.Tags(),
 		Networks: compute.InstanceNetworkArray{
 			&compute.InstanceNetworkArgs{
 				Port: port.ID(),
 			},
 		},
 	}
-	if bootFromVolume {
-		instanceArgs.BlockDevices = compute.InstanceBlockDeviceArray{
-			&compute.InstanceBlockDeviceArgs{
-				Uuid:                pulumi.String(imageID),
-				SourceType:          pulumi.String("image"),
-				DestinationType:     pulumi.String("volume"),
-				VolumeSize:          pulumi.Int(volumeSize),
-				BootIndex:           pulumi.Int(0),
-				DeleteOnTermination: pulumi.Bool(true),
-			},
+	if placement.AvailabilityZone != "" {
+		instanceArgs.AvailabilityZone = pulumi.String(placement.AvailabilityZone)
+	}
+	// soft-anti-affinity server group：並發開機分散到不同 compute node
+	if hints := placement.schedulerHints(serverGroupID); hints != nil {
+		instanceArgs.SchedulerHints = hints
+	}
+	instanceOpts := withProv(ignoreOwnership("metadata", "tags"))
+	if boot.Enabled() {
+		// 有備援候選時由 scenario 直接開機（ERROR 就換下一個候選），成功後 import 進 stack。
+		// import 會忽略 blockDevices，clone 由 bootWithFallback 每次嘗試各自建立，這裡只填 source ID。
+		// preview 只查詢可沿用的 instance：要重新開機時 ID / flavor / AZ 為 unknown（見 boot.go）。
+		device.Apply(instanceArgs, pulumi.String(device.SourceID))
+		spec := bootSpec{
+			Name:      prefix,
+			Device:    device,
+			Metadata:  own.MetadataMap(),
+			Tags:      own.TagList(),
+			Hints:     placement.apiSchedulerHints(serverGroupID),
+			DiagLines: diagLines,
+		}
+		booted := pulumi.All(port.ID(), userData).ApplyTWithContext(ctx.Context(), func(c context.Context, args []any) (pulumi.AnyOutput, error) {
+			spec.PortID = string(args[0].(pulumi.ID))
+			spec.UserData = args[1].(string)
+			if ctx.DryRun() {
+				r, err := api.previewBoot(c, spec, boot)
+				if err != nil || r == nil {
+					return pulumi.UnsafeUnknownOutput(nil).(pulumi.AnyOutput), err
+				}
+				return pulumi.Any(*r), nil
+			}
+			r, err := api.bootWithFallback(c, spec, boot)
+			return pulumi.Any(r), tm.fail(err)
+		}).(pulumi.AnyOutput)
+		// 以實際開機的 flavor / AZ 作為輸入：與 import 讀回的值一致，變更主 flavor / AZ 由 ctf-boot-spec 觸發重新開機
+		instanceArgs.FlavorName = booted.ApplyT(func(v any) string {
+			return v.(bootResult).Flavor
+		}).(pulumi.StringOutput)
+		instanceArgs.AvailabilityZone = booted.ApplyT(func(v any) *string {
+			if az := v.(bootResult).AvailabilityZone; az != "" {
+				return &az
+			}
+			return nil
+		}).(pulumi.StringPtrOutput)
+		bootedID := booted.ApplyT(func(v any) pulumi.ID {
+			return pulumi.ID(v.(bootResult).ID)
+		}).(pulumi.IDOutput)
+		instanceOpts = append(instanceOpts, pulumi.Import(bootedID), pulumi.IgnoreChanges(adoptedInstanceIgnore))
+	} else if device.IsClone() {
+		// 預建 source volume 的 Cinder clone（CoW backend 數秒完成），instance 刪除時一併刪除
+		volArgs := &blockstorage.VolumeArgs{
+			Name:        pulumi.String(prefix + "-vol"),
+			Size:        pulumi.Int(device.Size),
+			SourceVolId: pulumi.String(device.SourceID),
+			Metadata:    own.Metadata(),
 		}
+		if device.VolumeType != "" {
+			volArgs.VolumeType = pulumi.String(device.VolumeType)
+		}
+		if placement.AvailabilityZone != "" {
+			volArgs.AvailabilityZone = pulumi.String(placement.AvailabilityZone)
+		}
+		vol, err := blockstorage.NewVolume(ctx, prefix+"-vol", volArgs, withProv(ignoreOwnership("metadata"))...)
+		if err != nil {
+			return err
+		}
+		tm.track("volume", vol)
+		device.Apply(instanceArgs, vol.ID().ToStringOutput())
 	} else {
-		instanceArgs.ImageId = pulumi.String(imageID)
+		device.Apply(instanceArgs, nil)
 	}
-	_, err = compute.NewInstance(ctx, prefix+"-vm", instanceArgs, withProv()...)
+	instance, err := compute.NewInstance(ctx, prefix+"-vm", instanceArgs, instanceOpts...)
 	if err != nil {
 		return err
 	}
+	tm.track("instance", instance, port.ID(), userData)
 
-	// ── IP 取得（FIP 或內網 IP）────────────────────────────────
+	// ── 建立監看：instance 進入 ERROR 時回傳 Nova fault + console log ──
+	// 以 port ID 觸發（port 建好後 provider 才開始建立 instance）
+	// fallback 模式由 bootWithFallback 自行處理 ERROR，不需監看
+	buildDiag := pulumi.String("").ToStringOutput()
+	if !ctx.DryRun() && !boot.Enabled() {
+		buildDiag = port.ID().ApplyTWithContext(ctx.Context(), func(c context.Context, _ pulumi.ID) (string, error) {
+			return "", tm.fail(watchBuild(c, api, prefix, diagLines))
+		}).(pulumi.StringOutput)
+	}
+
+	// ── IP 取得（FIP / 內網 IP / 共用 FIP port forwarding，見 expose.go）────
+	fixedIP := port.AllFixedIps.ApplyT(func(ips []string) string {
+		if len(ips) > 0 {
+			return ips[0]
+		}
+		return "unknown"
+	}).(pulumi.StringOutput)
 	var connAddr pulumi.StringOutput
+	// port 名稱 → 對外 port（fip / fixed 與 VM 上相同）
+	connPorts := pulumi.ToIntMap(conn.InternalPorts(challengePort)).ToIntMapOutput()
 
-	if useFIP {
+	switch expose.Mode {
+	case exposeFIP:
 		if fipAddress != "" {
-			_, err = networking.NewFloatingIpAssociate(ctx, prefix+"-fip-assoc", &networking.FloatingIpAssociateArgs{
+			assoc, err := networking.NewFloatingIpAssociate(ctx, prefix+"-fip-assoc", &networking.FloatingIpAssociateArgs{
 				FloatingIp: pulumi.String(fipAddress),
 				PortId:     port.ID(),
 			}, withProv()...)
 			if err != nil {
 				return err
 			}
+			tm.track("fip", assoc, port.ID())
 			connAddr = pulumi.String(fipAddress).ToStringOutput()
+		} else if pooledFIP.Enabled() {
+			// 從預分配 pool 認領未關聯的 FIP（見 fippool.go），destroy 時只解除關聯
+			// preview 不認領：尚未關聯時位址為 unknown
+			claimed := port.ID().ApplyTWithContext(ctx.Context(), func(c context.Context, portID pulumi.ID) (pulumi.StringOutput, error) {
+				addr, err := api.claimFloatingIP(c, pooledFIP, string(portID), identity, ctx.DryRun())
+				if err == nil && addr == "" {
+					return unknownString(), nil
+				}
+				return pulumi.String(addr).ToStringOutput(), err
+			}).(pulumi.StringOutput)
+			assoc, err := networking.NewFloatingIpAssociate(ctx, prefix+"-fip-assoc", &networking.FloatingIpAssociateArgs{
+				FloatingIp: claimed,
+				PortId:     port.ID(),
+			}, withProv()...)
+			if err != nil {
+				return err
+			}
+			tm.track("fip", assoc, claimed)
+			connAddr = claimed
 		} else {
 			fip, err := networking.NewFloatingIp(ctx, prefix+"-fip", &networking.FloatingIpArgs{
 				Pool:   pulumi.String(fipPool),
 				PortId: port.ID(),
-			}, withProv()...)
+				Tags:   own.Tags(),
+			}, withProv(tagOpt)...)
 			if err != nil {
 				return err
 			}
+			tm.track("fip", fip, port.ID())
 			connAddr = fip.Address
 		}
-	} else {
-		connAddr = port.AllFixedIps.ApplyT(func(ips []string) string {
-			if len(ips) > 0 {
-				return ips[0]
+	case exposePortForward:
+		// 共用 FIP 上分配外部 port → VM fixed IP + 題目 port，forwarding 隨 port 刪除
+		// ports 宣告的具名 port 各自分配一個外部 port
+		connAddr = pulumi.String(expose.SharedFIP).ToStringOutput()
+		connPorts = pulumi.All(port.ID(), fixedIP, port.AllTags).ApplyTWithContext(ctx.Context(), func(c context.Context, args []any) (map[string]int, error) {
+			// 標記 port 使用過 port_forward，之後離開此模式時才知道要清理
+			if err := markPortForward(c, api, string(args[0].(pulumi.ID)), expose.SharedFIP, args[2].([]string), ctx.DryRun()); err != nil {
+				return nil, tm.fail(err)
 			}
-			return "unknown"
-		}).(pulumi.StringOutput)
+			ext := map[string]int{}
+			keep := map[int]bool{}
+			for name, internal := range conn.InternalPorts(challengePort) {
+				keep[internal] = true
+				key := identity
+				if name != conninfo.PrimaryPort {
+					key += "/" + name
+				}
+				p, err := api.allocatePortForward(c, expose, portForwardRequest{
+					InternalPortID: string(args[0].(pulumi.ID)),
+					InternalIP:     args[1].(string),
+					InternalPort:   internal,
+					Description:    own.Description(),
+					Key:            key,
+					DryRun:         ctx.DryRun(),
+				})
+				if err != nil {
+					return nil, tm.fail(err)
+				}
+				ext[name] = p
+			}
+			// ports 移除的具名 port 留下的 forwarding
+			err := api.releasePortForwards(c, expose.SharedFIP, string(args[0].(pulumi.ID)), keep, ctx.DryRun())
+			return ext, tm.fail(err)
+		}).(pulumi.IntMapOutput)
+	case exposeLoadBalancer:
+		// 共用 Octavia listener：<shortID>.<lb_domain> → 此 VM（見 lb.go），VM 不需要 FIP
+		lbID, err := api.listenerLoadBalancer(ctx.Context(), expose.LB.ListenerID)
+		if err != nil {
+			return err
+		}
+		host := expose.LB.Hostname(shortID)
+		if err := newLoadBalancerFrontend(ctx, prefix, expose.LB, lbID, host, fixedIP, challengePort, withProv()...); err != nil {
+			return err
+		}
+		connAddr = pulumi.String(host).ToStringOutput()
+		connPorts = pulumi.IntMap{conninfo.PrimaryPort: pulumi.Int(expose.LB.Port())}.ToIntMapOutput()
+	default:
+		connAddr = fixedIP
+	}
+
+	// 離開 port_forward 模式：forwarding 不在 state 中，port 帶有 port_forward 標記時刪除它在共用 FIP 上留下的 forwarding。
+	// 串在 connPorts 之後：清理失敗時部署失敗，不會把仍可從共用 FIP 連到的 VM 當成已切換
+	if expose.Mode != exposePortForward {
+		connPorts = pulumi.All(port.ID(), port.AllTags, connPorts).ApplyTWithContext(ctx.Context(), func(c context.Context, args []any) (map[string]int, error) {
+			err := leavePortForward(c, api, string(args[0].(pulumi.ID)), own.CreatedTag(), args[1].([]string), ctx.DryRun())
+			return args[2].(map[string]int), tm.fail(err)
+		}).(pulumi.IntMapOutput)
+	}
+
+	connPort := connPorts.MapIndex(pulumi.String(conninfo.PrimaryPort))
+
+	// ── DNS 記錄（見 dns.go）：connection_info 改用 hostname，readiness 仍檢查 IP ──
+	connHost := connAddr
+	if dnsCfg.Enabled() && expose.Mode != exposeLoadBalancer {
+		backend, err := newDNSBackend(ctx.Context(), dnsCfg, api)
+		if err != nil {
+			return err
+		}
+		host := dnsCfg.Hostname(shortID)
+		if err := backend.Register(ctx, prefix+"-dns", dnsRecord{
+			FQDN:        host,
+			Address:     connAddr,
+			TTL:         dnsCfg.TTL,
+			Description: own.Description(),
+		}, withProv()...); err != nil {
+			return err
+		}
+		connHost = pulumi.String(host).ToStringOutput()
 	}
 
 	// ── Readiness Check（可配置）─────────────────────────────
 	// readiness_timeout=0（預設）：跳過檢查，立即回傳（最快啟動，搭配 Pooler 使用）
-	// readiness_timeout>0：等待 TCP port 就緒（保守模式）
-	resp.ConnectionInfo = connAddr.ApplyT(func(ip string) string {
-		if readinessTimeout > 0 {
-			waitForPort(ip, challengePort, readinessTimeout)
+	// readiness_timeout>0：依 readiness_check 等待服務就緒（保守模式）
+	//   readiness_failure=warn：逾時只 log warning，仍回傳 connection_info
+	//   readiness_failure=fail：逾時讓 deployment 失敗，不把壞掉的位址交給玩家
+	//   readiness_check=console：等 cloud-init 完成（flag 已寫入）的 console marker
+	//   未就緒時附帶 Nova fault + console log（error 與 stack output "diagnostics"）
+	ready := pulumi.All(connAddr, instance.ID(), connPorts, connHost, fixedIP, flag, namedFlags).ApplyTWithContext(ctx.Context(), func(c context.Context, args []any) (_ map[string]string, err error) {
+		defer func() { tm.fail(err) }() // 失敗時帶 error 結束 trace（見 trace.go）
+		ip, extPorts, host, flag := args[0].(string), args[2].(map[string]int), args[3].(string), args[5].(string)
+		target := readinessTarget{Host: ip, Port: extPorts[conninfo.PrimaryPort], ServerID: string(args[1].(pulumi.ID))}
+		if delivery.Injected() {
+			// 先寫入 flag 再做 readiness，就緒即代表 flag 已在 VM 上。
+			// 共用 FIP / LB 模式的對外位址不轉發 22 port，改連內網 IP
+			injectHost := ip
+			if expose.Mode != exposeFIP && expose.Mode != exposeFixed {
+				injectHost = args[4].(string)
+			}
+			signer, err := delivery.injectSigner(identity, flag)
+			if err != nil {
+				return nil, err
+			}
+			files := delivery.flagFiles(flagPath, flag, flags.Specs, args[6].(map[string]string))
+			tm.rec.Mark("flag_inject", timing.Requested)
+			if err := api.injectFlag(c, delivery, injectHost, target.ServerID, signer, files); err != nil {
+				return nil, fmt.Errorf("%w\n%s", err, api.diagnose(c, target.ServerID, diagLines))
+			}
+			tm.rec.Mark("flag_inject", timing.Ready)
 		}
-		return formatConnectionInfo(connTpl, ip, challengePort)
-	}).(pulumi.StringOutput)
-	ctx.Export("ssh_command", connAddr.ApplyT(func(ip string) string {
+		diag := ""
+		if readiness.Enabled() {
+			tm.rec.Mark("readiness", timing.Requested)
+			if err := e.waitReady(tm.tr.Context(c, "readiness"), readiness.Checker, target, readiness.Timeout); err != nil {
+				diag = api.diagnose(c, target.ServerID, diagLines).String()
+				if readiness.Failure == readinessFailureFail {
+					return nil, fmt.Errorf("%w\n%s", err, diag)
+				}
+				fmt.Printf("WARNING: %v\n%s\n", err, diag)
+			} else {
+				tm.rec.Mark("readiness", timing.Ready)
+				tm.rec.Mark("instance", timing.Ready)
+			}
+		}
+		consoleURL, consoleExpires := "", time.Time{}
+		if console.Enabled() {
+			url, err := api.remoteConsoleURL(c, target.ServerID, console.Type)
+			if err != nil {
+				return nil, err
+			}
+			consoleURL, consoleExpires = url, console.ExpiresAt(e.now())
+		}
+		// 模板資料見 connection.go；DNS / loadbalancer 模式才有 hostname
+		hostname := ""
+		if dnsCfg.Enabled() || expose.Mode == exposeLoadBalancer {
+			hostname = host
+		}
+		info, err := conn.Render(conn.data(ip, hostname, extPorts, shortID, connectionExtra{
+			ConsoleURL:       consoleURL,
+			ConsoleExpiresAt: consoleExpires,
+			ServerID:         target.ServerID,
+			FixedIP:          args[4].(string),
+		}))
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{
+			"connection_info": info,
+			"console_url":     consoleURL,
+			"console_expires": formatConsoleExpires(consoleExpires),
+			"diagnostics":     diag,
+		}, nil
+	}).(pulumi.StringMapOutput)
+	resp.ConnectionInfo = ready.MapIndex(pulumi.String("connection_info"))
+	ctx.Export("diagnostics", pulumi.All(buildDiag, ready.MapIndex(pulumi.String("diagnostics"))).ApplyT(func(args []any) string {
+		return strings.TrimSpace(args[0].(string) + "\n" + args[1].(string))
+	}).(pulumi.StringOutput))
+	ctx.Export("ssh_command", connHost.ApplyT(func(ip string) string {
 		return "ssh ubuntu@" + ip
 	}).(pulumi.StringOutput))
 	ctx.Export("connection_ip", connAddr)
+	ctx.Export("connection_hostname", connHost)
+	ctx.Export("console_url", ready.MapIndex(pulumi.String("console_url")))
+	ctx.Export("console_expires", ready.MapIndex(pulumi.String("console_expires")))
+	ctx.Export("connection_port", connPort)
+	ctx.Export("connection_ports", connPorts)
 
-	resp.Flag = pulumi.String(flag).ToStringOutput()
-	return nil
-}
-
-// generateUserData 產生 cloud-init user_data，將動態 flag 注入 VM
-//
-// 若提供 customScript，替換佔位符後直接使用（支援 shell script 或 cloud-config）。
-// 否則產生預設的 cloud-config，只寫入 flag 檔案（搭配 snapshot 使用時 < 5 秒）。
-func generateUserData(flag, flagPath string, port int, identity, customScript string) string {
-	if customScript != "" {
-		r := strings.NewReplacer(
-			"{{FLAG}}", flag,
-			"{{FLAG_PATH}}", flagPath,
-			"{{PORT}}", strconv.Itoa(port),
-			"{{IDENTITY}}", identity,
-		)
-		return r.Replace(customScript)
-	}
+	// 具名 flag（flags）：name → flag，對應 CTFd 子題目；主要 flag 仍為 resp.Flag。
+	// 不使用 "flags"，避免與 SDK 輸出的 key 衝突
+	ctx.Export("named_flags", namedFlags)
 
-	// 預設 cloud-config：只寫 flag（最小化，搭配 Packer snapshot 使用）
-	return fmt.Sprintf(`#cloud-config
-write_files:
-  - path: %s
-    content: |
-      %s
-    permissions: '0444'
-    owner: root:root
-`, flagPath, flag)
-}
-
-// configOrEnv 從 additional config 讀取，fallback 到環境變數，再 fallback 到預設值
-func configOrEnv(req *sdk.Request, key, envKey, defaultVal string) string {
-	if v, ok := req.Config.Additional[key]; ok && v != "" {
-		return v
-	}
-	if envKey != "" {
-		if v := os.Getenv(envKey); v != "" {
-			return v
-		}
-	}
-	return defaultVal
-}
+	// 各階段時間：等 readiness 結束（ready）後才匯出，summary 包含整個部署
+	tm.export(ctx, ready)
 
-func requireEnv(key string) string {
-	v := os.Getenv(key)
-	if v == "" {
-		panic(fmt.Sprintf("required environment variable %q is not set", key))
-	}
-	return v
+	resp.Flag = flag
+	return nil
 }
 
 func envOrDefault(key, def string) string {
@@ -330,27 +717,3 @@ func envOrDefault(key, def string) string {
 	}
 	return def
 }
-
-// formatConnectionInfo 根據模板產生連線資訊
-// 支援 {ip} 和 {port} 佔位符，例如 "http://{ip}:{port}" → "http://1.2.3.4:8080"
-func formatConnectionInfo(tpl, ip string, port int) string {
-	r := strings.NewReplacer("{ip}", ip, "{port}", strconv.Itoa(port))
-	return r.Replace(tpl)
-}
-
-// waitForPort 等待 TCP port 可連線（服務就緒）
-// 適用於所有題型：HTTP、SSH、TCP/NC
-func waitForPort(host string, port int, timeout time.Duration) {
-	addr := fmt.Sprintf("%s:%d", host, port)
-	deadline := time.Now().Add(timeout)
-	for time.Now().Before(deadline) {
-		conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
-		if err == nil {
-			conn.Close()
-			return
-		}
-		time.Sleep(1 * time.Second)
-	}
-	// timeout 不 fail deployment，只是 log warning
-	fmt.Printf("WARNING: readiness check timed out for %s (waited %s)\n", addr, timeout)
-}
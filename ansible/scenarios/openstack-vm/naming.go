@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+
+	"github.com/ctferio/scenarios/scenarioconfig"
+	"github.com/ctferio/scenarios/scenarioconfig/naming"
+)
+
+// instance 命名：資源 prefix ctf-<shortID>、DNS / LB hostname <shortID>.<zone> 都由此產生
+// （hash 與長度見 scenarioconfig/naming）
+//
+// additional keys（通常以環境變數設為全域預設）：
+//
+//	instance_id_hash    md5（預設）/ sha256（CHALLENGE_INSTANCE_ID_HASH）
+//	instance_id_length  shortID 長度 8-32（預設 8，CHALLENGE_INSTANCE_ID_LENGTH）
+//	instance_id_scope   challenge（預設，hash 加入 challenge_id，沒有時用 image_id）/ none（舊版）
+//	                    （CHALLENGE_INSTANCE_ID_SCOPE）
+//
+// shortID 是 Pulumi 資源名稱的 prefix，改變設定會讓既有 stack 的資源改名重建：預設含題目範圍，
+// 升級前已有 instance 的部署需設定 instance_id_scope=none 保留舊版的 md5(identity)[:8]，
+// 在沒有存活 instance 時再移除（見 README「instance 命名」）。
+//
+// Nova 允許同名 instance，boot_attempts 等直接開機流程會沿用同名的 ACTIVE instance，
+// 因此建立資源前以名稱查詢 Nova，既有 instance 的 ctf-identity metadata 屬於其他 identity 時直接失敗。
+// 舊版命名（instance_id_scope=none 且 md5 / 8）不查詢：同名 instance 即此 identity 升級前建立者
+// （沒有 ctf-identity 也放行），檢查幾乎不會失敗，卻讓每次部署與 preview 都多一次 Keystone 認證與 Nova 查詢。
+
+// parseNaming 由 additional 建立 naming.Config
+func parseNaming(conf *scenarioconfig.Values, imageID string) naming.Config {
+	cfg := naming.Config{
+		Hash:   conf.String("instance_id_hash"),
+		Length: conf.Int("instance_id_length"),
+	}
+	if conf.String("instance_id_scope") == naming.ScopeChallenge {
+		cfg.Scope = challengeKey(conf, imageID)
+	}
+	return cfg
+}
+
+// checkNameOwner 同名 instance 已存在且不屬於此 identity 時回傳錯誤
+func (c *cloudAPI) checkNameOwner(ctx context.Context, names naming.Config, name, identity string) error {
+	s, err := c.findServer(ctx, name)
+	if err != nil || s == nil {
+		return err
+	}
+	return names.CheckOwner("instance", name+" ("+s.ID+")", s.Metadata["ctf-identity"], identity)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ctferio/scenarios/scenarioconfig/naming"
+	"github.com/gophercloud/gophercloud/v2"
+	gcopenstack "github.com/gophercloud/gophercloud/v2/openstack"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"golang.org/x/crypto/ssh"
+)
+
+// deployEnv run 依賴的外部環境：實際部署為 liveEnv，plan 子命令注入不連線的版本（見 plan.go）
+type deployEnv struct {
+	// auth OpenStack 認證（provider 與 cloud 共用，見 provider.go）
+	auth func() (*openstackAuth, error)
+	// newCloud 建立 provider 之外直接呼叫的 OpenStack API
+	newCloud func(auth *openstackAuth) cloud
+	// waitReady readiness 輪詢（見 readiness.go）
+	waitReady func(ctx context.Context, checker readinessChecker, t readinessTarget, timeout time.Duration) error
+	// now 部署時間（ownership 時間戳、帳密衍生）
+	now func() time.Time
+}
+
+var liveEnv = deployEnv{
+	auth:      resolveAuth,
+	newCloud:  func(auth *openstackAuth) cloud { return newCloudAPI(auth) },
+	waitReady: waitReady,
+	now:       time.Now,
+}
+
+// cloud run 在 Pulumi 資源之外對 OpenStack 做的查詢與操作，實作為 cloudAPI（plan 為 offlineCloud）
+type cloud interface {
+	// 查詢（diagnostics.go / naming.go / volume.go / lb.go / dns.go）
+	findServer(ctx context.Context, name string) (*servers.Server, error)
+	getServer(ctx context.Context, id string) (*servers.Server, error)
+	checkNameOwner(ctx context.Context, names naming.Config, name, identity string) error
+	bootSourceSize(ctx context.Context, dev bootDevice) (int, error)
+	listenerLoadBalancer(ctx context.Context, listenerID string) (string, error)
+	dnsZoneID(ctx context.Context, zone string) (string, error)
+	consoleOutput(ctx context.Context, serverID string, lines int) (string, error)
+	diagnose(ctx context.Context, serverID string, lines int) serverDiagnostics
+
+	// 開機與排程（boot.go / placement.go）
+	previewBoot(ctx context.Context, spec bootSpec, p bootPolicy) (*bootResult, error)
+	bootWithFallback(ctx context.Context, spec bootSpec, p bootPolicy) (bootResult, error)
+	ensureServerGroup(ctx context.Context, name, policy string, dryRun bool) (string, error)
+
+	// 對外（fippool.go / expose.go）
+	claimFloatingIP(ctx context.Context, cfg fipPoolConfig, portID, key string, dryRun bool) (string, error)
+	allocatePortForward(ctx context.Context, cfg exposeConfig, r portForwardRequest) (int, error)
+	releasePortForwards(ctx context.Context, sharedFIP, portID string, keep map[int]bool, dryRun bool) error
+	portTags(ctx context.Context, portID string) ([]string, error)
+	tagPort(ctx context.Context, portID, tag string) error
+	untagPort(ctx context.Context, portID, tag string) error
+
+	// 開機後（flagdelivery.go / console.go）
+	injectFlag(ctx context.Context, d flagDelivery, host, serverID string, signer ssh.Signer, files []flagFile) error
+	remoteConsoleURL(ctx context.Context, serverID, typ string) (string, error)
+}
+
+// cloudAPI 直接呼叫 OpenStack API（gophercloud），補足 pulumi-openstack 沒有的功能。
+// client 延遲建立：只有實際需要時（例如 console readiness）才向 Keystone 認證，
+// 不影響一般部署的啟動時間。認證結果在各 service client 間共用。
+type cloudAPI struct {
+	auth *openstackAuth
+
+	mu      sync.Mutex
+	pc      *gophercloud.ProviderClient
+	eo      gophercloud.EndpointOpts
+	clients map[string]*gophercloud.ServiceClient
+}
+
+func newCloudAPI(auth *openstackAuth) *cloudAPI {
+	return &cloudAPI{auth: auth, clients: map[string]*gophercloud.ServiceClient{}}
+}
+
+// serviceClient 回傳（必要時建立）指定 service 的 client；失敗不快取，下次呼叫會重試
+func (c *cloudAPI) serviceClient(ctx context.Context, service string,
+	newClient func(*gophercloud.ProviderClient, gophercloud.EndpointOpts) (*gophercloud.ServiceClient, error),
+) (*gophercloud.ServiceClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if sc, ok := c.clients[service]; ok {
+		return sc, nil
+	}
+	if c.pc == nil {
+		pc, eo, err := c.auth.providerClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.pc, c.eo = pc, eo
+	}
+	sc, err := newClient(c.pc, c.eo)
+	if err != nil {
+		return nil, fmt.Errorf("%s client: %w", service, err)
+	}
+	c.clients[service] = sc
+	return sc, nil
+}
+
+func (c *cloudAPI) computeClient(ctx context.Context) (*gophercloud.ServiceClient, error) {
+	return c.serviceClient(ctx, "compute", gcopenstack.NewComputeV2)
+}
+
+func (c *cloudAPI) blockStorageClient(ctx context.Context) (*gophercloud.ServiceClient, error) {
+	return c.serviceClient(ctx, "volumev3", gcopenstack.NewBlockStorageV3)
+}
+
+func (c *cloudAPI) networkClient(ctx context.Context) (*gophercloud.ServiceClient, error) {
+	return c.serviceClient(ctx, "network", gcopenstack.NewNetworkV2)
+}
+
+func (c *cloudAPI) loadBalancerClient(ctx context.Context) (*gophercloud.ServiceClient, error) {
+	return c.serviceClient(ctx, "load-balancer", gcopenstack.NewLoadBalancerV2)
+}
+
+func (c *cloudAPI) dnsClient(ctx context.Context) (*gophercloud.ServiceClient, error) {
+	return c.serviceClient(ctx, "dns", gcopenstack.NewDNSV2)
+}
+
+// consoleOutput 取得 instance console log 的最後 lines 行（lines <= 0 取全部）
+func (c *cloudAPI) consoleOutput(ctx context.Context, serverID string, lines int) (string, error) {
+	client, err := c.computeClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	opts := servers.ShowConsoleOutputOpts{}
+	if lines > 0 {
+		opts.Length = lines
+	}
+	out, err := servers.ShowConsoleOutput(ctx, client, serverID, opts).Extract()
+	if err != nil {
+		return "", fmt.Errorf("get console output of %s: %w", serverID, err)
+	}
+	return out, nil
+}
+
+// tailLines 回傳 s 的最後 n 行
+func tailLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ctferio/scenarios/scenarioconfig"
+	"github.com/ctferio/scenarios/scenarioconfig/naming"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// scenarioVersion 由 Ansible build 時以 -ldflags "-X main.scenarioVersion=<src hash>" 注入
+var scenarioVersion = "dev"
+
+const scenarioName = "openstack-vm"
+
+// Ownership metadata：每個資源都標記擁有者，當 chall-manager state 與實際資源不一致時
+// （例如 janitor 沒清掉的 instance）仍能歸屬與清理。
+//
+//	ctf-managed-by        chall-manager
+//	ctf-scenario          openstack-vm
+//	ctf-scenario-version  scenario source hash
+//	ctf-challenge-id      additional challenge_id（registration script 注入）
+//	ctf-source-id         additional source_id（有提供時）
+//	ctf-identity          sha256(identity) 前 16 hex（不直接暴露 identity）
+//	ctf-created-at        建立時間（RFC 3339, UTC）
+//	ctf-expires-at        建立時間 + instance_timeout（有提供時）
+//
+// Nova 使用 metadata（key/value），Neutron 使用 tags（"key=value"，每個 tag 最長 60 字元）。
+// 這些值只在建立時寫入，資源以 IgnoreChanges 忽略後續差異（避免 created-at 每次 up 都變）。
+type ownership struct {
+	values map[string]string
+}
+
+const neutronTagMaxLen = 60
+
+func newOwnership(conf *scenarioconfig.Values, identity string, now time.Time) *ownership {
+	o := &ownership{values: map[string]string{
+		"ctf-managed-by":       "chall-manager",
+		"ctf-scenario":         scenarioName,
+		"ctf-scenario-version": scenarioVersion,
+		"ctf-identity":         naming.IdentityHash(identity),
+		"ctf-created-at":       now.UTC().Format(time.RFC3339),
+	}}
+	if v := conf.String("challenge_id"); v != "" {
+		o.values["ctf-challenge-id"] = v
+	}
+	if v := conf.String("source_id"); v != "" {
+		o.values["ctf-source-id"] = v
+	}
+	if secs := conf.Int("instance_timeout"); secs > 0 {
+		o.values["ctf-expires-at"] = now.Add(time.Duration(secs) * time.Second).UTC().Format(time.RFC3339)
+	}
+	return o
+}
+
+// Metadata 回傳 Nova server metadata
+func (o *ownership) Metadata() pulumi.StringMap {
+	return pulumi.ToStringMap(o.values)
+}
+
+// MetadataMap 回傳 metadata 的一般 map（直接呼叫 Nova API 時使用）
+func (o *ownership) MetadataMap() map[string]string {
+	m := make(map[string]string, len(o.values))
+	for k, v := range o.values {
+		m[k] = v
+	}
+	return m
+}
+
+// Tags 回傳 Neutron / Nova tags（"key=value"，依 key 排序，超過長度上限的會截斷）
+func (o *ownership) Tags() pulumi.StringArray {
+	return pulumi.ToStringArray(o.TagList())
+}
+
+// TagList 回傳 Tags 的一般 slice（直接呼叫 Nova API 時使用）
+func (o *ownership) TagList() []string {
+	keys := make([]string, 0, len(o.values))
+	for k := range o.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tags := make([]string, 0, len(keys))
+	for _, k := range keys {
+		tag := k + "=" + o.values[k]
+		if len(tag) > neutronTagMaxLen {
+			tag = tag[:neutronTagMaxLen]
+		}
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// CreatedTag 回傳此次部署的 ctf-created-at tag：資源 tags 含此值代表是在此次部署建立
+func (o *ownership) CreatedTag() string {
+	return "ctf-created-at=" + o.values["ctf-created-at"]
+}
+
+// Description 回傳適合放進 description 欄位的摘要（SG 等沒有 tag 以外欄位的資源）
+func (o *ownership) Description() string {
+	return fmt.Sprintf("chall-manager %s challenge=%s identity=%s",
+		scenarioName, o.values["ctf-challenge-id"], o.values["ctf-identity"])
+}
+
+// ignoreOwnership 讓資源只在建立時寫入 ownership 欄位
+func ignoreOwnership(fields ...string) pulumi.ResourceOption {
+	return pulumi.IgnoreChanges(fields)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ctferio/scenarios/scenarioconfig"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servergroups"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/pulumi/pulumi-openstack/sdk/v3/go/openstack/compute"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// VM 排程設定：分散並發開機到不同 compute node，避免 Nova 在單一 node 上 RAM 不足而 ERROR
+//
+// additional keys：
+//
+//	availability_zone    Nova availability zone
+//	server_group         ""（不使用，預設）/ "auto"（每題共用，不存在時建立）/ 既有 server group UUID
+//	server_group_policy  auto 建立時的 policy（預設 soft-anti-affinity）
+//	scheduler_hints      其他 Nova scheduler hints，逗號分隔 key=value（同 key 可重複）
+//	                     例："different_host=<uuid>,different_host=<uuid>,query=[\">=\",\"$free_ram_mb\",1024]"
+//
+// server group 不由 Pulumi 管理：它跨 instance 共用，若放在某位玩家的 stack 中，
+// 該玩家 destroy 時就會被刪掉。scenario 以名稱 ctf-<challenge>-sg 查詢，不存在才透過 API 建立。
+const serverGroupAuto = "auto"
+
+// placementConfig 由 additional 解析出的排程設定
+type placementConfig struct {
+	AvailabilityZone  string
+	ServerGroup       string
+	ServerGroupPolicy string
+	Hints             map[string][]string
+}
+
+func parsePlacementConfig(conf *scenarioconfig.Values) placementConfig {
+	hints, _ := parseSchedulerHints(conf.String("scheduler_hints"))
+	return placementConfig{
+		AvailabilityZone:  conf.String("availability_zone"),
+		ServerGroup:       conf.String("server_group"),
+		ServerGroupPolicy: conf.String("server_group_policy"),
+		Hints:             hints,
+	}
+}
+
+// parseSchedulerHints 解析 "k=v,k=v"。value 內含逗號時（如 query JSON）請用 \, 跳脫。
+func parseSchedulerHints(raw string) (map[string][]string, error) {
+	hints := map[string][]string{}
+	if strings.TrimSpace(raw) == "" {
+		return hints, nil
+	}
+	for _, part := range splitEscaped(raw, ',') {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(part, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid scheduler_hints entry %q (want key=value)", part)
+		}
+		if k == "group" {
+			return nil, fmt.Errorf("scheduler_hints: use server_group instead of group")
+		}
+		hints[k] = append(hints[k], strings.TrimSpace(v))
+	}
+	return hints, nil
+}
+
+// splitEscaped 以 sep 分割字串，"\<sep>" 視為字面字元
+func splitEscaped(s string, sep byte) []string {
+	var out []string
+	var cur strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == sep:
+			cur.WriteByte(sep)
+			i++
+		case s[i] == sep:
+			out = append(out, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(s[i])
+		}
+	}
+	return append(out, cur.String())
+}
+
+// challengeKey 回傳題目層級的識別，用於跨 instance 共用的資源命名。
+// registration script 會注入 challenge_id；手動在 CTFd 建立的題目則以 image_id 推導。
+func challengeKey(conf *scenarioconfig.Values, imageID string) string {
+	if id := conf.String("challenge_id"); id != "" {
+		return sanitizeName(id)
+	}
+	h := md5.Sum([]byte(imageID))
+	return fmt.Sprintf("img-%x", h)[:12]
+}
+
+// sanitizeName 只保留 OpenStack / DNS 名稱安全的字元
+func sanitizeName(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('-')
+		}
+	}
+	out := strings.Trim(b.String(), "-")
+	if len(out) > 40 {
+		out = out[:40]
+	}
+	return out
+}
+
+// resolveServerGroup 回傳要放進 scheduler hint 的 server group ID（空字串表示不使用）。
+// "auto" 時以名稱查詢，不存在才建立（見 ensureServerGroup）；preview（dry run）只查詢不建立。
+func (c placementConfig) resolveServerGroup(ctx context.Context, api cloud, name string, dryRun bool) (string, error) {
+	if c.ServerGroup == "" {
+		return "", nil
+	}
+	if c.ServerGroup != serverGroupAuto {
+		return c.ServerGroup, nil
+	}
+	return api.ensureServerGroup(ctx, name, c.ServerGroupPolicy, dryRun)
+}
+
+// ensureServerGroup 以名稱查詢 server group，不存在且不是 dryRun 時以 policy 建立。
+// 多個 deployment 同時建立時可能產生同名 group，因此查詢結果固定取 ID 排序最小者，
+// 讓之後的 deployment 收斂到同一個 group。
+func (c *cloudAPI) ensureServerGroup(ctx context.Context, name, policy string, dryRun bool) (string, error) {
+	client, err := c.computeClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	// policies 欄位需要 compute microversion 2.15+（soft-* policy）
+	sc := *client
+	sc.Microversion = "2.15"
+
+	find := func() (string, error) {
+		pages, err := servergroups.List(&sc, servergroups.ListOpts{}).AllPages(ctx)
+		if err != nil {
+			return "", fmt.Errorf("list server groups: %w", err)
+		}
+		groups, err := servergroups.ExtractServerGroups(pages)
+		if err != nil {
+			return "", fmt.Errorf("list server groups: %w", err)
+		}
+		var ids []string
+		for _, g := range groups {
+			if g.Name == name {
+				ids = append(ids, g.ID)
+			}
+		}
+		if len(ids) == 0 {
+			return "", nil
+		}
+		sort.Strings(ids)
+		return ids[0], nil
+	}
+
+	id, err := find()
+	if err != nil || id != "" || dryRun {
+		return id, err
+	}
+	if _, err := servergroups.Create(ctx, &sc, servergroups.CreateOpts{
+		Name:     name,
+		Policies: []string{policy},
+	}).Extract(); err != nil {
+		return "", fmt.Errorf("create server group %s: %w", name, err)
+	}
+	return find()
+}
+
+// schedulerHints 組出 InstanceArgs.SchedulerHints（沒有任何 hint 時回傳 nil）
+func (c placementConfig) schedulerHints(groupID string) compute.InstanceSchedulerHintArray {
+	if groupID == "" && len(c.Hints) == 0 {
+		return nil
+	}
+	hint := &compute.InstanceSchedulerHintArgs{}
+	if groupID != "" {
+		hint.Group = pulumi.StringPtr(groupID)
+	}
+	extra := pulumi.StringMap{}
+	for k, vs := range c.Hints {
+		switch k {
+		case "different_host":
+			hint.DifferentHosts = pulumi.ToStringArray(vs)
+		case "same_host":
+			hint.SameHosts = pulumi.ToStringArray(vs)
+		case "different_cell":
+			hint.DifferentCells = pulumi.ToStringArray(vs)
+		case "query":
+			hint.Queries = pulumi.ToStringArray(vs)
+		case "target_cell":
+			hint.TargetCell = pulumi.StringPtr(vs[len(vs)-1])
+		case "build_near_host_ip":
+			hint.BuildNearHostIp = pulumi.StringPtr(vs[len(vs)-1])
+		default:
+			extra[k] = pulumi.String(strings.Join(vs, ","))
+		}
+	}
+	if len(extra) > 0 {
+		hint.AdditionalProperties = extra
+	}
+	return compute.InstanceSchedulerHintArray{hint}
+}
+
+// apiSchedulerHints 組出 Nova API 的 os:scheduler_hints（boot fallback 直接呼叫 API 時使用）
+func (c placementConfig) apiSchedulerHints(groupID string) servers.SchedulerHintOptsBuilder {
+	if groupID == "" && len(c.Hints) == 0 {
+		return nil
+	}
+	hints := rawSchedulerHints{}
+	if groupID != "" {
+		hints["group"] = groupID
+	}
+	for k, vs := range c.Hints {
+		if len(vs) == 1 {
+			hints[k] = vs[0]
+		} else {
+			hints[k] = vs
+		}
+	}
+	return hints
+}
+
+// rawSchedulerHints 原樣送出的 scheduler hints（gophercloud SchedulerHintOpts 會拒絕非 UUID 等寫法）
+type rawSchedulerHints map[string]any
+
+func (h rawSchedulerHints) ToSchedulerHintsMap() (map[string]any, error) {
+	return map[string]any{"os:scheduler_hints": map[string]any(h)}, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitEscaped(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{name: "empty", src: "", want: []string{""}},
+		{name: "plain", src: "a,b,c", want: []string{"a", "b", "c"}},
+		{name: "escaped separator", src: `a\,b,c`, want: []string{"a,b", "c"}},
+		{name: "other escapes kept", src: `a\n,b`, want: []string{`a\n`, "b"}},
+		{name: "trailing backslash", src: `a,b\`, want: []string{"a", `b\`}},
+		{name: "trailing separator", src: "a,", want: []string{"a", ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitEscaped(tt.src, ','); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSchedulerHints(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    map[string][]string
+		wantErr string
+	}{
+		{name: "empty", src: "  ", want: map[string][]string{}},
+		{
+			name: "repeated key",
+			src:  "different_host=a, different_host=b,target_cell=c1",
+			want: map[string][]string{"different_host": {"a", "b"}, "target_cell": {"c1"}},
+		},
+		{
+			name: "escaped comma in query",
+			src:  `query=[">="\,"$free_ram_mb"\,1024]`,
+			want: map[string][]string{"query": {`[">=","$free_ram_mb",1024]`}},
+		},
+		{name: "empty entries skipped", src: "a=1,,", want: map[string][]string{"a": {"1"}}},
+		{name: "empty value", src: "a=", want: map[string][]string{"a": {""}}},
+		{name: "missing value", src: "different_host", wantErr: "want key=value"},
+		{name: "missing key", src: "=x", wantErr: "want key=value"},
+		{name: "group rejected", src: "group=abc", wantErr: "use server_group"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSchedulerHints(tt.src)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSchedulerHints: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ctfer-io/chall-manager/sdk"
+	"github.com/ctferio/scenarios/scenarioconfig/naming"
+	"github.com/ctferio/scenarios/scenarioplan"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"golang.org/x/crypto/ssh"
+)
+
+// plan 子命令：以 Pulumi mock runtime 離線執行 run，不需要 OpenStack 帳號，也不建立任何資源（見 scenarioplan）
+//
+//	./main plan -additional challenge.yml [-identity team-1] [-set flag_delivery=ssh]
+//
+// 輸出 JSON：宣告的資源與 inputs、rendered.user_data（完整 cloud-init）、stack outputs 與 connection_info / flag。
+// run 以 planEnv 執行：cloud 為 offlineCloud，不呼叫 OpenStack API——名稱查詢視為不存在，
+// server group / DNS zone / LB 等查詢回傳以名稱產生的佔位 ID，readiness 與 SSH flag 注入略過。
+// 位址使用文件保留網段（RFC 5737），不會與實際環境混淆。
+
+const (
+	planFixedIP    = "192.0.2.10"   // port 的 fixed IP（TEST-NET-1）
+	planFloatingIP = "203.0.113.10" // floating IP / pool 認領的 FIP（TEST-NET-3）
+	planConsoleURL = "https://console.plan.invalid/"
+)
+
+// errOffline offlineCloud 不支援的操作（plan 視為 preview，不會呼叫）
+var errOffline = errors.New("OpenStack API is not available in plan mode")
+
+func planCommand(args []string) int {
+	mocks := scenarioplan.Mocks{Outputs: planOutputs, Render: planRender}
+	return scenarioplan.Command("openstack-vm", scenarioVersion, args, mocks, func(in scenarioplan.Input) scenarioplan.Body {
+		return func(ctx *pulumi.Context) (map[string]pulumi.Output, error) {
+			req := &sdk.Request{Ctx: ctx, Config: &sdk.Configuration{Identity: in.Identity, Additional: in.Additional}}
+			resp := &sdk.Response{}
+			if err := planEnv(in.Now).run(req, resp); err != nil {
+				return nil, err
+			}
+			return map[string]pulumi.Output{"connection_info": resp.ConnectionInfo, "flag": resp.Flag}, nil
+		}
+	})
+}
+
+// planEnv 不連線的 deployEnv；部署時間固定為 -now，輸出可重現
+func planEnv(now time.Time) deployEnv {
+	return deployEnv{
+		auth:     func() (*openstackAuth, error) { return planAuth(), nil },
+		newCloud: func(*openstackAuth) cloud { return offlineCloud{} },
+		waitReady: func(context.Context, readinessChecker, readinessTarget, time.Duration) error {
+			return nil
+		},
+		now: func() time.Time { return now },
+	}
+}
+
+// offlineCloud plan 使用的 cloud：不呼叫 OpenStack API，查詢回傳佔位值
+type offlineCloud struct{}
+
+func (offlineCloud) findServer(context.Context, string) (*servers.Server, error) { return nil, nil }
+
+func (offlineCloud) getServer(context.Context, string) (*servers.Server, error) { return nil, nil }
+
+func (offlineCloud) checkNameOwner(context.Context, naming.Config, string, string) error { return nil }
+
+// bootSourceSize 視為 available，大小以 volume_size 為準
+func (offlineCloud) bootSourceSize(context.Context, bootDevice) (int, error) { return 0, nil }
+
+func (offlineCloud) listenerLoadBalancer(_ context.Context, listenerID string) (string, error) {
+	return listenerID + "-loadbalancer-id", nil
+}
+
+func (offlineCloud) dnsZoneID(_ context.Context, zone string) (string, error) {
+	return zone + "-id", nil
+}
+
+func (offlineCloud) consoleOutput(context.Context, string, int) (string, error) {
+	return "", errOffline
+}
+
+func (offlineCloud) diagnose(_ context.Context, serverID string, _ int) serverDiagnostics {
+	return serverDiagnostics{ServerID: serverID, Errors: []string{errOffline.Error()}}
+}
+
+// previewBoot 沒有可沿用的 instance（同 preview 時名稱不存在）
+func (offlineCloud) previewBoot(context.Context, bootSpec, bootPolicy) (*bootResult, error) {
+	return nil, nil
+}
+
+func (offlineCloud) bootWithFallback(context.Context, bootSpec, bootPolicy) (bootResult, error) {
+	return bootResult{}, errOffline
+}
+
+func (offlineCloud) ensureServerGroup(_ context.Context, name, _ string, _ bool) (string, error) {
+	return name + "-id", nil
+}
+
+func (offlineCloud) claimFloatingIP(context.Context, fipPoolConfig, string, string, bool) (string, error) {
+	return planFloatingIP, nil
+}
+
+// allocatePortForward 不查詢既有 forwarding，回傳搜尋起點
+func (offlineCloud) allocatePortForward(_ context.Context, cfg exposeConfig, r portForwardRequest) (int, error) {
+	return cfg.PortMin + portForwardStart(cfg, r.Key), nil
+}
+
+func (offlineCloud) releasePortForwards(context.Context, string, string, map[int]bool, bool) error {
+	return nil
+}
+
+func (offlineCloud) portTags(context.Context, string) ([]string, error) { return nil, nil }
+
+func (offlineCloud) tagPort(context.Context, string, string) error { return nil }
+
+func (offlineCloud) untagPort(context.Context, string, string) error { return nil }
+
+func (offlineCloud) injectFlag(context.Context, flagDelivery, string, string, ssh.Signer, []flagFile) error {
+	return nil
+}
+
+func (offlineCloud) remoteConsoleURL(_ context.Context, serverID, typ string) (string, error) {
+	if _, err := consoleProtocol(typ); err != nil {
+		return "", err
+	}
+	return planConsoleURL + "?type=" + typ + "&server=" + serverID, nil
+}
+
+// planAuth 佔位的 password 認證：provider 資源的 inputs 照常輸出，不含實際帳密
+func planAuth() *openstackAuth {
+	return &openstackAuth{
+		Mode:              authModePassword,
+		AuthURL:           "https://keystone.plan.invalid/v3",
+		Region:            envOrDefault("OS_REGION_NAME", "RegionOne"),
+		UserName:          "plan",
+		Password:          "plan",
+		ProjectName:       "plan",
+		UserDomainName:    "Default",
+		ProjectDomainName: "Default",
+	}
+}
+
+// planOutputs scenario 會讀取的 provider 屬性
+func planOutputs(r scenarioplan.Resource) map[string]any {
+	switch r.Type {
+	case "openstack:networking/port:Port":
+		return map[string]any{"allFixedIps": []any{planFixedIP}, "allTags": r.Inputs["tags"]}
+	case "openstack:networking/floatingIp:FloatingIp":
+		return map[string]any{"address": planFloatingIP}
+	}
+	return nil
+}
+
+// planRender instance 的 cloud-init user-data
+func planRender(r scenarioplan.Resource) map[string]any {
+	if r.Type != "openstack:compute/instance:Instance" {
+		return nil
+	}
+	return map[string]any{"user_data": r.Inputs["userData"]}
+}
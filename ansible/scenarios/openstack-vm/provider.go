@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/v2"
+	gcopenstack "github.com/gophercloud/gophercloud/v2/openstack"
+	"github.com/gophercloud/gophercloud/v2/openstack/config"
+	"github.com/gophercloud/gophercloud/v2/openstack/config/clouds"
+	"github.com/pulumi/pulumi-openstack/sdk/v3/go/openstack"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// 支援的 OpenStack 認證模式（依優先順序判斷）：
+//
+//	clouds.yaml          OS_CLOUD 指定 entry，
+//	                     檔案路徑由 OS_CLIENT_CONFIG_FILE 指定（預設 ~/.config/openstack/clouds.yaml）
+//	application credential OS_APPLICATION_CREDENTIAL_ID（或 _NAME + OS_USERNAME）+ OS_APPLICATION_CREDENTIAL_SECRET
+//	token                OS_TOKEN（或 OS_AUTH_TOKEN）+ OS_PROJECT_NAME / OS_PROJECT_ID
+//	password             OS_USERNAME + OS_PASSWORD + OS_PROJECT_NAME（原本的行為）
+//
+// 除了 clouds.yaml 以外的模式都需要 OS_AUTH_URL。
+//
+// 同一份設定同時用於 Pulumi provider（建立資源）與 gophercloud client
+// （provider 沒有提供的 API，例如 console log）。
+const (
+	authModeCloud         = "clouds.yaml"
+	authModeAppCredential = "application_credential"
+	authModeToken         = "token"
+	authModePassword      = "password"
+)
+
+// openstackAuth 由環境變數解析出的認證設定
+type openstackAuth struct {
+	Mode   string
+	Cloud  string
+	Region string
+
+	AuthURL           string
+	UserName          string
+	UserID            string
+	Password          string
+	ProjectName       string
+	ProjectID         string
+	UserDomainName    string
+	ProjectDomainName string
+	AppCredID         string
+	AppCredName       string
+	AppCredSecret     string
+	Token             string
+}
+
+// resolveAuth 依環境變數選擇認證模式。
+// 缺少必要變數時回傳 error（不 panic），讓 chall-manager 能把原因回報給 CTFd。
+// 只讀取 chall-manager 的環境變數：additional 由出題者設定，不能選擇認證
+// （clouds.yaml 可能同時有管理者帳號的 entry）。
+func resolveAuth() (*openstackAuth, error) {
+	// ── clouds.yaml ─────────────────────────────────────────
+	// 其餘欄位（auth_url、region 等）由 clouds.yaml 提供，只覆蓋有明確設定的 region
+	if cloud := os.Getenv("OS_CLOUD"); cloud != "" {
+		return &openstackAuth{
+			Mode:   authModeCloud,
+			Cloud:  cloud,
+			Region: os.Getenv("OS_REGION_NAME"),
+		}, nil
+	}
+
+	a := &openstackAuth{
+		AuthURL:           os.Getenv("OS_AUTH_URL"),
+		Region:            envOrDefault("OS_REGION_NAME", "RegionOne"),
+		UserName:          os.Getenv("OS_USERNAME"),
+		UserID:            os.Getenv("OS_USER_ID"),
+		ProjectName:       os.Getenv("OS_PROJECT_NAME"),
+		ProjectID:         os.Getenv("OS_PROJECT_ID"),
+		UserDomainName:    envOrDefault("OS_USER_DOMAIN_NAME", "Default"),
+		ProjectDomainName: envOrDefault("OS_PROJECT_DOMAIN_NAME", "Default"),
+	}
+	missing := &missingEnv{}
+	missing.require("OS_AUTH_URL")
+
+	appCredID := os.Getenv("OS_APPLICATION_CREDENTIAL_ID")
+	appCredName := os.Getenv("OS_APPLICATION_CREDENTIAL_NAME")
+	token := envFirst("OS_TOKEN", "OS_AUTH_TOKEN")
+
+	switch {
+	case appCredID != "" || appCredName != "":
+		// ── Application credential ─────────────────────────
+		// 已綁定 project scope，不需要 project 設定；以 name 指定時需要 user 資訊
+		a.Mode = authModeAppCredential
+		a.AppCredID = appCredID
+		a.AppCredName = appCredName
+		a.AppCredSecret = os.Getenv("OS_APPLICATION_CREDENTIAL_SECRET")
+		missing.require("OS_APPLICATION_CREDENTIAL_SECRET")
+		if appCredID == "" {
+			missing.requireAny("OS_USERNAME", "OS_USER_ID")
+		}
+
+	case token != "":
+		// ── Token ──────────────────────────────────────────
+		a.Mode = authModeToken
+		a.Token = token
+		missing.requireAny("OS_PROJECT_NAME", "OS_PROJECT_ID")
+
+	default:
+		// ── Password（原本的行為）──────────────────────────
+		a.Mode = authModePassword
+		a.Password = os.Getenv("OS_PASSWORD")
+		missing.require("OS_USERNAME")
+		missing.require("OS_PASSWORD")
+		missing.requireAny("OS_PROJECT_NAME", "OS_PROJECT_ID")
+	}
+
+	if err := missing.err(a.Mode); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// newProvider 建立 Pulumi OpenStack provider。
+//
+// 明確把 env 值填入 ProviderArgs（而非交給 provider auto-detect），
+// 繞過 pulumi-openstack v3 env auto-detect 的問題。
+func newProvider(ctx *pulumi.Context, a *openstackAuth) (*openstack.Provider, error) {
+	p, err := openstack.NewProvider(ctx, "openstack", a.providerArgs())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create openstack provider (auth=%s): %w", a.Mode, err)
+	}
+	return p, nil
+}
+
+func (a *openstackAuth) providerArgs() *openstack.ProviderArgs {
+	args := &openstack.ProviderArgs{
+		Region: optionalString(a.Region),
+	}
+	switch a.Mode {
+	case authModeCloud:
+		args.Cloud = pulumi.StringPtr(a.Cloud)
+		return args
+	case authModeAppCredential:
+		args.ApplicationCredentialId = optionalString(a.AppCredID)
+		args.ApplicationCredentialName = optionalString(a.AppCredName)
+		args.ApplicationCredentialSecret = pulumi.StringPtr(a.AppCredSecret)
+		if a.AppCredID == "" {
+			args.UserName = optionalString(a.UserName)
+			args.UserId = optionalString(a.UserID)
+			args.UserDomainName = pulumi.StringPtr(a.UserDomainName)
+		}
+	case authModeToken:
+		args.Token = pulumi.StringPtr(a.Token)
+		args.TenantName = optionalString(a.ProjectName)
+		args.TenantId = optionalString(a.ProjectID)
+		args.ProjectDomainName = pulumi.StringPtr(a.ProjectDomainName)
+	default:
+		args.UserName = optionalString(a.UserName)
+		args.Password = pulumi.StringPtr(a.Password)
+		args.TenantName = optionalString(a.ProjectName)
+		args.TenantId = optionalString(a.ProjectID)
+		args.UserDomainName = pulumi.StringPtr(a.UserDomainName)
+		args.ProjectDomainName = pulumi.StringPtr(a.ProjectDomainName)
+	}
+	args.AuthUrl = pulumi.StringPtr(a.AuthURL)
+	return args
+}
+
+// providerClient 以同一份認證設定建立 gophercloud client，
+// 用於 pulumi-openstack 沒有提供的 API（console log、server fault 等）
+func (a *openstackAuth) providerClient(ctx context.Context) (*gophercloud.ProviderClient, gophercloud.EndpointOpts, error) {
+	eo := gophercloud.EndpointOpts{Region: a.Region}
+
+	if a.Mode == authModeCloud {
+		ao, cloudEO, tlsCfg, err := clouds.Parse(clouds.WithCloudName(a.Cloud))
+		if err != nil {
+			return nil, eo, fmt.Errorf("parse clouds.yaml entry %q: %w", a.Cloud, err)
+		}
+		if a.Region != "" {
+			cloudEO.Region = a.Region
+		}
+		pc, err := config.NewProviderClient(ctx, ao, config.WithTLSConfig(tlsCfg))
+		if err != nil {
+			return nil, cloudEO, fmt.Errorf("openstack auth (%s): %w", a.Mode, err)
+		}
+		return pc, cloudEO, nil
+	}
+
+	ao := gophercloud.AuthOptions{
+		IdentityEndpoint: a.AuthURL,
+		AllowReauth:      true,
+	}
+	switch a.Mode {
+	case authModeAppCredential:
+		ao.ApplicationCredentialID = a.AppCredID
+		ao.ApplicationCredentialName = a.AppCredName
+		ao.ApplicationCredentialSecret = a.AppCredSecret
+		if a.AppCredID == "" {
+			ao.Username = a.UserName
+			ao.UserID = a.UserID
+			ao.DomainName = a.UserDomainName
+		}
+	case authModeToken:
+		ao.TokenID = a.Token
+		ao.AllowReauth = false
+		ao.Scope = a.projectScope()
+	default:
+		ao.Username = a.UserName
+		ao.Password = a.Password
+		ao.DomainName = a.UserDomainName
+		ao.Scope = a.projectScope()
+	}
+	pc, err := gcopenstack.AuthenticatedClient(ctx, ao)
+	if err != nil {
+		return nil, eo, fmt.Errorf("openstack auth (%s): %w", a.Mode, err)
+	}
+	return pc, eo, nil
+}
+
+func (a *openstackAuth) projectScope() *gophercloud.AuthScope {
+	if a.ProjectID != "" {
+		return &gophercloud.AuthScope{ProjectID: a.ProjectID}
+	}
+	return &gophercloud.AuthScope{ProjectName: a.ProjectName, DomainName: a.ProjectDomainName}
+}
+
+// missingEnv 收集所有缺少的環境變數，一次回報（而不是遇到第一個就失敗）
+type missingEnv struct {
+	keys []string
+}
+
+func (m *missingEnv) require(key string) {
+	if os.Getenv(key) == "" {
+		m.keys = append(m.keys, key)
+	}
+}
+
+// requireAny 至少需要其中一個變數，例如 OS_PROJECT_NAME 或 OS_PROJECT_ID
+func (m *missingEnv) requireAny(keys ...string) {
+	if envFirst(keys...) == "" {
+		m.keys = append(m.keys, strings.Join(keys, "|"))
+	}
+}
+
+func (m *missingEnv) err(mode string) error {
+	if len(m.keys) == 0 {
+		return nil
+	}
+	return fmt.Errorf("openstack auth (%s): missing environment variables %s "+
+		"(or set OS_CLOUD to use a clouds.yaml entry)", mode, strings.Join(m.keys, ", "))
+}
+
+// optionalString 有值時回傳 StringPtr，否則回傳 nil（不覆蓋 provider 預設）
+func optionalString(v string) pulumi.StringPtrInput {
+	if v != "" {
+		return pulumi.StringPtr(v)
+	}
+	return nil
+}
+
+// envFirst 回傳第一個有值的環境變數
+func envFirst(keys ...string) string {
+	for _, k := range keys {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ctferio/scenarios/scenarioconfig"
+	"github.com/ctferio/scenarios/scenariotrace"
+)
+
+// Readiness check：VM 開機後確認題目服務真的可用才回傳 connection_info
+//
+// additional keys：
+//
+//	readiness_timeout      整體等待上限（"0" 跳過檢查，預設）
+//	readiness_check        tcp（預設）/ http / banner / ssh / console，可用逗號串接依序檢查
+//	                       （如 "console,http"：先等 cloud-init 完成，再等 HTTP 服務）
+//	readiness_failure      warn（預設，逾時只 log）/ fail（逾時讓 deployment 失敗）
+//	readiness_http_path    http 模式的 request path（預設 /）
+//	readiness_http_scheme  http / https（https 不驗證憑證）
+//	readiness_http_status  允許的 status，逗號分隔，支援 2xx 寫法（預設 2xx）
+//	readiness_match        http 模式比對 response body / banner 模式比對連線 banner 的 regex
+//
+// console 模式輪詢 Nova console log，等待 scenario 產生的 cloud-init 在所有
+// write_files / runcmd 之後印出的 marker（final_message），確保 flag 檔已寫入。
+const (
+	readinessPollInterval = 1 * time.Second
+	readinessDialTimeout  = 2 * time.Second
+	readinessReadLimit    = 64 * 1024
+
+	// consoleTailLines console 模式每次讀取與錯誤訊息附帶的 console log 行數
+	consoleTailLines = 200
+	consoleErrLines  = 20
+
+	readinessFailureWarn = "warn"
+	readinessFailureFail = "fail"
+)
+
+// readinessChecker 單次檢查服務是否就緒；ctx 帶有整體 deadline，實作必須遵守
+type readinessChecker interface {
+	Name() string
+	Check(ctx context.Context, t readinessTarget) error
+}
+
+// readinessTarget 被檢查的 instance
+type readinessTarget struct {
+	Host     string
+	Port     int
+	ServerID string
+}
+
+func (t readinessTarget) addr() string {
+	return net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+}
+
+// readinessConfig 由 additional 解析出的 readiness 設定
+type readinessConfig struct {
+	Timeout time.Duration
+	Failure string
+	Checker readinessChecker
+}
+
+// Enabled 回傳是否需要執行 readiness check
+func (c readinessConfig) Enabled() bool {
+	return c.Timeout > 0 && c.Checker != nil
+}
+
+// usesConsole 回傳是否啟用 console 模式（需要在 user_data 中加入 marker）
+func (c readinessConfig) usesConsole() bool {
+	if !c.Enabled() {
+		return false
+	}
+	steps := []readinessChecker{c.Checker}
+	if seq, ok := c.Checker.(sequenceChecker); ok {
+		steps = seq
+	}
+	for _, step := range steps {
+		if _, ok := step.(consoleChecker); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseReadinessConfig 讀取 readiness 相關的 additional keys。
+// api 與 marker 供 console 模式使用（marker 由 consoleReadyMarker 產生並寫入 user_data）。
+func parseReadinessConfig(conf *scenarioconfig.Values, api cloud, marker string) readinessConfig {
+	cfg := readinessConfig{
+		// readiness_timeout：0 跳過檢查
+		Timeout: conf.Duration("readiness_timeout"),
+		Failure: conf.String("readiness_failure"),
+	}
+
+	var matchRe *regexp.Regexp
+	if match := conf.String("readiness_match"); match != "" {
+		matchRe = regexp.MustCompile(match) // schema 已驗證
+	}
+
+	var checkers sequenceChecker
+	for _, kind := range conf.List("readiness_check") {
+		var checker readinessChecker
+		switch kind {
+		case "tcp":
+			checker = tcpChecker{}
+		case "http":
+			statuses, _ := parseStatusSpec(conf.String("readiness_http_status"))
+			checker = httpChecker{
+				Scheme:   conf.String("readiness_http_scheme"),
+				Path:     conf.String("readiness_http_path"),
+				Statuses: statuses,
+				Body:     matchRe,
+			}
+		case "banner":
+			checker = bannerChecker{Pattern: matchRe}
+		case "ssh":
+			checker = sshChecker{}
+		case "console":
+			checker = consoleChecker{API: api, Marker: marker}
+		}
+		checkers = append(checkers, checker)
+	}
+	if len(checkers) == 1 {
+		cfg.Checker = checkers[0]
+	} else {
+		cfg.Checker = checkers
+	}
+	return cfg
+}
+
+// waitReady 在 timeout 內反覆執行 checker，直到成功或 deadline 到期。
+// sequenceChecker 的每個步驟依序等待（前一步通過後不再重複檢查），共用同一個 deadline。
+// 逾時回傳最後一次檢查的錯誤；是否讓 deployment 失敗由呼叫端依 readiness_failure 決定。
+func waitReady(parent context.Context, checker readinessChecker, t readinessTarget, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	steps := []readinessChecker{checker}
+	if seq, ok := checker.(sequenceChecker); ok {
+		steps = seq
+	}
+	for _, step := range steps {
+		if err := pollReady(ctx, step, t); err != nil {
+			return fmt.Errorf("%s readiness check for %s timed out after %s: %w",
+				step.Name(), t.addr(), timeout, err)
+		}
+	}
+	return nil
+}
+
+// pollReady 每 readinessPollInterval 執行一次 checker，直到成功或 ctx 結束
+// 每次檢查是 ctx 中 span（trace 的 readiness 階段）下的一個 readiness.<check> span
+func pollReady(ctx context.Context, checker readinessChecker, t readinessTarget) error {
+	for {
+		end := scenariotrace.Step(ctx, "readiness."+checker.Name())
+		err := checker.Check(ctx, t)
+		end(err)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(readinessPollInterval):
+		}
+	}
+}
+
+// sequenceChecker 依序執行多個 checker（readiness_check="console,http"）
+type sequenceChecker []readinessChecker
+
+func (s sequenceChecker) Name() string {
+	names := make([]string, 0, len(s))
+	for _, c := range s {
+		names = append(names, c.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func (s sequenceChecker) Check(ctx context.Context, t readinessTarget) error {
+	for _, c := range s {
+		if err := c.Check(ctx, t); err != nil {
+			return fmt.Errorf("%s: %w", c.Name(), err)
+		}
+	}
+	return nil
+}
+
+// dialContext 以較短的單次 dial timeout 連線，但不超過整體 deadline
+func dialContext(ctx context.Context, t readinessTarget) (net.Conn, error) {
+	d := net.Dialer{Timeout: readinessDialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", t.addr())
+	if err != nil {
+		return nil, err
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+	return conn, nil
+}
+
+// ── TCP ─────────────────────────────────────────────────────
+
+// tcpChecker 只確認 port 可連線（原本 waitForPort 的行為）
+type tcpChecker struct{}
+
+func (tcpChecker) Name() string { return "tcp" }
+
+func (tcpChecker) Check(ctx context.Context, t readinessTarget) error {
+	conn, err := dialContext(ctx, t)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// ── HTTP ────────────────────────────────────────────────────
+
+// httpChecker 確認 HTTP status（與選填的 body regex）符合預期
+type httpChecker struct {
+	Scheme   string
+	Path     string
+	Statuses []statusRange
+	Body     *regexp.Regexp
+}
+
+func (httpChecker) Name() string { return "http" }
+
+func (c httpChecker) Check(ctx context.Context, t readinessTarget) error {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{Timeout: readinessDialTimeout}).DialContext,
+			// 題目服務多為自簽憑證，readiness 只關心服務是否回應
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		// 不跟隨 redirect：讓出題者可以把 3xx 當成就緒狀態
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	defer client.CloseIdleConnections()
+
+	path := c.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	url := fmt.Sprintf("%s://%s%s", c.Scheme, t.addr(), path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if !statusAllowed(c.Statuses, res.StatusCode) {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	if c.Body != nil {
+		body, err := io.ReadAll(io.LimitReader(res.Body, readinessReadLimit))
+		if err != nil {
+			return err
+		}
+		if !c.Body.Match(body) {
+			return fmt.Errorf("response body does not match %q", c.Body.String())
+		}
+	}
+	return nil
+}
+
+// statusRange 允許的 HTTP status 區間（"200" → [200,200]，"2xx" → [200,299]）
+type statusRange struct {
+	Min, Max int
+}
+
+func parseStatusSpec(spec string) ([]statusRange, error) {
+	var out []statusRange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+		if len(part) == 3 && strings.HasSuffix(part, "xx") {
+			d, err := strconv.Atoi(part[:1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid readiness_http_status %q", part)
+			}
+			out = append(out, statusRange{d * 100, d*100 + 99})
+			continue
+		}
+		code, err := strconv.Atoi(part)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid readiness_http_status %q", part)
+		}
+		out = append(out, statusRange{code, code})
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("readiness_http_status is empty")
+	}
+	return out, nil
+}
+
+func statusAllowed(ranges []statusRange, code int) bool {
+	for _, r := range ranges {
+		if code >= r.Min && code <= r.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// ── Banner ──────────────────────────────────────────────────
+
+// bannerChecker 連線後讀取服務主動送出的 banner，比對 regex（適用 nc 題型）
+type bannerChecker struct {
+	Pattern *regexp.Regexp
+}
+
+func (bannerChecker) Name() string { return "banner" }
+
+func (c bannerChecker) Check(ctx context.Context, t readinessTarget) error {
+	conn, err := dialContext(ctx, t)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// 單次讀取最多等 readinessDialTimeout，避免服務未送 banner 時卡到整體 deadline
+	_ = conn.SetReadDeadline(earliest(ctx, time.Now().Add(readinessDialTimeout)))
+
+	buf := make([]byte, 0, 4096)
+	chunk := make([]byte, 1024)
+	for len(buf) < readinessReadLimit {
+		n, err := conn.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+		if c.Pattern.Match(buf) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("banner %q does not match %q: %w", truncate(string(buf), 80), c.Pattern.String(), err)
+		}
+	}
+	return fmt.Errorf("banner does not match %q", c.Pattern.String())
+}
+
+// ── SSH ─────────────────────────────────────────────────────
+
+// sshChecker 完成 SSH 版本交換並收到 server 的 KEXINIT，
+// 確認 sshd 真的在處理連線（而不只是 port 已開）
+type sshChecker struct{}
+
+func (sshChecker) Name() string { return "ssh" }
+
+const sshMsgKexInit = 20
+
+func (sshChecker) Check(ctx context.Context, t readinessTarget) error {
+	conn, err := dialContext(ctx, t)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(earliest(ctx, time.Now().Add(2*readinessDialTimeout)))
+
+	r := bufio.NewReader(conn)
+	// RFC 4253 §4.2：server 可在版本字串前送其他行
+	for i := 0; ; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("read ssh version: %w", err)
+		}
+		if strings.HasPrefix(line, "SSH-") {
+			if !strings.HasPrefix(line, "SSH-2.0-") && !strings.HasPrefix(line, "SSH-1.99-") {
+				return fmt.Errorf("unsupported ssh version %q", strings.TrimSpace(line))
+			}
+			break
+		}
+		if i > 16 {
+			return errors.New("no ssh version string received")
+		}
+	}
+	if _, err := conn.Write([]byte("SSH-2.0-ctf-readiness\r\n")); err != nil {
+		return err
+	}
+
+	// binary packet：uint32 packet_length, byte padding_length, byte msg_type ...
+	var hdr [6]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return fmt.Errorf("read ssh kexinit: %w", err)
+	}
+	if l := binary.BigEndian.Uint32(hdr[:4]); l < 2 || l > 256*1024 {
+		return fmt.Errorf("invalid ssh packet length %d", l)
+	}
+	if hdr[5] != sshMsgKexInit {
+		return fmt.Errorf("expected SSH_MSG_KEXINIT, got message type %d", hdr[5])
+	}
+	return nil
+}
+
+// ── Console（cloud-init 完成）──────────────────────────────
+
+// consoleChecker 輪詢 Nova console log，等待 cloud-init final_message 印出的 marker。
+// marker 出現代表所有 write_files（含 flag）與 runcmd 都已執行完畢。
+type consoleChecker struct {
+	API    cloud
+	Marker string
+}
+
+func (consoleChecker) Name() string { return "console" }
+
+func (c consoleChecker) Check(ctx context.Context, t readinessTarget) error {
+	if t.ServerID == "" {
+		return errors.New("instance id is not known yet")
+	}
+	out, err := c.API.consoleOutput(ctx, t.ServerID, consoleTailLines)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(out, c.Marker) {
+		return nil
+	}
+	return fmt.Errorf("cloud-init marker %q not found in console log; last %d lines:\n%s",
+		c.Marker, consoleErrLines, tailLines(out, consoleErrLines))
+}
+
+// consoleReadyMarker 產生 console 模式等待的 marker（每個 instance 唯一）
+func consoleReadyMarker(prefix string) string {
+	return "CTF-CLOUD-INIT-DONE " + prefix
+}
+
+// earliest 回傳 t 與 ctx deadline 中較早者
+func earliest(ctx context.Context, t time.Time) time.Time {
+	if dl, ok := ctx.Deadline(); ok && dl.Before(t) {
+		return dl
+	}
+	return t
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+
+	sc "github.com/ctferio/scenarios/scenarioconfig"
+	"github.com/ctferio/scenarios/scenarioconfig/conninfo"
+	"github.com/ctferio/scenarios/scenarioconfig/flaggen"
+	"github.com/ctferio/scenarios/scenarioconfig/naming"
+)
+
+// configSchema openstack-vm 支援的 additional keys（型別、預設值、環境變數 fallback、限制）。
+// run 一開始就以此驗證 additional，所有錯誤與未知的 key 一次回報，不會建立任何資源。
+// 新增 key 時在此宣告，再以 conf.String / conf.Int 等 getter 讀取。
+var configSchema = &sc.Schema{
+	Scenario: scenarioName,
+	Keys: []sc.Key{
+		// ── 題目 ──
+		{Name: "image_id", Kind: sc.String, Env: "CHALLENGE_IMAGE_ID", Required: true,
+			Description: "OpenStack image ID（Packer snapshot 可大幅加速啟動）"},
+		{Name: "network_id", Kind: sc.String, Env: "CHALLENGE_NETWORK_ID", Required: true,
+			Description: "VM 所在的 OpenStack network ID"},
+		{Name: "flavor", Kind: sc.String, Env: "CHALLENGE_FLAVOR", Default: "general.small",
+			Description: "VM flavor"},
+		{Name: "port", Kind: sc.Int, Env: "CHALLENGE_PORT", Default: "8080", Min: sc.Ptr(1), Max: sc.Ptr(65535),
+			Description: "題目服務 port"},
+		{Name: "base_flag", Kind: sc.String, Env: "CHALLENGE_BASE_FLAG", Default: "change_me",
+			Description: "flag 衍生基礎值（依 flag_strategy 加工）"},
+		{Name: "flag_prefix", Kind: sc.String, Env: "CHALLENGE_FLAG_PREFIX", Default: "CTF",
+			Description: "flag 前綴"},
+		{Name: "flag_strategy", Kind: sc.String, Env: "CHALLENGE_FLAG_STRATEGY", Default: flaggen.Variate, Enum: flaggen.Names,
+			Description: "flag 產生策略（variate / static / hmac / random / format，見 flagstrategy.go）"},
+		{Name: "flag_format", Kind: sc.String, Validate: flaggen.ValidateFormat,
+			Description: "flag_strategy=format 的 Go text/template（如 {{.Prefix}}{ {{leet .Base}}_{{hash 6}} }）"},
+		{Name: "flags", Kind: sc.String, Validate: validateFlags,
+			Description: "多個具名 flag 檔（user=/home/ctf/user.txt owner=ctf:ctf mode=0440, root=/root/root.txt mode=0400），stack output named_flags"},
+		{Name: "flag_path", Kind: sc.String, Default: "/opt/ctf/flag.txt", Pattern: regexp.MustCompile(`^/`),
+			Description: "VM 內 flag 檔案路徑（絕對路徑）"},
+		{Name: "cloud_init", Kind: sc.String,
+			Description: "自訂 cloud-init（#cloud-config 或腳本），與 flag 寫入步驟合併；替換 {{FLAG}} {{FLAG_PATH}} {{PORT}} {{IDENTITY}}"},
+		{Name: "cloud_init_template", Kind: sc.Bool, Default: "false",
+			Description: "cloud_init 以 Go text/template 渲染（{{.Flag}} {{yaml .Flag}} {{shell .Flag}} 等）；預設只替換舊版佔位符"},
+		{Name: "connection_info", Kind: sc.String, Default: "nc {ip} {port}", Validate: conninfo.Validate(consoleExtra),
+			Description: "連線資訊模板（Go text/template，相容 {ip} {port} {console} 佔位符，見 connection.go）"},
+		{Name: "ports", Kind: sc.String, Validate: conninfo.ValidatePorts,
+			Description: "額外的具名 port（name=port 逗號分隔，如 web=80,admin=8443），各自開放 SG / port forwarding"},
+		{Name: "credentials_user", Kind: sc.String, Pattern: usernamePattern, Validate: validateCredentialsUser,
+			Description: "產生 per-instance 帳密的使用者名稱（cloud-init 建立，可 SSH 密碼登入）"},
+		{Name: "security_group_id", Kind: sc.String, Env: "CHALLENGE_SECURITY_GROUP_ID",
+			Description: "預建的共用 Security Group ID（提供時跳過 per-player SG）"},
+
+		// ── Flag 投遞（flagdelivery.go）──
+		{Name: "flag_delivery", Kind: sc.String, Env: "CHALLENGE_FLAG_DELIVERY", Default: flagDeliveryUserData,
+			Enum:        []string{flagDeliveryUserData, flagDeliveryScrub, flagDeliverySSH},
+			Description: "flag 投遞方式"},
+		{Name: "flag_owner", Kind: sc.String, Default: "root:root", Pattern: flagOwnerPattern,
+			Description: "flag 檔擁有者（user 或 user:group）"},
+		{Name: "flag_mode", Kind: sc.String, Default: "0444", Pattern: flagModePattern,
+			Description: "flag 檔權限（八進位）"},
+		{Name: "flag_inject_cidr", Kind: sc.String, Env: "CHALLENGE_FLAG_INJECT_CIDR", Validate: validateCIDR,
+			Description: "ssh 投遞模式 per-player SG 開放 22 port 的來源（chall-manager 的位址；flag_delivery=ssh 時必填）"},
+		{Name: "flag_inject_timeout", Kind: sc.Duration, Default: defaultFlagInjectTimeout.String(), Validate: validatePositiveDuration,
+			Description: "ssh 投遞模式等待注入完成的上限"},
+
+		// ── 命名（naming.go）──
+		{Name: "instance_id_hash", Kind: sc.String, Env: "CHALLENGE_INSTANCE_ID_HASH", Default: naming.DefaultHash, Enum: naming.Hashes,
+			Description: "shortID（資源名稱 ctf-<shortID>）的 hash"},
+		{Name: "instance_id_length", Kind: sc.Int, Env: "CHALLENGE_INSTANCE_ID_LENGTH", Default: strconv.Itoa(naming.DefaultLength),
+			Min: sc.Ptr(naming.MinLength), Max: sc.Ptr(naming.MaxLength),
+			Description: "shortID 長度（hex 字元）"},
+		{Name: "instance_id_scope", Kind: sc.String, Env: "CHALLENGE_INSTANCE_ID_SCOPE", Default: naming.DefaultScope, Enum: naming.Scopes,
+			Description: "challenge：shortID 加入 challenge_id（沒有時用 image_id）；none：只依 identity（升級前既有 stack 的舊名稱）"},
+
+		// ── 對外曝露（expose.go / fippool.go / lb.go / dns.go）──
+		{Name: "use_fip", Kind: sc.Bool, Env: "CHALLENGE_USE_FIP", Default: "true",
+			Description: "未設定 expose_mode 時：true → fip，false → fixed"},
+		{Name: "expose_mode", Kind: sc.String, Env: "CHALLENGE_EXPOSE_MODE",
+			Enum:        []string{exposeFIP, exposeFixed, exposePortForward, exposeLoadBalancer},
+			Description: "對外曝露方式（未設定時依 use_fip）"},
+		{Name: "fip_pool", Kind: sc.String, Env: "CHALLENGE_FIP_POOL", Default: "public",
+			Description: "建立 Floating IP 的外部網路"},
+		{Name: "fip_address", Kind: sc.String, Validate: validateIP,
+			Description: "預分配的 Floating IP 位址"},
+		{Name: "fip_pool_tag", Kind: sc.String, Env: "CHALLENGE_FIP_POOL_TAG",
+			Description: "從帶此 tag 的預分配 FIP 中認領"},
+		{Name: "fip_pool_description", Kind: sc.String, Env: "CHALLENGE_FIP_POOL_DESCRIPTION",
+			Description: "從此 description 的預分配 FIP 中認領"},
+		{Name: "port_forward_fip", Kind: sc.String, Env: "CHALLENGE_PORT_FORWARD_FIP", Validate: validateIP,
+			Description: "port_forward 模式共用的 Floating IP 位址"},
+		{Name: "port_forward_range", Kind: sc.String, Env: "CHALLENGE_PORT_FORWARD_RANGE", Default: defaultPortForwardRange,
+			Validate:    func(raw string) error { _, _, err := parsePortRange(raw); return err },
+			Description: "port_forward 模式的外部 port 範圍（min-max）"},
+		{Name: "lb_listener_id", Kind: sc.String, Env: "CHALLENGE_LB_LISTENER_ID",
+			Description: "loadbalancer 模式共用的 Octavia listener"},
+		{Name: "lb_domain", Kind: sc.String, Env: "CHALLENGE_LB_DOMAIN",
+			Description: "loadbalancer 模式 hostname 網域（<shortID>.<lb_domain>）"},
+		{Name: "lb_scheme", Kind: sc.String, Env: "CHALLENGE_LB_SCHEME", Default: "https", Enum: []string{"https", "http"},
+			Description: "loadbalancer 模式回傳 URL 的 scheme"},
+		{Name: "lb_member_subnet_id", Kind: sc.String, Env: "CHALLENGE_LB_MEMBER_SUBNET_ID",
+			Description: "loadbalancer 模式 member 所在 subnet"},
+		{Name: "dns_backend", Kind: sc.String, Env: "CHALLENGE_DNS_BACKEND", Enum: []string{dnsBackendDesignate},
+			Description: "per-instance DNS 記錄 backend"},
+		{Name: "dns_zone", Kind: sc.String, Env: "CHALLENGE_DNS_ZONE",
+			Description: "DNS 記錄所在 zone"},
+		{Name: "dns_zone_id", Kind: sc.String, Env: "CHALLENGE_DNS_ZONE_ID",
+			Description: "Designate zone UUID（提供時跳過以名稱查詢）"},
+		{Name: "dns_ttl", Kind: sc.Int, Env: "CHALLENGE_DNS_TTL", Default: strconv.Itoa(defaultDNSTTL), Min: sc.Ptr(1),
+			Description: "DNS 記錄 TTL 秒數"},
+		{Name: "console_access", Kind: sc.String, Enum: []string{consoleNoVNC, consoleSPICE},
+			Description: "回傳 Nova remote console URL（需在 CHALLENGE_CONSOLE_CHALLENGES 允許清單中）"},
+
+		// ── 開機磁碟（volume.go）──
+		{Name: "boot_from_volume", Kind: sc.Bool, Env: "CHALLENGE_BOOT_FROM_VOLUME", Default: "false",
+			Description: "從 image 建立 volume 開機"},
+		{Name: "volume_size", Kind: sc.Int, Env: "CHALLENGE_VOLUME_SIZE", Default: "10", Min: sc.Ptr(1),
+			Description: "開機 volume 大小（GB）"},
+		{Name: "boot_volume_source_id", Kind: sc.String,
+			Description: "預建 source volume（Cinder clone 開機）"},
+		{Name: "boot_snapshot_source_id", Kind: sc.String,
+			Description: "預建 volume snapshot（以 snapshot 建立開機 volume）"},
+		{Name: "volume_type", Kind: sc.String, Env: "CHALLENGE_VOLUME_TYPE",
+			Description: "clone volume 的 Cinder volume type"},
+
+		// ── 就緒檢查與診斷（readiness.go / diagnostics.go）──
+		{Name: "readiness_timeout", Kind: sc.Duration, Env: "CHALLENGE_READINESS_TIMEOUT", Default: "0",
+			Description: "等待服務就緒的上限（0 跳過檢查）"},
+		{Name: "readiness_check", Kind: sc.List, Env: "CHALLENGE_READINESS_CHECK", Default: "tcp",
+			Enum:        []string{"tcp", "http", "banner", "ssh", "console"},
+			Description: "就緒檢查方式，逗號串接依序檢查"},
+		{Name: "readiness_failure", Kind: sc.String, Env: "CHALLENGE_READINESS_FAILURE", Default: readinessFailureWarn,
+			Enum:        []string{readinessFailureWarn, readinessFailureFail},
+			Description: "逾時處理（warn 只 log / fail 讓部署失敗）"},
+		{Name: "readiness_http_path", Kind: sc.String, Default: "/",
+			Description: "http 檢查的 request path"},
+		{Name: "readiness_http_scheme", Kind: sc.String, Default: "http", Enum: []string{"http", "https"},
+			Description: "http 檢查的 scheme（https 不驗證憑證）"},
+		{Name: "readiness_http_status", Kind: sc.String, Default: "2xx",
+			Validate:    func(raw string) error { _, err := parseStatusSpec(raw); return err },
+			Description: "http 檢查允許的 status（逗號分隔，支援 2xx）"},
+		{Name: "readiness_match", Kind: sc.String, Validate: validateRegexp,
+			Description: "http body / banner 比對的 regex"},
+		{Name: "diagnostics_console_lines", Kind: sc.Int, Default: strconv.Itoa(defaultDiagnosticsLines), Min: sc.Ptr(0),
+			Description: "失敗時附帶的 console log 行數（0 不取）"},
+
+		// ── 排程與開機 fallback（placement.go / boot.go）──
+		{Name: "availability_zone", Kind: sc.String, Env: "CHALLENGE_AVAILABILITY_ZONE",
+			Description: "Nova availability zone"},
+		{Name: "server_group", Kind: sc.String, Env: "CHALLENGE_SERVER_GROUP",
+			Description: "auto（每題共用）或既有 server group UUID"},
+		{Name: "server_group_policy", Kind: sc.String, Default: "soft-anti-affinity",
+			Enum:        []string{"soft-anti-affinity", "anti-affinity", "soft-affinity", "affinity"},
+			Description: "server_group=auto 建立時的 policy"},
+		{Name: "scheduler_hints", Kind: sc.String,
+			Validate:    func(raw string) error { _, err := parseSchedulerHints(raw); return err },
+			Description: "其他 Nova scheduler hints（key=value,key=value）"},
+		{Name: "fallback_flavors", Kind: sc.List, Env: "CHALLENGE_FALLBACK_FLAVORS",
+			Description: "Nova 排程失敗時的備援 flavor"},
+		{Name: "fallback_availability_zones", Kind: sc.List, Env: "CHALLENGE_FALLBACK_AVAILABILITY_ZONES",
+			Description: "Nova 排程失敗時的備援 AZ"},
+		{Name: "boot_attempts", Kind: sc.Int, Env: "CHALLENGE_BOOT_ATTEMPTS", Min: sc.Ptr(1),
+			Description: "總開機嘗試次數（預設為候選組合數）"},
+		{Name: "boot_retry_delay", Kind: sc.Duration, Default: defaultBootRetryDelay.String(),
+			Description: "開機失敗後的等待時間"},
+		{Name: "boot_timeout", Kind: sc.Duration, Default: defaultBootTimeout.String(), Validate: validatePositiveDuration,
+			Description: "單次開機等待 ACTIVE 的上限"},
+
+		// ── Ownership（registration script / 全域設定）──
+		{Name: "challenge_id", Kind: sc.String,
+			Description: "題目識別（registration script 注入）"},
+		{Name: "source_id", Kind: sc.String,
+			Description: "玩家 / 隊伍識別"},
+		{Name: "instance_timeout", Kind: sc.Int, Min: sc.Ptr(0),
+			Description: "instance 存活秒數（registration script 注入）"},
+		// OpenStack 認證只由 chall-manager 的環境變數設定（見 provider.go），不是 additional key
+	},
+	Constraints: []func(*sc.Values) error{
+		func(v *sc.Values) error {
+			if v.String("boot_volume_source_id") != "" && v.String("boot_snapshot_source_id") != "" {
+				return fmt.Errorf("boot_volume_source_id and boot_snapshot_source_id are mutually exclusive")
+			}
+			return nil
+		},
+		func(v *sc.Values) error {
+			if v.String("expose_mode") == exposePortForward && v.String("port_forward_fip") == "" {
+				return fmt.Errorf("expose_mode=port_forward requires port_forward_fip (set via additional or CHALLENGE_PORT_FORWARD_FIP env)")
+			}
+			return nil
+		},
+		func(v *sc.Values) error {
+			if v.String("expose_mode") == exposeLoadBalancer && (v.String("lb_listener_id") == "" || v.String("lb_domain") == "") {
+				return fmt.Errorf("expose_mode=loadbalancer requires lb_listener_id and lb_domain")
+			}
+			return nil
+		},
+		func(v *sc.Values) error {
+			if v.String("expose_mode") == exposeLoadBalancer && v.String("ports") != "" {
+				return fmt.Errorf("ports is not supported with expose_mode=loadbalancer (the listener forwards a single port)")
+			}
+			return nil
+		},
+		func(v *sc.Values) error {
+			if v.String("flag_strategy") == flaggen.Format && v.String("flag_format") == "" {
+				return fmt.Errorf("flag_strategy=format requires flag_format")
+			}
+			return nil
+		},
+		func(v *sc.Values) error {
+			if v.String("dns_backend") != "" && v.String("dns_zone") == "" {
+				return fmt.Errorf("dns_backend=%s requires dns_zone", v.String("dns_backend"))
+			}
+			return nil
+		},
+		func(v *sc.Values) error {
+			if slices.Contains(v.List("readiness_check"), "banner") && v.String("readiness_match") == "" {
+				return fmt.Errorf("readiness_check=banner requires readiness_match")
+			}
+			return nil
+		},
+	},
+}
+
+// usernamePattern credentials_user 的格式（Linux 使用者名稱）
+var usernamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+// validateCredentialsUser 不允許覆蓋 root 與 flag 注入使用者
+func validateCredentialsUser(raw string) error {
+	if raw == "root" || raw == flagInjectUser {
+		return fmt.Errorf("%q is reserved", raw)
+	}
+	return nil
+}
+
+func validateIP(raw string) error {
+	if net.ParseIP(raw) == nil {
+		return fmt.Errorf("%q is not an IP address", raw)
+	}
+	return nil
+}
+
+func validateCIDR(raw string) error {
+	if _, _, err := net.ParseCIDR(raw); err != nil {
+		return fmt.Errorf("%q is not a CIDR", raw)
+	}
+	return nil
+}
+
+func validateRegexp(raw string) error {
+	if _, err := regexp.Compile(raw); err != nil {
+		return fmt.Errorf("invalid regex: %w", err)
+	}
+	return nil
+}
+
+// validatePositiveDuration 拒絕 0（型別檢查已拒絕負值）
+func validatePositiveDuration(raw string) error {
+	if raw == "0" || raw == "0s" {
+		return fmt.Errorf("must be greater than 0")
+	}
+	return nil
+}
+
+// schemaCommand 實作 schema 子命令：把 configSchema 以 JSON Schema 輸出到 stdout，
+// 供 registration tooling 以實際部署的版本驗證 challenge.yml 的 additional
+func schemaCommand() int {
+	if err := configSchema.WriteJSONSchema(os.Stdout, scenarioVersion); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
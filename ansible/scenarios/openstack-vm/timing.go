@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/ctferio/scenarios/scenarioconfig"
+	"github.com/ctferio/scenarios/scenarioconfig/naming"
+	"github.com/ctferio/scenarios/scenarioconfig/timing"
+	"github.com/ctferio/scenarios/scenariotrace"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// 各階段的時間（見 scenarioconfig/timing），stack output "timings"，每個時間點另以一行 JSON 寫到 log：
+//
+//	security_group / port / volume / instance / fip
+//	               requested：依賴的輸入都已解析；created：資源 ID 已知（fallback 開機為 import 完成）
+//	instance       ready：readiness 通過（未啟用 readiness 時沒有 ready）
+//	flag_inject    flag_delivery=ssh 的 SSH 注入（requested 開始連線，ready 寫入完成）
+//	readiness      requested 開始等待，ready 通過；逾時則沒有 ready
+//
+// preview 不寫 log（資源 ID 未知，不會有 created / ready）。
+// 重複執行 pulumi up 且資源未變更時，created 為 provider 讀回既有資源的時間。
+// 啟用 trace（見 trace.go）時每個階段也是一個 span，log 行附帶 trace_id。
+
+// timings 以 Pulumi output 的解析時間標記各階段
+type timings struct {
+	rec  *timing.Recorder
+	tr   *scenariotrace.Deployment
+	deps []pulumi.Output
+}
+
+func newTimings(ctx *pulumi.Context, conf *scenarioconfig.Values, identity string, tr *scenariotrace.Deployment, now func() time.Time) *timings {
+	var out io.Writer = os.Stdout
+	if ctx.DryRun() {
+		out = nil
+	}
+	fields := map[string]string{
+		"scenario":      scenarioName,
+		"challenge_id":  conf.String("challenge_id"),
+		"identity_hash": naming.IdentityHash(identity),
+	}
+	if tr != nil {
+		fields["trace_id"] = tr.TraceID()
+	}
+	t := &timings{rec: timing.New(now, out, fields), tr: tr}
+	if tr != nil {
+		t.rec.Observe(tr.Observe)
+	}
+	return t
+}
+
+// track 標記資源的 requested（after 全部解析時，沒有 after 時立即）與 created（ID 已知時）
+func (t *timings) track(phase string, res pulumi.CustomResource, after ...pulumi.Output) {
+	if len(after) == 0 {
+		t.rec.Mark(phase, timing.Requested)
+	} else {
+		t.deps = append(t.deps, pulumi.All(outputsAny(after)...).ApplyT(func([]any) int {
+			t.rec.Mark(phase, timing.Requested)
+			return 0
+		}))
+	}
+	t.deps = append(t.deps, res.ID().ApplyT(func(id pulumi.ID) pulumi.ID {
+		t.rec.Mark(phase, timing.Created)
+		return id
+	}))
+}
+
+// export 在所有標記與 done 解析後匯出 stack output "timings"，寫 summary log 並送出 trace
+func (t *timings) export(ctx *pulumi.Context, done ...pulumi.Output) {
+	deps := append(t.deps, done...)
+	ctx.Export("timings", pulumi.All(outputsAny(deps)...).ApplyT(func([]any) map[string]any {
+		s := t.rec.Summary()
+		finishTrace(t.tr, nil)
+		return s.Map()
+	}).(pulumi.MapOutput))
+}
+
+// fail err 不為 nil 時以 err 結束 trace，回傳 err（apply 失敗時 Pulumi 直接結束 process，不會再匯出 timings）
+func (t *timings) fail(err error) error {
+	if err != nil {
+		finishTrace(t.tr, err)
+	}
+	return err
+}
+
+func outputsAny(outs []pulumi.Output) []any {
+	all := make([]any, len(outs))
+	for i, o := range outs {
+		all[i] = o
+	}
+	return all
+}
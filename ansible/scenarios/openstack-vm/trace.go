@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ctferio/scenarios/scenarioconfig/naming"
+	"github.com/ctferio/scenarios/scenariotrace"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// OpenTelemetry trace（見 ../scenariotrace）：設定 OTEL_EXPORTER_OTLP_ENDPOINT 後，每次部署送出一條 trace：
+//
+//	config             additional 解析與驗證
+//	<phase>            timing.go 的每個階段（security_group / port / volume / instance / fip / flag_inject / readiness）
+//	readiness.<check>  readiness 的每一次檢查（readiness.go 的 pollReady）
+//
+// 成功時在匯出 stack output "timings" 時結束並送出；run 回傳 error、開機 / 建立監看 / port forwarding /
+// readiness 等 apply 失敗時帶 error 結束，尚未完成的階段標記為錯誤。
+// provider 回報的資源建立失敗不會回到 scenario（Pulumi 直接結束 process），該次 trace 可能不完整。
+// preview / plan 不送出 trace。
+
+// startTrace 開始部署的 trace；未啟用、preview 或 exporter 設定錯誤時回傳 nil（不影響部署）
+func startTrace(ctx *pulumi.Context, identity string) *scenariotrace.Deployment {
+	if ctx.DryRun() {
+		return nil
+	}
+	tr, err := scenariotrace.Start(ctx.Context(), scenarioName, scenarioVersion, naming.IdentityHash(identity))
+	if err != nil {
+		fmt.Printf("WARNING: tracing disabled: %v\n", err)
+		return nil
+	}
+	return tr
+}
+
+// finishTrace 結束並送出 trace；送出失敗只 log
+func finishTrace(tr *scenariotrace.Deployment, err error) {
+	if ferr := tr.Finish(err); ferr != nil {
+		fmt.Printf("WARNING: %v\n", ferr)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/ctferio/scenarios/scenarioconfig/conninfo"
+	"gopkg.in/yaml.v3"
+)
+
+// cloud-init user_data 產生器
+//
+// 預設產生只寫 flag 的 cloud-config（搭配 Packer snapshot 使用時 < 5 秒），flags 宣告的具名 flag 檔一併寫入。
+// 出題者的 cloud_init 先替換佔位符（cloud_init_template=true 時改以 text/template 渲染），再依類型與 flag 步驟合併：
+//
+//	#cloud-config  解析為 YAML 後合併，flag 的 write_files entry 附加在最後
+//	               （同路徑的出題者 entry 會被取代）
+//	#! / #cloud-boothook / #include
+//	               包成 MIME multipart：第一段為 flag cloud-config，第二段為出題者腳本
+//
+// 全部經由 yaml.v3 序列化，flag 含 YAML 特殊字元或換行也不會產生壞掉的 cloud-config。
+
+const (
+	cloudConfigHeader = "#cloud-config"
+
+	// mimeBoundary 固定值：user_data 內容必須 deterministic，
+	// 否則每次 pulumi up 都會因 user_data 變更而重建 VM
+	mimeBoundary = "ctf-openstack-vm-boundary"
+)
+
+// userDataInput 產生 user_data 所需的資料，同時也是 cloud_init 模板的資料模型
+type userDataInput struct {
+	Flag     string
+	FlagPath string
+	Port     int
+	Identity string
+	// Flags flags 宣告的具名 flag（name → flag），模板以 {{.Flags.user}} 取得
+	Flags map[string]string
+
+	// Files 要寫入 VM 的 flag 檔（主要 flag 與具名 flag，見 flagDelivery.flagFiles）
+	Files []flagFile
+
+	// Custom 出題者提供的 cloud_init（可為空）
+	Custom string
+	// Template Custom 以 text/template 渲染（cloud_init_template）；false 時只替換舊版佔位符
+	Template bool
+
+	// Delivery flag 投遞方式（見 flagdelivery.go）；ssh 模式 user_data 不含 flag，
+	// 只建立以 InjectPublicKey 登入的一次性注入使用者
+	Delivery        flagDelivery
+	InjectPublicKey string
+
+	// Credentials credentials_user 的 per-instance 帳密（見 connection.go）；
+	// Username 非空時建立此使用者，cloud_init 模板可用 {{.Credentials.Password}}
+	Credentials conninfo.Credentials
+
+	// ReadyMarker 非空時，cloud-init 在所有模組（write_files、runcmd、出題者腳本）
+	// 完成後把 marker 印到 console（final_message），供 console readiness 模式偵測
+	ReadyMarker string
+}
+
+// cloudConfig 是 cloud-config 文件的結構化表示。
+// 只明確定義 scenario 需要操作的欄位，其餘出題者的 key 原樣保留在 Extra。
+type cloudConfig struct {
+	BootCmd    []any       `yaml:"bootcmd,omitempty"`
+	WriteFiles []writeFile `yaml:"write_files,omitempty"`
+	RunCmd     []any       `yaml:"runcmd,omitempty"`
+	// FinalMessage 在 cloud_final_modules 最後輸出到 console
+	FinalMessage string         `yaml:"final_message,omitempty"`
+	Extra        map[string]any `yaml:",inline"`
+}
+
+// writeFile 對應 cloud-config 的 write_files entry
+type writeFile struct {
+	Path        string `yaml:"path"`
+	Content     string `yaml:"content"`
+	Owner       string `yaml:"owner,omitempty"`
+	Permissions string `yaml:"permissions,omitempty"`
+	Encoding    string `yaml:"encoding,omitempty"`
+	Append      bool   `yaml:"append,omitempty"`
+	Defer       bool   `yaml:"defer,omitempty"`
+
+	// Extra 保留出題者設定的其他欄位（如 source）
+	Extra map[string]any `yaml:",inline"`
+}
+
+// generateUserData 產生 cloud-init user_data，將動態 flag 注入 VM
+func generateUserData(in userDataInput) (string, error) {
+	cfg := &cloudConfig{}
+	custom := ""
+
+	if strings.TrimSpace(in.Custom) != "" {
+		rendered, err := renderCloudInit(in.Custom, in)
+		if err != nil {
+			return "", err
+		}
+		if in.Delivery.Injected() {
+			// ssh 模式 flag 不能出現在 user_data：模板引用 flag 時兩次渲染結果不同
+			probe := in
+			probe.Flag = "ctf-flag-probe"
+			probe.Flags = make(map[string]string, len(in.Flags))
+			for name := range in.Flags {
+				probe.Flags[name] = "ctf-flag-probe-" + name
+			}
+			other, err := renderCloudInit(in.Custom, probe)
+			if err != nil {
+				return "", err
+			}
+			if other != rendered {
+				return "", fmt.Errorf("cloud_init: references the flag, which is not allowed with flag_delivery=ssh")
+			}
+		}
+		if isCloudConfig(rendered) {
+			if err := yaml.Unmarshal([]byte(rendered), cfg); err != nil {
+				return "", fmt.Errorf("cloud_init: invalid cloud-config YAML: %w", err)
+			}
+		} else {
+			custom = rendered
+		}
+	}
+
+	switch in.Delivery.Mode {
+	case flagDeliverySSH:
+		cfg.addInjectUser(in.InjectPublicKey)
+	case flagDeliveryScrub:
+		cfg.addScrub()
+		fallthrough
+	default:
+		for _, f := range in.Files {
+			cfg.addFile(writeFile{
+				Path:        f.Path,
+				Content:     f.Content,
+				Owner:       f.Owner,
+				Permissions: f.Perms,
+			})
+		}
+	}
+
+	if in.Credentials.Username != "" {
+		cfg.addCredentialsUser(in.Credentials)
+	}
+
+	if in.ReadyMarker != "" {
+		// 出題者自訂的 final_message 保留，marker 接在後面
+		cfg.FinalMessage = strings.TrimSpace(cfg.FinalMessage + "\n" + in.ReadyMarker)
+	}
+
+	doc, err := cfg.marshal()
+	if err != nil {
+		return "", err
+	}
+	if custom == "" {
+		return doc, nil
+	}
+	return multipartUserData(doc, custom)
+}
+
+// addFile 加入 write_files entry；同路徑的既有 entry 會被取代，避免出題者設定覆蓋 flag
+func (c *cloudConfig) addFile(f writeFile) {
+	kept := c.WriteFiles[:0]
+	for _, wf := range c.WriteFiles {
+		if wf.Path != f.Path {
+			kept = append(kept, wf)
+		}
+	}
+	c.WriteFiles = append(kept, f)
+}
+
+func (c *cloudConfig) marshal() (string, error) {
+	var buf bytes.Buffer
+	buf.WriteString(cloudConfigHeader + "\n")
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return "", fmt.Errorf("marshal cloud-config: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return "", fmt.Errorf("marshal cloud-config: %w", err)
+	}
+	return buf.String(), nil
+}
+
+func isCloudConfig(s string) bool {
+	return strings.HasPrefix(strings.TrimLeft(s, " \t\r\n"), cloudConfigHeader)
+}
+
+// mimeTypeOf 依 cloud-init 的 first-line 慣例判斷 part 類型
+func mimeTypeOf(s string) (string, error) {
+	first := strings.TrimLeft(s, " \t\r\n")
+	switch {
+	case strings.HasPrefix(first, "#!"):
+		return "text/x-shellscript", nil
+	case strings.HasPrefix(first, "#cloud-boothook"):
+		return "text/cloud-boothook", nil
+	case strings.HasPrefix(first, "#include"):
+		return "text/x-include-url", nil
+	}
+	return "", fmt.Errorf("cloud_init: unsupported format (must start with #cloud-config, #!, #cloud-boothook or #include)")
+}
+
+// multipartUserData 把 flag cloud-config 與出題者腳本包成 MIME multipart，
+// cloud-init 會依序處理兩段（cloud-config 的 write_files 先於腳本執行）
+func multipartUserData(cloudCfg, custom string) (string, error) {
+	customType, err := mimeTypeOf(custom)
+	if err != nil {
+		return "", err
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if err := mw.SetBoundary(mimeBoundary); err != nil {
+		return "", err
+	}
+	parts := []struct {
+		contentType, filename, content string
+	}{
+		{"text/cloud-config", "ctf-flag.cfg", cloudCfg},
+		{customType, "challenge", custom},
+	}
+	for _, p := range parts {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", p.contentType+`; charset="utf-8"`)
+		h.Set("MIME-Version", "1.0")
+		h.Set("Content-Transfer-Encoding", "7bit")
+		h.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, p.filename))
+		w, err := mw.CreatePart(h)
+		if err != nil {
+			return "", err
+		}
+		if _, err := w.Write([]byte(p.content)); err != nil {
+			return "", err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Content-Type: multipart/mixed; boundary=\"%s\"\nMIME-Version: 1.0\n\n%s",
+		mimeBoundary, body.String()), nil
+}
+
+// renderCloudInit 產生出題者的 cloud_init。
+//
+// 預設只替換舊版佔位符 {{FLAG}} {{FLAG_PATH}} {{PORT}} {{IDENTITY}}（原樣插入，不跳脫），
+// 其餘內容不變：腳本中的 ${{...}}、Jinja / Helm 模板不受影響。
+//
+// in.Template（cloud_init_template=true）時以 text/template 渲染：
+// 資料模型：{{.Flag}} {{.FlagPath}} {{.Flags.<name>}} {{.Port}} {{.Identity}} {{.Credentials.Username}} {{.Credentials.Password}}
+// 跳脫函式：{{yaml .Flag}}（YAML scalar）、{{shell .Flag}}（shell 單引號）、
+// {{json .Flag}}、{{base64 .Flag}}；舊版佔位符同樣可用
+func renderCloudInit(src string, in userDataInput) (string, error) {
+	if !in.Template {
+		return strings.NewReplacer(
+			"{{FLAG}}", in.Flag,
+			"{{FLAG_PATH}}", in.FlagPath,
+			"{{PORT}}", strconv.Itoa(in.Port),
+			"{{IDENTITY}}", in.Identity,
+		).Replace(src), nil
+	}
+	funcs := template.FuncMap{
+		"yaml":   yamlQuote,
+		"shell":  shellQuote,
+		"json":   jsonQuote,
+		"base64": func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+
+		"FLAG":      func() string { return in.Flag },
+		"FLAG_PATH": func() string { return in.FlagPath },
+		"PORT":      func() string { return strconv.Itoa(in.Port) },
+		"IDENTITY":  func() string { return in.Identity },
+	}
+	tpl, err := template.New("cloud_init").Funcs(funcs).Option("missingkey=error").Parse(src)
+	if err != nil {
+		return "", fmt.Errorf("cloud_init: invalid template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, in); err != nil {
+		return "", fmt.Errorf("cloud_init: render template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// yamlQuote 回傳可直接放在 YAML value 位置的 double-quoted scalar
+func yamlQuote(v any) string {
+	// JSON string 是合法的 YAML double-quoted scalar
+	return jsonQuote(v)
+}
+
+// shellQuote 以單引號包住字串（內含的單引號會先結束引號、跳脫後再重新開始）
+func shellQuote(v any) string {
+	return "'" + strings.ReplaceAll(fmt.Sprint(v), "'", `'\''`) + "'"
+}
+
+func jsonQuote(v any) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return strconv.Quote(fmt.Sprint(v))
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderCloudInit(t *testing.T) {
+	in := userDataInput{
+		Flag:     `CTF{it's "q"}`,
+		FlagPath: "/root/flag.txt",
+		Port:     31337,
+		Identity: "1a2b3c4d",
+		Flags:    map[string]string{"user": "CTF{user}"},
+	}
+	tests := []struct {
+		name     string
+		src      string
+		template bool
+		want     string
+		wantErr  string
+	}{
+		{
+			name: "legacy placeholders",
+			src:  "echo {{FLAG}} > {{FLAG_PATH}} # {{PORT}} {{IDENTITY}}",
+			want: `echo CTF{it's "q"} > /root/flag.txt # 31337 1a2b3c4d`,
+		},
+		{
+			name: "legacy script with braces unchanged",
+			src:  "#!/bin/bash\necho \"${{ x }}\" {{ .Values.name }} {{.Flag",
+			want: "#!/bin/bash\necho \"${{ x }}\" {{ .Values.name }} {{.Flag",
+		},
+		{
+			name:     "template escaping",
+			src:      "flag: {{yaml .Flag}}\necho {{shell .Flags.user}}",
+			template: true,
+			want:     "flag: \"CTF{it's \\\"q\\\"}\"\necho 'CTF{user}'",
+		},
+		{
+			name:     "template legacy functions",
+			src:      "{{FLAG_PATH}}:{{PORT}}",
+			template: true,
+			want:     "/root/flag.txt:31337",
+		},
+		{name: "template syntax error", src: "{{.Flag", template: true, wantErr: "invalid template"},
+		{name: "template unknown flag", src: "{{.Flags.root}}", template: true, wantErr: "render template"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := in
+			in.Template = tt.template
+			got, err := renderCloudInit(tt.src, in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("renderCloudInit: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
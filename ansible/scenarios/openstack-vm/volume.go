@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ctferio/scenarios/scenarioconfig"
+	"github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v3/snapshots"
+	"github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
+	"github.com/pulumi/pulumi-openstack/sdk/v3/go/openstack/compute"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// 開機磁碟來源。boot_from_volume=true 每次都從 image 建 volume（實測 ~23s），
+// 改由預先建好的 source volume / volume snapshot clone，搭配支援 copy-on-write 的
+// volume type（Ceph RBD 等）可在數秒內完成。
+//
+// additional keys：
+//
+//	boot_volume_source_id    預建的 source volume UUID（Cinder clone）
+//	boot_snapshot_source_id  預建的 volume snapshot UUID（Nova 以 snapshot 建 volume）
+//	volume_type              clone volume 的 Cinder volume type（選擇支援 CoW clone 的 backend）
+//	volume_size              volume 大小（GB，預設 10；小於 source 時使用 source 大小）
+//
+// clone 出的 volume 一律 delete_on_termination，instance 刪除時一併刪除。
+// source 不存在或不是 available 狀態時 log warning，退回 image 開機流程。
+const (
+	bootSourceImage       = "image"        // image 開機（flavor local disk）
+	bootSourceImageVolume = "image-volume" // boot_from_volume：image → 新 volume
+	bootSourceSnapshot    = "snapshot"     // volume snapshot → 新 volume
+	bootSourceVolume      = "volume"       // source volume → Cinder clone
+
+	// volumeReadyTimeout 等待 Cinder clone 變成 available 的上限
+	volumeReadyTimeout = 5 * time.Minute
+)
+
+// bootDevice 開機磁碟設定
+type bootDevice struct {
+	Source     string
+	SourceID   string
+	Size       int
+	VolumeType string
+}
+
+// resolveBootDevice 由 additional 決定開機磁碟；source volume / snapshot 不可用時退回 image
+func resolveBootDevice(ctx context.Context, conf *scenarioconfig.Values, api cloud, imageID string) (bootDevice, error) {
+	size := conf.Int("volume_size")
+	imageDev := bootDevice{Source: bootSourceImage, SourceID: imageID}
+	if conf.Bool("boot_from_volume") {
+		imageDev = bootDevice{Source: bootSourceImageVolume, SourceID: imageID, Size: size}
+	}
+
+	volumeID := conf.String("boot_volume_source_id")
+	snapshotID := conf.String("boot_snapshot_source_id")
+	dev := bootDevice{
+		Size:       size,
+		VolumeType: conf.String("volume_type"),
+	}
+	switch {
+	case volumeID != "":
+		dev.Source, dev.SourceID = bootSourceVolume, volumeID
+	case snapshotID != "":
+		dev.Source, dev.SourceID = bootSourceSnapshot, snapshotID
+	default:
+		return imageDev, nil
+	}
+
+	srcSize, err := api.bootSourceSize(ctx, dev)
+	if err != nil {
+		fmt.Printf("WARNING: boot %s %s unavailable, falling back to image %s: %v\n",
+			dev.Source, dev.SourceID, imageID, err)
+		return imageDev, nil
+	}
+	if srcSize > dev.Size {
+		dev.Size = srcSize
+	}
+	return dev, nil
+}
+
+// bootSourceSize 確認 source volume / snapshot 存在且為 available，回傳其大小（GB）
+func (c *cloudAPI) bootSourceSize(ctx context.Context, dev bootDevice) (int, error) {
+	client, err := c.blockStorageClient(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var status string
+	var size int
+	switch dev.Source {
+	case bootSourceVolume:
+		v, err := volumes.Get(ctx, client, dev.SourceID).Extract()
+		if err != nil {
+			return 0, fmt.Errorf("get volume: %w", err)
+		}
+		status, size = v.Status, v.Size
+	case bootSourceSnapshot:
+		s, err := snapshots.Get(ctx, client, dev.SourceID).Extract()
+		if err != nil {
+			return 0, fmt.Errorf("get snapshot: %w", err)
+		}
+		status, size = s.Status, s.Size
+	}
+	if status != "available" {
+		return 0, fmt.Errorf("status is %q, want available", status)
+	}
+	return size, nil
+}
+
+// IsClone 是否需要先建立 Cinder clone volume
+func (d bootDevice) IsClone() bool {
+	return d.Source == bootSourceVolume
+}
+
+// Apply 設定 InstanceArgs 的開機磁碟；clone 模式的 volume 由呼叫端建立後傳入 cloneID
+func (d bootDevice) Apply(args *compute.InstanceArgs, cloneID pulumi.StringInput) {
+	bd := &compute.InstanceBlockDeviceArgs{
+		DestinationType:     pulumi.String("volume"),
+		BootIndex:           pulumi.Int(0),
+		DeleteOnTermination: pulumi.Bool(true),
+	}
+	switch d.Source {
+	case bootSourceImage:
+		args.ImageId = pulumi.String(d.SourceID)
+		return
+	case bootSourceImageVolume:
+		bd.Uuid = pulumi.String(d.SourceID)
+		bd.SourceType = pulumi.String("image")
+		bd.VolumeSize = pulumi.Int(d.Size)
+	case bootSourceSnapshot:
+		bd.Uuid = pulumi.String(d.SourceID)
+		bd.SourceType = pulumi.String("snapshot")
+		bd.VolumeSize = pulumi.Int(d.Size)
+	case bootSourceVolume:
+		bd.Uuid = cloneID
+		bd.SourceType = pulumi.String("volume")
+	}
+	if d.VolumeType != "" && d.Source != bootSourceVolume {
+		bd.VolumeType = pulumi.String(d.VolumeType)
+	}
+	args.BlockDevices = compute.InstanceBlockDeviceArray{bd}
+}
+
+// apply 設定 Nova API CreateOpts 的開機磁碟（boot fallback 使用）
+func (d bootDevice) apply(opts *servers.CreateOpts, cloneID string) {
+	bd := servers.BlockDevice{
+		DestinationType:     servers.DestinationVolume,
+		BootIndex:           0,
+		DeleteOnTermination: true,
+		VolumeType:          d.VolumeType,
+	}
+	switch d.Source {
+	case bootSourceImage:
+		opts.ImageRef = d.SourceID
+		return
+	case bootSourceImageVolume:
+		bd.UUID, bd.SourceType, bd.VolumeSize = d.SourceID, servers.SourceImage, d.Size
+	case bootSourceSnapshot:
+		bd.UUID, bd.SourceType, bd.VolumeSize = d.SourceID, servers.SourceSnapshot, d.Size
+	case bootSourceVolume:
+		bd.UUID, bd.SourceType, bd.VolumeType = cloneID, servers.SourceVolume, ""
+	}
+	opts.BlockDevice = []servers.BlockDevice{bd}
+}
+
+// cloneVolume 以 Cinder clone source volume 並等待 available（boot fallback 每次嘗試各自 clone）
+func (c *cloudAPI) cloneVolume(ctx context.Context, name string, d bootDevice, az string, metadata map[string]string) (string, error) {
+	client, err := c.blockStorageClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	v, err := volumes.Create(ctx, client, volumes.CreateOpts{
+		Name:             name,
+		Size:             d.Size,
+		SourceVolID:      d.SourceID,
+		VolumeType:       d.VolumeType,
+		AvailabilityZone: az,
+		Metadata:         metadata,
+	}, nil).Extract()
+	if err != nil {
+		return "", fmt.Errorf("clone volume %s: %w", d.SourceID, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, volumeReadyTimeout)
+	defer cancel()
+	ticker := time.NewTicker(buildPollInterval)
+	defer ticker.Stop()
+	for {
+		cur, err := volumes.Get(ctx, client, v.ID).Extract()
+		switch {
+		case err == nil && cur.Status == "available":
+			return v.ID, nil
+		case err == nil && cur.Status == "error":
+			return v.ID, fmt.Errorf("clone volume %s: status error", v.ID)
+		}
+		select {
+		case <-ctx.Done():
+			return v.ID, fmt.Errorf("clone volume %s: not available after %s", v.ID, volumeReadyTimeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// deleteVolume 刪除尚未掛上 instance 的 clone（開機請求失敗時清理）
+func (c *cloudAPI) deleteVolume(ctx context.Context, id string) error {
+	client, err := c.blockStorageClient(ctx)
+	if err != nil {
+		return err
+	}
+	if err := volumes.Delete(ctx, client, id, volumes.DeleteOpts{}).ExtractErr(); err != nil {
+		return fmt.Errorf("delete volume %s: %w", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+// Package conninfo 以 Go text/template 產生回傳給玩家的 connection_info。
+//
+// 資料模型（Data）：
+//
+//	{{.IP}}           對外 IP（FIP / 共用 FIP / worker IP）
+//	{{.Hostname}}     per-instance DNS hostname（未設定 DNS 時為空字串）
+//	{{.Host}}         Hostname，未設定時為 IP（舊 {ip} 佔位符）
+//	{{.Port}}         主要服務的對外 port（舊 {port} 佔位符）
+//	{{.Ports.web}}    每個具名 port 的對外 port，主要服務固定名為 "challenge"
+//	{{.ShortID}}      identity 衍生的短識別（預設 8 字元 md5 hex，見 naming）
+//	{{.Credentials.Username}} / {{.Credentials.Password}}
+//	                  scenario 產生的 per-instance 帳密（未設定 credentials_user 時為空）
+//	{{.ExpiresAt}}    到期時間（time.Time，未設定 instance_timeout 時為 zero），
+//	                  可用 {{.ExpiresAt.Format "15:04 MST"}}
+//	{{.Extra.xxx}}    scenario 特有欄位（各 scenario README 列出）
+//
+// 輔助函式：
+//
+//	url       {{url "https" .Host .Ports.web "/login"}} → https://host:port/login（預設 port 省略、IPv6 加 []）
+//	hostport  {{hostport .Host .Port}} → host:port（IPv6 加 []）
+//	default   {{default .IP .Hostname}} → Hostname 為空時回傳 IP
+//	upper / lower / join / until（{{until .ExpiresAt}} → 剩餘時間，如 "1h0m0s"）
+//
+// 相容舊版佔位符：{ip} → {{.Host}}、{port} → {{.Port}}，scenario 另可宣告對應 Extra 的佔位符（如 {console}）。
+// 模板在部署開始時以 Parse + Check 驗證（語法、欄位、具名 port），錯誤會在建立任何資源前回報。
+package conninfo
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// PrimaryPort 主要服務 port 在 Ports 中的名稱
+const PrimaryPort = "challenge"
+
+// Credentials scenario 產生的 per-instance 帳密
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// Data connection_info 模板的資料模型
+type Data struct {
+	IP          string
+	Hostname    string
+	Host        string
+	Port        int
+	Ports       map[string]int
+	ShortID     string
+	Credentials Credentials
+	ExpiresAt   time.Time
+	Extra       map[string]string
+}
+
+// Template 已解析的 connection_info 模板
+type Template struct {
+	tpl *template.Template
+}
+
+// funcs 模板輔助函式
+var funcs = template.FuncMap{
+	"url":      buildURL,
+	"hostport": func(host string, port int) string { return net.JoinHostPort(host, strconv.Itoa(port)) },
+	"default": func(def, v string) string {
+		if v == "" {
+			return def
+		}
+		return v
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"join":  strings.Join,
+	"until": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return time.Until(t).Round(time.Minute).String()
+	},
+}
+
+// Parse 解析 connection_info 模板。legacy 為 scenario 額外支援的舊式佔位符名稱，
+// {name} 會轉成 {{index .Extra "name"}}。
+func Parse(src string, legacy ...string) (*Template, error) {
+	tpl, err := template.New("connection_info").Funcs(funcs).Option("missingkey=error").Parse(convertLegacy(src, legacy))
+	if err != nil {
+		return nil, fmt.Errorf("connection_info: invalid template: %w", err)
+	}
+	return &Template{tpl: tpl}, nil
+}
+
+// Check 以範例資料試渲染，找出語法正確但引用不存在欄位 / port / Extra 的模板。
+// sample 的 Ports 與 Extra 需包含 scenario 實際會提供的所有 key。
+func (t *Template) Check(sample Data) error {
+	_, err := t.Render(sample)
+	return err
+}
+
+// Render 以 d 渲染模板；d.Host 為空時依 Hostname / IP 補上
+func (t *Template) Render(d Data) (string, error) {
+	if d.Host == "" {
+		d.Host = d.IP
+		if d.Hostname != "" {
+			d.Host = d.Hostname
+		}
+	}
+	var buf bytes.Buffer
+	if err := t.tpl.Execute(&buf, d); err != nil {
+		return "", fmt.Errorf("connection_info: render template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// Validate 只檢查模板語法（給 scenarioconfig.Key.Validate 使用，與其他 additional 問題一起回報）
+func Validate(legacy ...string) func(string) error {
+	return func(src string) error {
+		_, err := Parse(src, legacy...)
+		return err
+	}
+}
+
+// convertLegacy 把 {ip} {port} 與 legacy 佔位符轉成 template action。
+// 只轉換單層大括號，{{...}} 內的文字不受影響。
+func convertLegacy(src string, legacy []string) string {
+	repl := map[string]string{"ip": "{{.Host}}", "port": "{{.Port}}"}
+	for _, name := range legacy {
+		repl[name] = fmt.Sprintf("{{index .Extra %q}}", name)
+	}
+	var b strings.Builder
+	for i := 0; i < len(src); i++ {
+		if src[i] == '{' && (i == 0 || src[i-1] != '{') {
+			if end := strings.IndexByte(src[i+1:], '}'); end >= 0 {
+				name := src[i+1 : i+1+end]
+				after := i + 2 + end
+				if action, ok := repl[name]; ok && (after >= len(src) || src[after] != '}') {
+					b.WriteString(action)
+					i = after - 1
+					continue
+				}
+			}
+		}
+		b.WriteByte(src[i])
+	}
+	return b.String()
+}
+
+// buildURL 組出 scheme://host[:port][/path]，scheme 的預設 port 省略
+func buildURL(scheme, host string, port int, path ...string) string {
+	hp := host
+	if (scheme == "http" && port == 80) || (scheme == "https" && port == 443) || port == 0 {
+		if strings.Contains(host, ":") {
+			hp = "[" + host + "]"
+		}
+	} else {
+		hp = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	p := strings.Join(path, "")
+	if p != "" && !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return scheme + "://" + hp + p
+}
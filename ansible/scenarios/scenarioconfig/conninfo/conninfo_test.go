@@ -0,0 +1,73 @@
+package conninfo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	data := Data{
+		IP:          "203.0.113.10",
+		Port:        31337,
+		Ports:       map[string]int{PrimaryPort: 31337, "web": 8443},
+		ShortID:     "1a2b3c4d",
+		Credentials: Credentials{Username: "ctf", Password: "hunter2"},
+		Extra:       map[string]string{"console": "https://console.example/"},
+	}
+	tests := []struct {
+		name    string
+		src     string
+		legacy  []string
+		data    func(Data) Data
+		want    string
+		wantErr string
+	}{
+		{name: "legacy placeholders", src: "nc {ip} {port}", want: "nc 203.0.113.10 31337"},
+		{name: "scenario placeholder", src: "{console}", legacy: []string{"console"}, want: "https://console.example/"},
+		{name: "double braces untouched", src: "{{.Port}} {port}", want: "31337 31337"},
+		{name: "unknown single brace kept", src: "{host}:{port}", want: "{host}:31337"},
+		{
+			name: "hostname preferred over ip",
+			src:  "ssh {{.Credentials.Username}}@{{.Host}} -p {{.Port}}",
+			data: func(d Data) Data { d.Hostname = "1a2b3c4d.ctf.example"; return d },
+			want: "ssh ctf@1a2b3c4d.ctf.example -p 31337",
+		},
+		{name: "url omits default port", src: `{{url "https" .Host 443 "/login"}}`, want: "https://203.0.113.10/login"},
+		{name: "url with named port", src: `{{url "https" .Host .Ports.web}}`, want: "https://203.0.113.10:8443"},
+		{
+			name: "hostport brackets ipv6",
+			src:  "{{hostport .Host .Port}}",
+			data: func(d Data) Data { d.IP = "2001:db8::1"; return d },
+			want: "[2001:db8::1]:31337",
+		},
+		{name: "default", src: "{{default .IP .Hostname}}", want: "203.0.113.10"},
+		{name: "unknown named port", src: "{{.Ports.ssh}}", wantErr: "render template"},
+		{name: "unknown field", src: "{{.Address}}", wantErr: "render template"},
+		{name: "syntax error", src: "{{.Port", wantErr: "invalid template"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := data
+			if tt.data != nil {
+				d = tt.data(d)
+			}
+			tpl, err := Parse(tt.src, tt.legacy...)
+			var got string
+			if err == nil {
+				got, err = tpl.Render(d)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("render: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
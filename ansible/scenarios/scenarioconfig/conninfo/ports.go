@@ -0,0 +1,69 @@
+package conninfo
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// NamedPort 題目額外曝露的具名 port（additional 的 ports key："web=80,admin=8443"）
+type NamedPort struct {
+	Name string
+	Port int
+}
+
+// portNamePattern 與 Kubernetes port name（IANA service name）相同：小寫英數與 -，最多 15 字元
+var portNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// ParsePorts 解析 "name=port,name=port"；名稱不可重複，也不可使用保留的 "challenge"
+func ParsePorts(raw string) ([]NamedPort, error) {
+	var out []NamedPort
+	seen := map[string]bool{}
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		name = strings.TrimSpace(name)
+		if !ok {
+			return nil, fmt.Errorf("invalid ports entry %q (want name=port)", part)
+		}
+		if len(name) > 15 || !portNamePattern.MatchString(name) || !strings.ContainsAny(name, "abcdefghijklmnopqrstuvwxyz") {
+			return nil, fmt.Errorf("invalid port name %q (lowercase letters, digits and -, at most 15 characters)", name)
+		}
+		if name == PrimaryPort {
+			return nil, fmt.Errorf("port name %q is reserved for the port key", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate port name %q", name)
+		}
+		port, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %q for %s (want 1-65535)", value, name)
+		}
+		seen[name] = true
+		out = append(out, NamedPort{Name: name, Port: port})
+	}
+	return out, nil
+}
+
+// ValidatePorts 給 scenarioconfig.Key.Validate 使用
+func ValidatePorts(raw string) error {
+	_, err := ParsePorts(raw)
+	return err
+}
+
+// NewCredentials 產生 per-instance 帳密。密碼由 identity 與題目 secret（base_flag）衍生：
+// 同一 instance 重跑 pulumi up 得到相同密碼（user_data / Pod spec 不變），不同玩家互不相同。
+func NewCredentials(username, identity, secret string) Credentials {
+	if username == "" {
+		return Credentials{}
+	}
+	h := sha256.Sum256([]byte("ctf-credentials\x00" + identity + "\x00" + secret))
+	pw := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(h[:])
+	return Credentials{Username: username, Password: strings.ToLower(pw[:20])}
+}
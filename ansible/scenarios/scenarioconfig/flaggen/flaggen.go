@@ -0,0 +1,155 @@
+// Package flaggen 實作 flag_strategy：兩個 scenario 以同一組策略產生 flag，
+// 結果同時交給 k8s env（CTF_FLAG）與 VM cloud-init（write_files / {{.Flag}}），以及 resp.Flag。
+//
+// 策略（additional 的 flag_strategy）：
+//
+//	variate  <prefix>{sdk.Variate(identity, base_flag)}（預設，與舊版相同）
+//	static   <prefix>{base_flag}：所有玩家相同，給 flag 寫死在 image 裡的舊題目
+//	hmac     <prefix>{HMAC-SHA256(secret, challenge_id + identity + base_flag) 前 32 hex}，
+//	         secret 只放在 chall-manager 環境變數（CHALLENGE_FLAG_SECRET / CHALLENGE_FLAG_SECRET_FILE），
+//	         CTFd 管理者拿到 base_flag 也算不出其他玩家的 flag
+//	random   <prefix>{32 hex 亂數}：與 identity / base_flag 無關，由 scenario 以 Pulumi state 保存的
+//	         nonce 產生（首次部署決定，之後 pulumi up 不變）
+//	format   flag_format 模板（見 format.go），例：{{.Prefix}}{ {{leet .Base}}_{{hash 6}} }
+//
+// 需要 nonce 的策略（random，或 flag_format 引用 .Random）由 NeedsNonce 回報，scenario 另建 nonce 資源。
+package flaggen
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	Variate = "variate"
+	Static  = "static"
+	HMAC    = "hmac"
+	Random  = "random"
+	Format  = "format"
+
+	// SecretEnv / SecretFileEnv hmac 策略的 secret（chall-manager 環境變數，出題者無法設定）
+	SecretEnv     = "CHALLENGE_FLAG_SECRET"
+	SecretFileEnv = "CHALLENGE_FLAG_SECRET_FILE"
+)
+
+// Names 所有策略名稱（schema 的 Enum）
+var Names = []string{Variate, Static, HMAC, Random, Format}
+
+// Input 產生 flag 所需的資料
+type Input struct {
+	Identity    string
+	ChallengeID string
+	Base        string
+	Prefix      string
+
+	// Secret hmac 策略（及 flag_format 的 .HMAC）的 key
+	Secret string
+	// Nonce NeedsNonce 時由 scenario 提供的 per-instance 亂數（hex）
+	Nonce string
+	// VariateFunc 由 scenario 注入 sdk.Variate（本套件不依賴 chall-manager SDK）
+	VariateFunc func(identity, base string) string
+}
+
+// Strategy 一種 flag 產生方式
+type Strategy interface {
+	// Generate 回傳完整 flag（含 prefix）
+	Generate(in Input) (string, error)
+	// NeedsNonce 是否需要 per-instance 亂數（Input.Nonce）
+	NeedsNonce() bool
+}
+
+// New 依 flag_strategy 建立策略；format 策略使用 flagFormat
+func New(name, flagFormat string) (Strategy, error) {
+	switch name {
+	case "", Variate:
+		return variateStrategy{}, nil
+	case Static:
+		return staticStrategy{}, nil
+	case HMAC:
+		return hmacStrategy{}, nil
+	case Random:
+		return randomStrategy{}, nil
+	case Format:
+		if strings.TrimSpace(flagFormat) == "" {
+			return nil, fmt.Errorf("flag_strategy=format requires flag_format")
+		}
+		return parseFormat(flagFormat)
+	}
+	return nil, fmt.Errorf("unknown flag_strategy %q (want %s)", name, strings.Join(Names, ", "))
+}
+
+// LoadSecret 讀取 hmac secret：CHALLENGE_FLAG_SECRET，或 CHALLENGE_FLAG_SECRET_FILE 指向的檔案
+func LoadSecret() (string, error) {
+	if v := os.Getenv(SecretEnv); v != "" {
+		return v, nil
+	}
+	path := os.Getenv(SecretFileEnv)
+	if path == "" {
+		return "", nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read %s: %w", SecretFileEnv, err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func wrap(prefix, body string) string {
+	return prefix + "{" + body + "}"
+}
+
+type variateStrategy struct{}
+
+func (variateStrategy) Generate(in Input) (string, error) {
+	if in.VariateFunc == nil {
+		return "", fmt.Errorf("flag_strategy=variate: no Variate function")
+	}
+	return wrap(in.Prefix, in.VariateFunc(in.Identity, in.Base)), nil
+}
+
+func (variateStrategy) NeedsNonce() bool { return false }
+
+type staticStrategy struct{}
+
+func (staticStrategy) Generate(in Input) (string, error) {
+	return wrap(in.Prefix, in.Base), nil
+}
+
+func (staticStrategy) NeedsNonce() bool { return false }
+
+type hmacStrategy struct{}
+
+func (hmacStrategy) Generate(in Input) (string, error) {
+	mac, err := keyedHash(in)
+	if err != nil {
+		return "", err
+	}
+	return wrap(in.Prefix, mac[:32]), nil
+}
+
+func (hmacStrategy) NeedsNonce() bool { return false }
+
+// keyedHash HMAC-SHA256(secret, challenge_id \0 identity \0 base)，hex
+func keyedHash(in Input) (string, error) {
+	if in.Secret == "" {
+		return "", fmt.Errorf("HMAC flag requires %s or %s on chall-manager", SecretEnv, SecretFileEnv)
+	}
+	m := hmac.New(sha256.New, []byte(in.Secret))
+	m.Write([]byte(in.ChallengeID + "\x00" + in.Identity + "\x00" + in.Base))
+	return hex.EncodeToString(m.Sum(nil)), nil
+}
+
+type randomStrategy struct{}
+
+func (randomStrategy) Generate(in Input) (string, error) {
+	if in.Nonce == "" {
+		return "", fmt.Errorf("flag_strategy=random: missing nonce")
+	}
+	return wrap(in.Prefix, in.Nonce), nil
+}
+
+func (randomStrategy) NeedsNonce() bool { return true }
@@ -0,0 +1,120 @@
+package flaggen
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    []FlagSpec
+		wantErr string
+	}{
+		{name: "empty", raw: " , "},
+		{
+			name: "user and root",
+			raw:  "user=/home/ctf/user.txt owner=ctf:ctf mode=0440, root=/root/root.txt mode=0400",
+			want: []FlagSpec{
+				{Name: "user", Path: "/home/ctf/user.txt", Owner: "ctf:ctf", Mode: "0440"},
+				{Name: "root", Path: "/root/root.txt", Mode: "0400"},
+			},
+		},
+		{
+			name: "env and base",
+			raw:  "stage1 env=STAGE1_FLAG base=s3cret",
+			want: []FlagSpec{{Name: "stage1", Env: "STAGE1_FLAG", Base: "s3cret"}},
+		},
+		{name: "invalid name", raw: "User=/flag", wantErr: "invalid flag name"},
+		{name: "duplicate name", raw: "user=/a, user=/b", wantErr: "duplicate flag name"},
+		{name: "unknown field", raw: "user=/flag group=ctf", wantErr: "unknown field"},
+		{name: "field without value", raw: "user=/flag mode=", wantErr: "want key=value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFlags(tt.raw)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseFlags error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFlags: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseFlags = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestGenerateIdentity 每種策略對同一個 identity 的結果固定，並確認是否依 identity 而不同
+func TestGenerateIdentity(t *testing.T) {
+	variate := func(identity, base string) string { return base + "_" + identity }
+	tests := []struct {
+		strategy, format string
+		// perIdentity 不同 identity 是否產生不同 flag
+		perIdentity bool
+	}{
+		{strategy: Variate, perIdentity: true},
+		{strategy: Static},
+		{strategy: HMAC, perIdentity: true},
+		{strategy: Random},
+		{strategy: Format, format: "{{.Prefix}}{ {{leet .Base}}_{{hash 6}} }", perIdentity: true},
+		{strategy: Format, format: "{{.Prefix}}{ {{.Base}}_{{.Random}} }"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy+" "+tt.format, func(t *testing.T) {
+			s, err := New(tt.strategy, tt.format)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			in := func(identity string) Input {
+				return Input{
+					Identity:    identity,
+					ChallengeID: "12",
+					Base:        "leaked_secret",
+					Prefix:      "CTF",
+					Secret:      "test-secret",
+					Nonce:       "00112233445566778899aabbccddeeff",
+					VariateFunc: variate,
+				}
+			}
+			gen := func(identity string) string {
+				flag, err := s.Generate(in(identity))
+				if err != nil {
+					t.Fatalf("Generate(%s): %v", identity, err)
+				}
+				return flag
+			}
+
+			a, again, b := gen("team-1"), gen("team-1"), gen("team-2")
+			if a != again {
+				t.Errorf("same identity: %q != %q", a, again)
+			}
+			if !strings.HasPrefix(a, "CTF{") || !strings.HasSuffix(a, "}") {
+				t.Errorf("flag %q is not wrapped in CTF{...}", a)
+			}
+			if differs := a != b; differs != tt.perIdentity {
+				t.Errorf("team-1 %q, team-2 %q: differs = %v, want %v", a, b, differs, tt.perIdentity)
+			}
+		})
+	}
+}
+
+func TestForFlag(t *testing.T) {
+	in := Input{Identity: "team-1", Base: "base", Nonce: "00112233445566778899aabbccddeeff"}
+	user, root := in.ForFlag(FlagSpec{Name: "user"}), in.ForFlag(FlagSpec{Name: "root", Base: "fixed"})
+	if user.Base != "base/user" || root.Base != "fixed" {
+		t.Errorf("Base = %q, %q, want base/user, fixed", user.Base, root.Base)
+	}
+	if user.Nonce == in.Nonce || user.Nonce == root.Nonce || len(user.Nonce) != len(in.Nonce) {
+		t.Errorf("per-flag nonces %q, %q not derived from %q", user.Nonce, root.Nonce, in.Nonce)
+	}
+	if again := in.ForFlag(FlagSpec{Name: "user"}); again.Nonce != user.Nonce {
+		t.Errorf("per-flag nonce not deterministic: %q != %q", again.Nonce, user.Nonce)
+	}
+}
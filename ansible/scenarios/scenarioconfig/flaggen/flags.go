@@ -0,0 +1,91 @@
+package flaggen
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// 多 flag（additional 的 flags key）：HTB 式 user / root flag、多階段 web 題每階段一個 flag。
+//
+//	flags: "user=/home/ctf/user.txt owner=ctf:ctf mode=0440, root=/root/root.txt mode=0400"
+//
+// 以逗號分隔每個 flag，每個 flag 以空白分隔欄位：第一個欄位為 name 或 name=path，其餘為 key=value：
+//
+//	owner  檔案擁有者（VM）
+//	mode   檔案權限（VM / Pod，預設 0444）
+//	env    環境變數名稱（Pod）
+//	base   flag 衍生基礎值（預設 <base_flag>/<name>；static 策略需要每個 flag 寫死的值時使用）
+//
+// 每個 flag 以同一個 flag_strategy 產生，主要 flag（resp.Flag）不受影響。
+// 路徑 / env 是否必填、支援哪些欄位由 scenario 決定（見各 scenario 的 Validate）。
+
+// FlagSpec flags 中的一個具名 flag
+type FlagSpec struct {
+	Name  string
+	Path  string
+	Owner string
+	Mode  string
+	Env   string
+	Base  string
+}
+
+// flagNamePattern flag 名稱：用於 stack output、模板（{{.Flags.user}}）與 Kubernetes Secret key
+var flagNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+// ParseFlags 解析 flags
+func ParseFlags(raw string) ([]FlagSpec, error) {
+	var out []FlagSpec
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(raw, ",") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		name, path, _ := strings.Cut(fields[0], "=")
+		spec := FlagSpec{Name: name, Path: path}
+		if !flagNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid flag name %q (lowercase letters, digits and _, starting with a letter)", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate flag name %q", name)
+		}
+		seen[name] = true
+		for _, f := range fields[1:] {
+			k, v, ok := strings.Cut(f, "=")
+			if !ok || v == "" {
+				return nil, fmt.Errorf("flag %s: invalid field %q (want key=value)", name, f)
+			}
+			switch k {
+			case "owner":
+				spec.Owner = v
+			case "mode":
+				spec.Mode = v
+			case "env":
+				spec.Env = v
+			case "base":
+				spec.Base = v
+			default:
+				return nil, fmt.Errorf("flag %s: unknown field %q (want owner, mode, env or base)", name, k)
+			}
+		}
+		out = append(out, spec)
+	}
+	return out, nil
+}
+
+// ForFlag 回傳產生具名 flag 的 Input：base 預設 <base_flag>/<name>，nonce 由主要 nonce 衍生
+func (in Input) ForFlag(spec FlagSpec) Input {
+	out := in
+	out.Base = in.Base + "/" + spec.Name
+	if spec.Base != "" {
+		out.Base = spec.Base
+	}
+	if in.Nonce != "" {
+		h := sha256.Sum256([]byte(in.Nonce + "\x00" + spec.Name))
+		out.Nonce = hex.EncodeToString(h[:])[:min(len(in.Nonce), 2*sha256.Size)]
+	}
+	return out
+}
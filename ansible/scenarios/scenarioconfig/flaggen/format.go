@@ -0,0 +1,153 @@
+package flaggen
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// flag_format：flag_strategy=format 的 Go text/template，渲染結果就是完整 flag（prefix 需自行放入）
+//
+// 資料：
+//
+//	{{.Prefix}}   flag_prefix
+//	{{.Base}}     base_flag
+//	{{.Variate}}  sdk.Variate(identity, base_flag)
+//	{{.HMAC}}     HMAC-SHA256(secret, ...) hex（需 CHALLENGE_FLAG_SECRET）
+//	{{.Random}}   per-instance 亂數 hex（引用時 scenario 會建立 nonce 資源）
+//
+// 函式（per-identity deterministic）：
+//
+//	leet     依 identity 把部分字母換成 leetspeak（a→4/@、e→3、i→1/!、o→0、s→5/$、t→7…）
+//	hash N   identity + base_flag 的 SHA-256 前 N hex（1-64）
+//	upper / lower / replace OLD NEW
+//
+// 例：{{.Prefix}}{ {{leet .Base}}_{{hash 6}} } → CTF{l34k3d_s3cr3t_9f2c1a}
+// 模板前後與 {} 內側的空白會被移除，方便撰寫 {{.Prefix}}{ ... }。
+
+// leetTable 可替換的字元與候選
+var leetTable = map[rune][]string{
+	'a': {"4", "@"},
+	'b': {"8"},
+	'e': {"3"},
+	'g': {"9"},
+	'i': {"1", "!"},
+	'l': {"1"},
+	'o': {"0"},
+	's': {"5", "$"},
+	't': {"7"},
+}
+
+type formatStrategy struct {
+	tpl   *template.Template
+	nonce bool
+}
+
+// formatFuncs 解析時的函式宣告（實際實作在 Generate 時依 identity 綁定）
+func formatFuncs(in Input) template.FuncMap {
+	seed := sha256.Sum256([]byte("ctf-flag-format\x00" + in.Identity + "\x00" + in.Base))
+	return template.FuncMap{
+		"leet": func(s string) string { return leet(s, seed[:]) },
+		"hash": func(n int) (string, error) {
+			if n < 1 || n > 64 {
+				return "", fmt.Errorf("hash: length %d out of range 1-64", n)
+			}
+			return hex.EncodeToString(seed[:])[:n], nil
+		},
+		"upper":   strings.ToUpper,
+		"lower":   strings.ToLower,
+		"replace": func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	}
+}
+
+func parseFormat(src string) (Strategy, error) {
+	tpl, err := template.New("flag_format").Funcs(formatFuncs(Input{})).Option("missingkey=error").Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("flag_format: invalid template: %w", err)
+	}
+	return formatStrategy{tpl: tpl, nonce: strings.Contains(src, ".Random")}, nil
+}
+
+// ValidateFormat 只檢查 flag_format 語法（給 scenarioconfig.Key.Validate 使用）
+func ValidateFormat(src string) error {
+	_, err := parseFormat(src)
+	return err
+}
+
+func (s formatStrategy) NeedsNonce() bool { return s.nonce }
+
+func (s formatStrategy) Generate(in Input) (string, error) {
+	tpl, err := s.tpl.Clone()
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tpl.Funcs(formatFuncs(in)).Execute(&buf, formatData{in: in}); err != nil {
+		return "", fmt.Errorf("flag_format: render template: %w", err)
+	}
+	flag := strings.TrimSpace(buf.String())
+	// {{.Prefix}}{ ... } 寫法：移除大括號內側的空白
+	if open := strings.IndexByte(flag, '{'); open >= 0 && strings.HasSuffix(flag, "}") {
+		flag = flag[:open+1] + strings.TrimSpace(flag[open+1:len(flag)-1]) + "}"
+	}
+	if flag == "" {
+		return "", fmt.Errorf("flag_format: rendered an empty flag")
+	}
+	return flag, nil
+}
+
+// formatData flag_format 的資料；Variate / HMAC / Random 只在模板引用時計算
+type formatData struct {
+	in Input
+}
+
+func (d formatData) Prefix() string { return d.in.Prefix }
+func (d formatData) Base() string   { return d.in.Base }
+
+func (d formatData) Variate() (string, error) {
+	if d.in.VariateFunc == nil {
+		return "", fmt.Errorf("no Variate function")
+	}
+	return d.in.VariateFunc(d.in.Identity, d.in.Base), nil
+}
+
+func (d formatData) HMAC() (string, error) {
+	return keyedHash(d.in)
+}
+
+func (d formatData) Random() (string, error) {
+	if d.in.Nonce == "" {
+		return "", fmt.Errorf("missing nonce")
+	}
+	return d.in.Nonce, nil
+}
+
+// leet 依 seed 的位元決定每個可替換字元是否替換、換成哪個候選
+func leet(s string, seed []byte) string {
+	var b strings.Builder
+	bit := 0
+	next := func() int {
+		v := int(seed[(bit/8)%len(seed)]>>(bit%8)) & 1
+		bit++
+		return v
+	}
+	for _, r := range s {
+		cands, ok := leetTable[toLower(r)]
+		if !ok || next() == 0 {
+			b.WriteRune(r)
+			continue
+		}
+		b.WriteString(cands[next()%len(cands)])
+	}
+	return b.String()
+}
+
+func toLower(r rune) rune {
+	if r >= 'A' && r <= 'Z' {
+		return r + 'a' - 'A'
+	}
+	return r
+}
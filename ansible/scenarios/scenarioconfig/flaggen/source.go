@@ -0,0 +1,87 @@
+package flaggen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ctferio/scenarios/scenarioconfig"
+)
+
+// NonceBytes random 策略（NeedsNonce）的 nonce 長度，hex 後 32 字元
+const NonceBytes = 16
+
+// Source 由 additional 的 flag_strategy / flag_format / flags 解析出的 flag 產生方式（兩個 scenario 共用）。
+// 需要 nonce 時 scenario 另建 nonce 資源（pulumi-random RandomId），再以 Flag / Named 產生。
+type Source struct {
+	strategy Strategy
+	input    Input
+	// Specs flags 宣告的具名 flag（見 flags.go）
+	Specs []FlagSpec
+	// Sample / SampleNamed 以範例 nonce 產生的 flag（random 策略在 nonce 建立前用來驗證模板）
+	Sample      string
+	SampleNamed map[string]string
+}
+
+// NewSource 建立策略並以範例 nonce 試產生一次，缺少 secret、模板錯誤在建立資源前回報。
+// conf 需宣告 flag_strategy、flag_format、flags、challenge_id、base_flag、flag_prefix；
+// variate 由 scenario 注入 sdk.Variate（本套件不依賴 chall-manager SDK）。
+func NewSource(conf *scenarioconfig.Values, identity string, variate func(identity, base string) string) (Source, error) {
+	strategy, err := New(conf.String("flag_strategy"), conf.String("flag_format"))
+	if err != nil {
+		return Source{}, err
+	}
+	secret, err := LoadSecret()
+	if err != nil {
+		return Source{}, err
+	}
+	s := Source{
+		strategy: strategy,
+		input: Input{
+			Identity:    identity,
+			ChallengeID: conf.String("challenge_id"),
+			Base:        conf.String("base_flag"),
+			Prefix:      conf.String("flag_prefix"),
+			Secret:      secret,
+			VariateFunc: variate,
+		},
+	}
+	// 格式已由 schema 驗證
+	if s.Specs, err = ParseFlags(conf.String("flags")); err != nil {
+		return Source{}, err
+	}
+	sample := strings.Repeat("0", NonceBytes*2)
+	if s.Sample, err = s.Flag(sample); err != nil {
+		return Source{}, err
+	}
+	if s.SampleNamed, err = s.Named(sample); err != nil {
+		return Source{}, err
+	}
+	return s, nil
+}
+
+// NeedsNonce 是否需要 per-instance nonce（random 策略，或 flag_format 引用 .Random）
+func (s Source) NeedsNonce() bool {
+	return s.strategy.NeedsNonce()
+}
+
+// Flag 產生主要 flag；不需要 nonce 時 nonce 為空字串
+func (s Source) Flag(nonce string) (string, error) {
+	in := s.input
+	in.Nonce = nonce
+	return s.strategy.Generate(in)
+}
+
+// Named 產生 flags 宣告的所有具名 flag（name → flag）
+func (s Source) Named(nonce string) (map[string]string, error) {
+	in := s.input
+	in.Nonce = nonce
+	out := make(map[string]string, len(s.Specs))
+	for _, spec := range s.Specs {
+		flag, err := s.strategy.Generate(in.ForFlag(spec))
+		if err != nil {
+			return nil, fmt.Errorf("flag %s: %w", spec.Name, err)
+		}
+		out[spec.Name] = flag
+	}
+	return out, nil
+}
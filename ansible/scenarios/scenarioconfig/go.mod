@@ -0,0 +1,3 @@
+module github.com/ctferio/scenarios/scenarioconfig
+
+go 1.25
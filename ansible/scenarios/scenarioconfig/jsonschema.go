@@ -0,0 +1,117 @@
+package scenarioconfig
+
+import (
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// JSON Schema（draft 2020-12）輸出：scenario binary 的 schema 子命令以此描述自己支援的 additional，
+// registration tooling / CTFd 管理者可以用部署中的那個版本驗證 challenge.yml。
+//
+// additional 在 chall-manager 中是 map[string]string，所以每個 property 的 type 都是 string，
+// 型別以 pattern 表達（空字串視為未設定，不套用 pattern / enum）。
+// JSON Schema 無法表達的資訊放在 x- 開頭的擴充欄位：
+//
+//	x-kind               string / int / bool / duration / list
+//	x-env                環境變數 fallback（未在 additional 設定時使用）
+//	x-minimum/x-maximum  int 的範圍（含端點）
+//	x-required           必填但可由 x-env 提供（因此不列在 required）
+//
+// 跨 key 的條件（Constraints）與 Validate 檢查不在 JSON Schema 中，仍由 scenario 部署時驗證。
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// kindPatterns 各型別的字串格式（與 check 的解析規則一致）
+var kindPatterns = map[Kind]string{
+	Int:      `^\s*[+-]?[0-9]+\s*$`,
+	Bool:     `^\s*(1|t|T|TRUE|true|True|0|f|F|FALSE|false|False)\s*$`,
+	Duration: `^\s*([0-9]+|(([0-9]+(\.[0-9]*)?|\.[0-9]+)(ns|us|µs|ms|s|m|h))+)\s*$`,
+}
+
+// JSONSchema 回傳 additional 的 JSON Schema，version 為 scenario 版本（scenarioVersion）
+func (s *Schema) JSONSchema(version string) map[string]any {
+	props := map[string]any{}
+	var required []string
+	for _, k := range s.Keys {
+		props[k.Name] = k.jsonSchema()
+		if k.Required && k.Env == "" {
+			required = append(required, k.Name)
+		}
+	}
+	out := map[string]any{
+		"$schema":              jsonSchemaDraft,
+		"title":                s.Scenario + " additional config",
+		"description":          "Values are strings (chall-manager additional); empty values are treated as unset. Cross-key constraints are checked by the scenario at deploy time.",
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+		"x-scenario":           s.Scenario,
+		"x-scenario-version":   version,
+	}
+	if len(required) > 0 {
+		out["required"] = required
+	}
+	return out
+}
+
+// WriteJSONSchema 以縮排 JSON 寫出 JSONSchema
+func (s *Schema) WriteJSONSchema(w io.Writer, version string) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(s.JSONSchema(version))
+}
+
+func (k Key) jsonSchema() map[string]any {
+	kind := k.Kind
+	if kind == "" {
+		kind = String
+	}
+	p := map[string]any{
+		"type":   "string",
+		"x-kind": string(kind),
+	}
+	if k.Description != "" {
+		p["description"] = k.Description
+	}
+	if k.Default != "" {
+		p["default"] = k.Default
+	}
+	if k.Env != "" {
+		p["x-env"] = k.Env
+	}
+	if k.Required && k.Env != "" {
+		p["x-required"] = true
+	}
+	switch kind {
+	case String:
+		if len(k.Enum) > 0 {
+			p["enum"] = k.Enum
+		}
+		if k.Pattern != nil {
+			p["pattern"] = k.Pattern.String()
+		}
+	case Int:
+		p["pattern"] = kindPatterns[Int]
+		if k.Min != nil {
+			p["x-minimum"] = *k.Min
+		}
+		if k.Max != nil {
+			p["x-maximum"] = *k.Max
+		}
+	case Bool, Duration:
+		p["pattern"] = kindPatterns[kind]
+	case List:
+		if len(k.Enum) > 0 {
+			quoted := make([]string, len(k.Enum))
+			for i, e := range k.Enum {
+				quoted[i] = regexp.QuoteMeta(e)
+			}
+			item := "(" + strings.Join(quoted, "|") + ")?"
+			p["pattern"] = `^\s*` + item + `(\s*,\s*` + item + `)*\s*$`
+			p["x-enum"] = k.Enum
+		}
+	}
+	return p
+}
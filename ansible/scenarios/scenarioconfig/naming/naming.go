@@ -0,0 +1,108 @@
+// Package naming 產生 per-instance 短識別碼（shortID）：資源命名、DNS 記錄、ownership 的 ctf-id 都以此為準。
+//
+// 舊版只取 md5(identity) 前 8 hex，同一個共用 namespace / 專案內約 7.7 萬個 instance 就有 50% 機率撞名，
+// 撞名的兩個 instance 會搶同一個 Pod / Service selector / Nova instance。
+// 可設定 hash 與長度，並可把題目範圍（challenge_id）納入 hash：
+//
+//	instance_id_hash    md5（預設）/ sha256
+//	instance_id_length  8-32（預設 8）
+//	instance_id_scope   challenge（預設，hash 加入 challenge_id）/ none（舊版，只依 identity）
+//
+// shortID 也是 Pulumi 資源名稱的 prefix，改變設定會讓既有 stack 的資源全部改名重建：
+// 預設含題目範圍（與舊版名稱不同），升級前已有 instance 的部署需設定 instance_id_scope=none 保留舊名稱，
+// 在所有 instance 到期後再移除。新部署建議 sha256 / 12 / challenge。
+// 名稱仍可能撞到既有資源，scenario 建立前以 CheckOwner 比對 ctf-identity，屬於其他 identity 時直接失敗。
+package naming
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+const (
+	MD5    = "md5"
+	SHA256 = "sha256"
+
+	// ScopeChallenge / ScopeNone instance_id_scope 的值
+	ScopeChallenge = "challenge"
+	ScopeNone      = "none"
+
+	// 預設值（hash / 長度與舊版相同，scope 不同：既有 stack 需設定 instance_id_scope=none）
+	DefaultHash   = MD5
+	DefaultLength = 8
+	DefaultScope  = ScopeChallenge
+
+	MinLength = 8
+	MaxLength = 32
+)
+
+var (
+	// Hashes instance_id_hash 的可用值（schema 的 Enum）
+	Hashes = []string{MD5, SHA256}
+	// Scopes instance_id_scope 的可用值（schema 的 Enum）
+	Scopes = []string{ScopeChallenge, ScopeNone}
+)
+
+// Config shortID 的產生方式；zero value 即舊版的 md5(identity) 前 8 hex
+type Config struct {
+	Hash   string // 空字串為 md5
+	Length int    // <= 0 為 DefaultLength
+	// Scope 題目範圍的識別（challenge_id，沒有時由 scenario 以 image 等代替）；空字串時只依 identity
+	Scope string
+}
+
+// Legacy 是否為舊版命名（md5、8 字元、不含題目範圍）
+func (c Config) Legacy() bool {
+	return c.Hash != SHA256 && (c.Length <= 0 || c.Length == DefaultLength) && c.Scope == ""
+}
+
+// ShortID 回傳 identity 的 hex 短識別碼
+func (c Config) ShortID(identity string) string {
+	src := identity
+	if c.Scope != "" {
+		src = c.Scope + "\x00" + identity
+	}
+	var sum []byte
+	switch c.Hash {
+	case SHA256:
+		h := sha256.Sum256([]byte(src))
+		sum = h[:]
+	default:
+		h := md5.Sum([]byte(src))
+		sum = h[:]
+	}
+	id := hex.EncodeToString(sum)
+	n := c.Length
+	if n <= 0 {
+		n = DefaultLength
+	}
+	return id[:min(max(n, MinLength), MaxLength, len(id))]
+}
+
+// IdentityHash ownership 的 ctf-identity：sha256(identity) 前 16 hex（不直接暴露 identity）
+func IdentityHash(identity string) string {
+	h := sha256.Sum256([]byte(identity))
+	return hex.EncodeToString(h[:])[:16]
+}
+
+// CheckOwner 比對既有資源的 ctf-identity（owner）與目前的 identity：
+// 同一個 identity（重複執行 pulumi up）回傳 nil，其他情況回傳錯誤，不沿用也不覆蓋。
+// 舊版命名下沒有 ctf-identity 的資源視為此 identity 所有：加入 ownership 前建立的 instance
+// （ownership 欄位以 ignoreChanges 忽略，之後也不會補上）名稱同樣由 md5(identity) 產生。
+// kind / name 只用於錯誤訊息（如 "pod challenges/ctf-1a2b3c4d"）。
+func (c Config) CheckOwner(kind, name, owner, identity string) error {
+	if owner == IdentityHash(identity) {
+		return nil
+	}
+	if owner == "" {
+		if c.Legacy() {
+			return nil
+		}
+		return fmt.Errorf("%s %s already exists without a ctf-identity owner; refusing to adopt it "+
+			"(remove it, or change instance_id_length / instance_id_hash / instance_id_scope)", kind, name)
+	}
+	return fmt.Errorf("%s %s already exists and belongs to another identity (ctf-identity=%s); "+
+		"refusing to adopt or overwrite it (increase instance_id_length or use instance_id_hash=sha256)", kind, name, owner)
+}
@@ -0,0 +1,101 @@
+package naming
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// legacyShortID 舊版的 shortID：md5(identity) 前 8 hex
+func legacyShortID(identity string) string {
+	h := md5.Sum([]byte(identity))
+	return hex.EncodeToString(h[:])[:8]
+}
+
+func TestShortIDLegacy(t *testing.T) {
+	for _, cfg := range []Config{
+		{},
+		{Hash: DefaultHash, Length: DefaultLength},
+		{Hash: MD5, Length: 8},
+	} {
+		if !cfg.Legacy() {
+			t.Errorf("%+v: Legacy() = false", cfg)
+		}
+		for _, identity := range []string{"team-1", "42", ""} {
+			if got, want := cfg.ShortID(identity), legacyShortID(identity); got != want {
+				t.Errorf("%+v ShortID(%q) = %q, want %q", cfg, identity, got, want)
+			}
+		}
+	}
+}
+
+func TestShortID(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantLen int
+	}{
+		{name: "md5 longer", cfg: Config{Length: 12}, wantLen: 12},
+		{name: "sha256", cfg: Config{Hash: SHA256}, wantLen: 8},
+		{name: "sha256 max", cfg: Config{Hash: SHA256, Length: 64}, wantLen: MaxLength},
+		{name: "below minimum", cfg: Config{Length: 4}, wantLen: MinLength},
+		{name: "challenge scope", cfg: Config{Scope: "12"}, wantLen: 8},
+		{name: "defaults with challenge_id", cfg: Config{Hash: DefaultHash, Length: DefaultLength, Scope: "12"}, wantLen: DefaultLength},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := tt.cfg.ShortID("team-1")
+			if len(id) != tt.wantLen {
+				t.Errorf("ShortID = %q, want length %d", id, tt.wantLen)
+			}
+			if id != tt.cfg.ShortID("team-1") {
+				t.Errorf("ShortID not deterministic")
+			}
+			if id == tt.cfg.ShortID("team-2") {
+				t.Errorf("ShortID same for different identities: %q", id)
+			}
+			if tt.cfg.Legacy() {
+				t.Errorf("%+v: Legacy() = true", tt.cfg)
+			}
+		})
+	}
+
+	// 題目範圍不同時，同一個 identity 的 shortID 不同，也不同於舊版名稱
+	if a, b := (Config{Scope: "12"}).ShortID("team-1"), (Config{Scope: "13"}).ShortID("team-1"); a == b {
+		t.Errorf("scopes 12 and 13 share shortID %q", a)
+	}
+	if got := (Config{Scope: "12"}).ShortID("team-1"); got == legacyShortID("team-1") {
+		t.Errorf("scoped shortID %q equals the legacy name", got)
+	}
+}
+
+func TestCheckOwner(t *testing.T) {
+	owner := IdentityHash("team-1")
+	tests := []struct {
+		name    string
+		cfg     Config
+		owner   string
+		wantErr string
+	}{
+		{name: "same identity", cfg: Config{Hash: SHA256}, owner: owner},
+		{name: "other identity", cfg: Config{}, owner: IdentityHash("team-2"), wantErr: "belongs to another identity"},
+		{name: "unowned under legacy naming", cfg: Config{}},
+		{name: "unowned under new naming", cfg: Config{Hash: SHA256, Length: 12}, wantErr: "without a ctf-identity owner"},
+		{name: "unowned under challenge scope", cfg: Config{Scope: "12"}, wantErr: "without a ctf-identity owner"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.CheckOwner("pod", "challenges/ctf-1a2b3c4d", tt.owner, "team-1")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("CheckOwner: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("CheckOwner error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+// Package scenarioconfig 宣告 scenario 的 additional config schema，並在建立任何資源前驗證。
+//
+// 每個 scenario 以 Schema 宣告支援的 key（型別、預設值、環境變數 fallback、限制條件、說明），
+// Parse 依 additional → 環境變數 → 預設值 的順序取值（與原本的 configOrEnv 相同），
+// 一次回報所有型別錯誤、不符限制與未知的 key，之後由 Values 的 typed getter 讀取。
+//
+//	cfg, err := configSchema.Parse(req.Config.Additional)
+//	if err != nil {
+//		return err // 列出所有問題
+//	}
+//	port := cfg.Int("port")
+package scenarioconfig
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kind key 的值型別
+type Kind string
+
+const (
+	String   Kind = "string"
+	Int      Kind = "int"
+	Bool     Kind = "bool"
+	Duration Kind = "duration" // Go duration（"30s"）或整數秒數（"30"）
+	List     Kind = "list"     // 逗號分隔，忽略空白項目
+)
+
+// Key 一個 additional key 的宣告
+type Key struct {
+	Name        string
+	Kind        Kind
+	Default     string
+	Env         string // 環境變數 fallback（空字串代表只能由 additional 設定）
+	Description string
+
+	Required bool
+	Enum     []string       // 允許的值（String / List 的每個項目）
+	Min, Max *int           // Int 的範圍（含端點）
+	Pattern  *regexp.Regexp // String 的格式
+	// Validate 額外的檢查（型別與上述限制通過後執行），回傳 error 代表值無效
+	Validate func(string) error
+}
+
+// Schema 一個 scenario 的 additional config 宣告
+type Schema struct {
+	Scenario string
+	Keys     []Key
+	// Constraints 跨 key 的檢查（如互斥、某模式需要的 key），在單一 key 檢查之後執行
+	Constraints []func(*Values) error
+}
+
+// Ptr 回傳 v 的指標（Min / Max 使用）
+func Ptr(v int) *int { return &v }
+
+// key 以名稱查詢宣告
+func (s *Schema) key(name string) (Key, bool) {
+	for _, k := range s.Keys {
+		if k.Name == name {
+			return k, true
+		}
+	}
+	return Key{}, false
+}
+
+// Error 驗證失敗時回傳，列出所有問題
+type Error struct {
+	Scenario string
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("invalid additional config for %s (%d problem(s)):\n  - %s",
+		e.Scenario, len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// Parse 依 additional → 環境變數 → 預設值 解析所有 key，
+// 有任何型別錯誤、不符限制、缺少必填或未知的 key 時回傳 *Error（包含全部問題）
+func (s *Schema) Parse(additional map[string]string) (*Values, error) {
+	v := &Values{schema: s, raw: map[string]string{}, source: map[string]string{}}
+	var problems []string
+
+	for _, k := range s.Keys {
+		raw, source := k.Default, ""
+		if a, ok := additional[k.Name]; ok && a != "" {
+			raw, source = a, "additional"
+		} else if k.Env != "" {
+			if e := os.Getenv(k.Env); e != "" {
+				raw, source = e, "env "+k.Env
+			}
+		}
+		v.raw[k.Name], v.source[k.Name] = raw, source
+
+		if raw == "" {
+			if k.Required {
+				hint := ""
+				if k.Env != "" {
+					hint = " or " + k.Env + " env"
+				}
+				problems = append(problems, fmt.Sprintf("%s: required (set via additional%s)", k.Name, hint))
+			}
+			continue
+		}
+		if err := k.check(raw); err != nil {
+			where := k.Name
+			if source != "" && source != "additional" {
+				where += " (" + source + ")"
+			}
+			problems = append(problems, fmt.Sprintf("%s: %v", where, err))
+		}
+	}
+
+	for _, c := range s.Constraints {
+		if err := c(v); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	var unknown []string
+	for name := range additional {
+		if _, ok := s.key(name); !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		msg := fmt.Sprintf("unknown key %q", name)
+		if near := s.suggest(name); near != "" {
+			msg += fmt.Sprintf(" (did you mean %q?)", near)
+		}
+		problems = append(problems, msg)
+	}
+
+	if len(problems) > 0 {
+		return nil, &Error{Scenario: s.Scenario, Problems: problems}
+	}
+	return v, nil
+}
+
+// check 驗證單一非空值
+func (k Key) check(raw string) error {
+	switch k.Kind {
+	case String, "":
+		if k.Pattern != nil && !k.Pattern.MatchString(raw) {
+			return fmt.Errorf("%q does not match %s", raw, k.Pattern)
+		}
+		if err := checkEnum(k.Enum, raw); err != nil {
+			return err
+		}
+	case Int:
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		if k.Min != nil && n < *k.Min {
+			return fmt.Errorf("%d is less than %d", n, *k.Min)
+		}
+		if k.Max != nil && n > *k.Max {
+			return fmt.Errorf("%d is greater than %d", n, *k.Max)
+		}
+	case Bool:
+		if _, err := strconv.ParseBool(strings.TrimSpace(raw)); err != nil {
+			return fmt.Errorf("%q is not a boolean (want true or false)", raw)
+		}
+	case Duration:
+		d, err := parseDuration(raw)
+		if err != nil {
+			return err
+		}
+		if d < 0 {
+			return fmt.Errorf("%q is negative", raw)
+		}
+	case List:
+		for _, item := range SplitList(raw) {
+			if err := checkEnum(k.Enum, item); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported kind %q", k.Kind)
+	}
+	if k.Validate != nil {
+		return k.Validate(raw)
+	}
+	return nil
+}
+
+func checkEnum(enum []string, v string) error {
+	if len(enum) == 0 {
+		return nil
+	}
+	for _, e := range enum {
+		if v == e {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q is not one of %s", v, strings.Join(enum, ", "))
+}
+
+// parseDuration 接受 Go duration（"30s"、"2m"）或整數秒數（"30"）
+func parseDuration(raw string) (time.Duration, error) {
+	raw = strings.TrimSpace(raw)
+	if n, err := strconv.Atoi(raw); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a duration (want e.g. 30s, 2m or seconds)", raw)
+	}
+	return d, nil
+}
+
+// SplitList 解析逗號分隔清單（忽略空白項目），List key 與 scenario 自行讀取的環境變數共用
+func SplitList(raw string) []string {
+	var out []string
+	for _, v := range strings.Split(raw, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// suggest 回傳與 name 最接近的已宣告 key（拼字錯誤提示），差異太大時回傳空字串
+func (s *Schema) suggest(name string) string {
+	best, bestDist := "", len(name)/2+1
+	for _, k := range s.Keys {
+		if d := editDistance(name, k.Name); d < bestDist {
+			best, bestDist = k.Name, d
+		}
+	}
+	return best
+}
+
+// editDistance Levenshtein 距離
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,129 @@
+package scenarioconfig
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testSchema 測試用的 schema：port 有預設值與環境變數 fallback，image 只有環境變數 fallback
+func testSchema() *Schema {
+	return &Schema{
+		Scenario: "test",
+		Keys: []Key{
+			{Name: "port", Kind: Int, Default: "22", Env: "CHALLENGE_TEST_PORT", Min: Ptr(1), Max: Ptr(65535)},
+			{Name: "image", Kind: String, Env: "CHALLENGE_TEST_IMAGE"},
+			{Name: "flag_prefix", Kind: String, Default: "CTF"},
+		},
+	}
+}
+
+func TestParseSources(t *testing.T) {
+	tests := []struct {
+		name       string
+		additional map[string]string
+		env        map[string]string
+		wantPort   int
+		wantImage  string
+	}{
+		{
+			name:     "default",
+			wantPort: 22,
+		},
+		{
+			name:      "env fallback",
+			env:       map[string]string{"CHALLENGE_TEST_PORT": "8080", "CHALLENGE_TEST_IMAGE": "nginx"},
+			wantPort:  8080,
+			wantImage: "nginx",
+		},
+		{
+			name:       "additional overrides env",
+			additional: map[string]string{"port": "9000", "image": "web:latest"},
+			env:        map[string]string{"CHALLENGE_TEST_PORT": "8080", "CHALLENGE_TEST_IMAGE": "nginx"},
+			wantPort:   9000,
+			wantImage:  "web:latest",
+		},
+		{
+			name:       "empty additional falls back to env",
+			additional: map[string]string{"port": ""},
+			env:        map[string]string{"CHALLENGE_TEST_PORT": "8080"},
+			wantPort:   8080,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CHALLENGE_TEST_PORT", "")
+			t.Setenv("CHALLENGE_TEST_IMAGE", "")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			v, err := testSchema().Parse(tt.additional)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if got := v.Int("port"); got != tt.wantPort {
+				t.Errorf("port = %d, want %d", got, tt.wantPort)
+			}
+			if got := v.String("image"); got != tt.wantImage {
+				t.Errorf("image = %q, want %q", got, tt.wantImage)
+			}
+		})
+	}
+}
+
+func TestParseProblems(t *testing.T) {
+	tests := []struct {
+		name       string
+		additional map[string]string
+		env        map[string]string
+		want       []string
+	}{
+		{
+			name:       "unknown key with suggestion",
+			additional: map[string]string{"prot": "80"},
+			want:       []string{`unknown key "prot" (did you mean "port"?)`},
+		},
+		{
+			name:       "unknown key without suggestion",
+			additional: map[string]string{"registry": "x"},
+			want:       []string{`unknown key "registry"`},
+		},
+		{
+			name:       "invalid additional value",
+			additional: map[string]string{"port": "http"},
+			want:       []string{"port: "},
+		},
+		{
+			name: "invalid env value names the variable",
+			env:  map[string]string{"CHALLENGE_TEST_PORT": "70000"},
+			want: []string{"port (env CHALLENGE_TEST_PORT): "},
+		},
+		{
+			name:       "all problems reported together",
+			additional: map[string]string{"port": "0", "flag_prefx": "X"},
+			want:       []string{"port: ", `unknown key "flag_prefx" (did you mean "flag_prefix"?)`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CHALLENGE_TEST_PORT", "")
+			t.Setenv("CHALLENGE_TEST_IMAGE", "")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, err := testSchema().Parse(tt.additional)
+			var perr *Error
+			if !errors.As(err, &perr) {
+				t.Fatalf("Parse error = %v, want *Error", err)
+			}
+			if len(perr.Problems) != len(tt.want) {
+				t.Fatalf("problems = %q, want %d", perr.Problems, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.HasPrefix(perr.Problems[i], want) {
+					t.Errorf("problem %d = %q, want prefix %q", i, perr.Problems[i], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+// Package timing 記錄 scenario 每個階段（port、VM、FIP、pod、service、readiness 等）的時間點，
+// 壓力測試不必再靠手動計時拆解開機時間。
+//
+// 每個階段最多三個時間點，以部署開始（Recorder 建立）為基準的毫秒數表示：
+//
+//	requested  scenario 送出建立請求（依賴的輸入都已解析）
+//	created    provider 回報建立完成（資源 ID 已知）
+//	ready      可使用（readiness 通過、pod Ready、NodePort 已分配等，依 scenario 定義）
+//
+// 每次標記都以一行 JSON 寫到 log（event=scenario_timing），部署結束時 Summary 另寫一行
+// event=scenario_timing_summary，並由 scenario 匯出為 stack output "timings"。
+// log 中只有 identity 的 hash（與 ownership 的 ctf-identity 相同），不直接暴露 identity。
+//
+// 套件本身不依賴 Pulumi：何時標記由 scenario 依 Pulumi output 的解析時間決定（見各 scenario 的 timing.go）。
+// Observe 讓其他記錄方式（例如 scenariotrace 的 span）沿用同一組時間點。
+package timing
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// 階段的時間點
+const (
+	Requested = "requested"
+	Created   = "created"
+	Ready     = "ready"
+)
+
+// log 行的 event 欄位
+const (
+	EventMark    = "scenario_timing"
+	EventSummary = "scenario_timing_summary"
+)
+
+// Phase 單一階段的時間點（相對於部署開始的毫秒數，未發生的時間點為 nil）
+type Phase struct {
+	RequestedMS *int64 `json:"requested_ms,omitempty"`
+	CreatedMS   *int64 `json:"created_ms,omitempty"`
+	ReadyMS     *int64 `json:"ready_ms,omitempty"`
+	// DurationMS requested 到最後一個時間點的毫秒數（只有 requested 時為 nil）
+	DurationMS *int64 `json:"duration_ms,omitempty"`
+}
+
+// Summary 整個部署的時間摘要（stack output "timings" 與 summary log 行的內容）
+type Summary struct {
+	StartedAt time.Time        `json:"started_at"`
+	TotalMS   int64            `json:"total_ms"`
+	Phases    map[string]Phase `json:"phases"`
+	// Order 階段第一次被標記的順序（map 沒有順序，供輸出表格使用）
+	Order []string `json:"order"`
+}
+
+// Recorder 記錄各階段的時間點，可在多個 goroutine（Pulumi apply）中同時標記
+type Recorder struct {
+	mu     sync.Mutex
+	now    func() time.Time
+	start  time.Time
+	out    io.Writer
+	fields map[string]string
+	marks  map[string]map[string]time.Time
+	order  []string
+	// observers 每個新的時間點都會呼叫（不持有 mu）
+	observers []func(phase, point string, at time.Time)
+}
+
+// New 建立 Recorder 並以 now() 作為部署開始時間。
+// fields 附加在每一行 log（scenario、challenge_id、identity_hash 等）；out 為 nil 時不寫 log。
+func New(now func() time.Time, out io.Writer, fields map[string]string) *Recorder {
+	if now == nil {
+		now = time.Now
+	}
+	return &Recorder{
+		now:    now,
+		start:  now(),
+		out:    out,
+		fields: fields,
+		marks:  map[string]map[string]time.Time{},
+	}
+}
+
+// Mark 以目前時間記錄階段的時間點（同一個時間點重複標記時保留第一次）
+func (r *Recorder) Mark(phase, point string) {
+	r.mark(phase, point, r.now())
+}
+
+// MarkAt 以指定時間記錄時間點（例如 Kubernetes 回報的 Ready 時間）
+func (r *Recorder) MarkAt(phase, point string, at time.Time) {
+	r.mark(phase, point, at)
+}
+
+// Observe 註冊 fn，之後每個新的時間點（重複標記除外）都會以相同參數呼叫 fn
+func (r *Recorder) Observe(fn func(phase, point string, at time.Time)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.observers = append(r.observers, fn)
+}
+
+func (r *Recorder) mark(phase, point string, at time.Time) {
+	r.mu.Lock()
+	m, ok := r.marks[phase]
+	if !ok {
+		m = map[string]time.Time{}
+		r.marks[phase] = m
+		r.order = append(r.order, phase)
+	}
+	if _, ok := m[point]; ok {
+		r.mu.Unlock()
+		return
+	}
+	m[point] = at
+	r.log(map[string]any{
+		"event":      EventMark,
+		"phase":      phase,
+		"mark":       point,
+		"elapsed_ms": at.Sub(r.start).Milliseconds(),
+		"time":       at.UTC().Format(time.RFC3339Nano),
+	})
+	observers := r.observers
+	r.mu.Unlock()
+	for _, fn := range observers {
+		fn(phase, point, at)
+	}
+}
+
+// Summary 目前為止的時間摘要，並寫一行 summary log
+func (r *Recorder) Summary() Summary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := Summary{
+		StartedAt: r.start.UTC(),
+		TotalMS:   r.now().Sub(r.start).Milliseconds(),
+		Phases:    make(map[string]Phase, len(r.marks)),
+		Order:     append([]string(nil), r.order...),
+	}
+	for name, m := range r.marks {
+		var p Phase
+		var last time.Time
+		set := func(dst **int64, point string) {
+			if at, ok := m[point]; ok {
+				ms := at.Sub(r.start).Milliseconds()
+				*dst = &ms
+				if at.After(last) {
+					last = at
+				}
+			}
+		}
+		set(&p.RequestedMS, Requested)
+		set(&p.CreatedMS, Created)
+		set(&p.ReadyMS, Ready)
+		if req, ok := m[Requested]; ok && last.After(req) {
+			d := last.Sub(req).Milliseconds()
+			p.DurationMS = &d
+		}
+		s.Phases[name] = p
+	}
+	r.log(map[string]any{
+		"event":      EventSummary,
+		"started_at": s.StartedAt.Format(time.RFC3339Nano),
+		"total_ms":   s.TotalMS,
+		"phases":     s.Phases,
+		"order":      s.Order,
+	})
+	return s
+}
+
+// Map Summary 轉成 map（Pulumi stack output 只接受基本型別）
+func (s Summary) Map() map[string]any {
+	phases := make(map[string]any, len(s.Phases))
+	for name, p := range s.Phases {
+		m := map[string]any{}
+		for k, v := range map[string]*int64{
+			"requested_ms": p.RequestedMS,
+			"created_ms":   p.CreatedMS,
+			"ready_ms":     p.ReadyMS,
+			"duration_ms":  p.DurationMS,
+		} {
+			if v != nil {
+				m[k] = float64(*v)
+			}
+		}
+		phases[name] = m
+	}
+	order := make([]any, len(s.Order))
+	for i, name := range s.Order {
+		order[i] = name
+	}
+	return map[string]any{
+		"started_at": s.StartedAt.Format(time.RFC3339Nano),
+		"total_ms":   float64(s.TotalMS),
+		"phases":     phases,
+		"order":      order,
+	}
+}
+
+// log 寫一行 JSON（呼叫端持有 mu）
+func (r *Recorder) log(line map[string]any) {
+	if r.out == nil {
+		return
+	}
+	for k, v := range r.fields {
+		line[k] = v
+	}
+	raw, err := json.Marshal(line)
+	if err != nil {
+		return
+	}
+	r.out.Write(append(raw, '\n'))
+}
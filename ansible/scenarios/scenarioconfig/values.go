@@ -0,0 +1,77 @@
+package scenarioconfig
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Values 驗證通過的設定值。getter 的 key 必須在 Schema 中宣告且型別相符，
+// 否則視為程式錯誤而 panic（使用者輸入的錯誤已在 Parse 時回報）。
+type Values struct {
+	schema *Schema
+	raw    map[string]string
+	source map[string]string // "additional" / "env XXX" / ""（預設值）
+}
+
+func (v *Values) lookup(name string, kinds ...Kind) string {
+	k, ok := v.schema.key(name)
+	if !ok {
+		panic(fmt.Sprintf("scenarioconfig: key %q is not declared in the %s schema", name, v.schema.Scenario))
+	}
+	for _, want := range kinds {
+		if k.Kind == want {
+			return v.raw[name]
+		}
+	}
+	panic(fmt.Sprintf("scenarioconfig: key %q is %s, not %v", name, k.Kind, kinds))
+}
+
+// String 回傳字串值（未設定且無預設值時為空字串）
+func (v *Values) String(name string) string {
+	return v.lookup(name, String, "")
+}
+
+// Int 回傳整數值（未設定且無預設值時為 0）
+func (v *Values) Int(name string) int {
+	raw := v.lookup(name, Int)
+	if raw == "" {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(raw))
+	return n
+}
+
+// Bool 回傳布林值（未設定且無預設值時為 false）
+func (v *Values) Bool(name string) bool {
+	raw := v.lookup(name, Bool)
+	if raw == "" {
+		return false
+	}
+	b, _ := strconv.ParseBool(strings.TrimSpace(raw))
+	return b
+}
+
+// Duration 回傳時間長度（未設定且無預設值時為 0）
+func (v *Values) Duration(name string) time.Duration {
+	raw := v.lookup(name, Duration)
+	if raw == "" {
+		return 0
+	}
+	d, _ := parseDuration(raw)
+	return d
+}
+
+// List 回傳逗號分隔清單（忽略空白項目）
+func (v *Values) List(name string) []string {
+	return SplitList(v.lookup(name, List))
+}
+
+// IsSet 回傳 key 是否由 additional 或環境變數明確設定（而非預設值）
+func (v *Values) IsSet(name string) bool {
+	if _, ok := v.schema.key(name); !ok {
+		panic(fmt.Sprintf("scenarioconfig: key %q is not declared in the %s schema", name, v.schema.Scenario))
+	}
+	return v.source[name] != ""
+}
@@ -0,0 +1,164 @@
+package scenarioplan
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// plan 子命令的參數：
+//
+//	-identity      模擬的玩家 identity（預設 plan）
+//	-additional    additional 檔案（JSON / YAML）；有 additional key 時取其內容，可直接指定 challenge.yml
+//	-set key=value 覆蓋單一 key（可重複，優先於 -additional）
+//	-now           模擬的部署時間（RFC 3339，預設 2000-01-01T00:00:00Z），讓 ownership 時間戳與帳密可重現
+//
+// 環境變數 fallback（CHALLENGE_* 等全域預設）與實際部署相同；OpenStack / Kubernetes 連線設定不需要。
+
+// DefaultIdentity 未指定 -identity 時使用的 identity
+const DefaultIdentity = "plan"
+
+// DefaultNow 未指定 -now 時的部署時間
+var DefaultNow = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Input plan 的輸入
+type Input struct {
+	Identity   string
+	Additional map[string]string
+	Now        time.Time
+}
+
+// Command 實作 plan 子命令：解析參數、以 mock runtime 執行 body、把 Plan 以 JSON 寫到 stdout，回傳 exit code。
+// body 執行期間 scenario 寫到 stdout 的訊息（WARNING 等）改寫到 stderr，stdout 只有 JSON。
+func Command(scenario, version string, args []string, mocks Mocks, body func(in Input) Body) int {
+	in, err := parseArgs(scenario, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "plan: %v\n", err)
+		return 2
+	}
+
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	plan, err := Run(scenario, mocks, body(in))
+	os.Stdout = stdout
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "plan: %v\n", err)
+		return 1
+	}
+	plan.Scenario, plan.Version = scenario, version
+	plan.Identity, plan.Additional = in.Identity, in.Additional
+	if err := Write(os.Stdout, plan); err != nil {
+		fmt.Fprintf(os.Stderr, "plan: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// Write 以縮排 JSON 輸出 Plan（map key 排序，輸出可直接 diff）
+func Write(w io.Writer, plan *Plan) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(plan)
+}
+
+// setFlags -set 的值（key=value，可重複）
+type setFlags map[string]string
+
+func (s setFlags) String() string {
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, k+"="+s[k])
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+func (s setFlags) Set(v string) error {
+	k, val, ok := strings.Cut(v, "=")
+	if !ok || strings.TrimSpace(k) == "" {
+		return fmt.Errorf("want key=value, got %q", v)
+	}
+	s[strings.TrimSpace(k)] = val
+	return nil
+}
+
+func parseArgs(scenario string, args []string) (Input, error) {
+	fs := flag.NewFlagSet(scenario+" plan", flag.ContinueOnError)
+	identity := fs.String("identity", DefaultIdentity, "player identity")
+	file := fs.String("additional", "", "additional file (JSON / YAML, or a challenge.yml)")
+	now := fs.String("now", DefaultNow.Format(time.RFC3339), "deployment time (RFC 3339)")
+	set := setFlags{}
+	fs.Var(set, "set", "additional key=value (repeatable, overrides -additional)")
+	if err := fs.Parse(args); err != nil {
+		return Input{}, err
+	}
+	if fs.NArg() > 0 {
+		return Input{}, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	in := Input{Identity: *identity, Additional: map[string]string{}}
+	t, err := time.Parse(time.RFC3339, *now)
+	if err != nil {
+		return Input{}, fmt.Errorf("-now: %w", err)
+	}
+	in.Now = t
+	if *file != "" {
+		if in.Additional, err = ReadAdditional(*file); err != nil {
+			return Input{}, err
+		}
+	}
+	for k, v := range set {
+		in.Additional[k] = v
+	}
+	return in, nil
+}
+
+// ReadAdditional 讀取 additional 檔案（JSON 是 YAML 的子集，一律以 YAML 解析）。
+// 文件有 additional key 時（challenge.yml）取其內容，否則整份文件即 additional；
+// 非字串的值（port: 22）轉成字串，與 CTFd 送給 chall-manager 的格式相同。
+func ReadAdditional(path string) (map[string]string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read additional: %w", err)
+	}
+	var doc map[string]any
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("parse additional %s: %w", path, err)
+	}
+	if nested, ok := doc["additional"]; ok {
+		m, ok := nested.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("parse additional %s: additional is not a mapping", path)
+		}
+		doc = m
+	}
+	return Stringify(doc), nil
+}
+
+// Stringify 把 YAML 值轉成 additional 的字串值（null 為空字串）
+func Stringify(m map[string]any) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		switch v := v.(type) {
+		case nil:
+			out[k] = ""
+		case string:
+			out[k] = v
+		default:
+			out[k] = fmt.Sprint(v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,12 @@
+module github.com/ctferio/scenarios/scenarioplan
+
+go 1.25
+
+require (
+	// mock runtime（pulumi.WithMocks）：離線執行 scenario，不連線 Pulumi engine 與雲端
+	github.com/pulumi/pulumi/sdk/v3 v3.219.0
+	// yaml.v3：讀取 challenge.yml / additional 檔案
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+// 執行 go mod tidy 自動補全間接依賴
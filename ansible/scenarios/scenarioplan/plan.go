@@ -0,0 +1,254 @@
+// Package scenarioplan 實作 scenario binary 的 plan 子命令：以 Pulumi mock runtime 離線執行 scenario，
+// 不連線 Pulumi engine 與雲端、不建立任何資源，以 JSON 輸出宣告的資源（type / name / inputs）、
+// 產生的 user-data / pod spec 與 stack outputs（connection_info、flag 等）。
+//
+// 用途：
+//   - 出題者在本機確認 challenge.yml 的 additional 會產生什麼，不需要 OpenStack / k3s 帳號
+//   - 以同一組輸入比較兩個 scenario 版本的輸出（diff 兩份 plan）
+//
+// 執行方式等同 preview（ctx.DryRun() 為 true）：scenario 中只在實際部署時才做的步驟（開機重試、建立監看）不會執行。
+// provider 產生的屬性（ID、IP、NodePort 等）由 mock 依資源名稱產生固定值，同一組輸入的輸出完全相同；
+// scenario 另外需要的屬性由 Mocks.Outputs 補上。
+package scenarioplan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Unknown 無法離線得知的值（provider 產生且 mock 未提供的屬性，或依賴它的 output）
+const Unknown = "<unknown>"
+
+// Stack plan 使用的 stack 名稱（出現在 URN 中）
+const Stack = "plan"
+
+// Resource 一個宣告的資源
+type Resource struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	ID   string `json:"id"`
+	// Provider 明確指定的 provider 資源名稱（使用預設 provider 時為空）
+	Provider string `json:"provider,omitempty"`
+	// Import 以 pulumi.Import 接管的既有資源 ID
+	Import string         `json:"import,omitempty"`
+	Inputs map[string]any `json:"inputs"`
+}
+
+// Invoke 一次 provider function 呼叫（data source）
+type Invoke struct {
+	Token string         `json:"token"`
+	Args  map[string]any `json:"args"`
+}
+
+// Plan plan 子命令的輸出
+type Plan struct {
+	Scenario   string            `json:"scenario"`
+	Version    string            `json:"version"`
+	Identity   string            `json:"identity"`
+	Additional map[string]string `json:"additional"`
+	// Resources 依名稱排序（資源註冊是並行的，宣告順序不固定）
+	Resources []Resource `json:"resources"`
+	Invokes   []Invoke   `json:"invokes,omitempty"`
+	// Rendered scenario 產生的主要產物（cloud-init user-data、pod spec），由 Mocks.Render 從資源取出
+	Rendered map[string]any `json:"rendered,omitempty"`
+	// Outputs stack outputs 與 sdk.Response（connection_info、flag）
+	Outputs map[string]any `json:"outputs"`
+}
+
+// Mocks scenario 提供的 mock 行為
+type Mocks struct {
+	// Outputs 回傳資源建立後 provider 才會產生、scenario 又會讀取的屬性（例如 port 的 allFixedIps），
+	// 覆蓋在 inputs 上作為資源 state；nil 或回傳 nil 表示 state 即 inputs
+	Outputs func(r Resource) map[string]any
+	// Render 從資源取出要列在 rendered 的產物（key → 值）；nil 表示不輸出 rendered
+	Render func(r Resource) map[string]any
+}
+
+// Body 在 mock runtime 中執行 scenario，回傳 stack exports 以外要列出的 output（sdk.Response 的欄位）
+type Body func(ctx *pulumi.Context) (map[string]pulumi.Output, error)
+
+// monitor 實作 pulumi.MockResourceMonitor，記錄所有資源與 invoke
+type monitor struct {
+	mocks Mocks
+
+	mu        sync.Mutex
+	resources []Resource
+	invokes   []Invoke
+}
+
+func (m *monitor) NewResource(args pulumi.MockResourceArgs) (string, resource.PropertyMap, error) {
+	r := Resource{
+		Type:     args.TypeToken,
+		Name:     args.Name,
+		ID:       args.ID,
+		Provider: providerName(args.Provider),
+		Import:   args.ID,
+		Inputs:   plainMap(args.Inputs),
+	}
+	if r.ID == "" {
+		r.ID = args.Name + "-id"
+	}
+	state := args.Inputs.Copy()
+	for k, v := range defaultOutputs(r) {
+		state[resource.PropertyKey(k)] = resource.NewPropertyValue(v)
+	}
+	if m.mocks.Outputs != nil {
+		for k, v := range m.mocks.Outputs(r) {
+			state[resource.PropertyKey(k)] = resource.NewPropertyValue(v)
+		}
+	}
+
+	m.mu.Lock()
+	m.resources = append(m.resources, r)
+	m.mu.Unlock()
+	return r.ID, state, nil
+}
+
+// Call 對應 provider function（Invoke）：回傳 args，離線無法查詢
+func (m *monitor) Call(args pulumi.MockCallArgs) (resource.PropertyMap, error) {
+	m.mu.Lock()
+	m.invokes = append(m.invokes, Invoke{Token: args.Token, Args: plainMap(args.Args)})
+	m.mu.Unlock()
+	return args.Args, nil
+}
+
+// defaultOutputs 兩個 scenario 共用的 provider 屬性
+func defaultOutputs(r Resource) map[string]any {
+	switch r.Type {
+	case "random:index/randomId:RandomId":
+		// flag_strategy=random 的 nonce：以名稱產生固定值，plan 可重現
+		n := 8
+		if v, ok := r.Inputs["byteLength"].(float64); ok && v > 0 {
+			n = int(v)
+		}
+		sum := sha256.Sum256([]byte(r.Name))
+		n = min(n, len(sum))
+		return map[string]any{"hex": hex.EncodeToString(sum[:n])}
+	}
+	return nil
+}
+
+// providerName 由 provider reference（<urn>::<id>）取出 provider 資源名稱
+func providerName(ref string) string {
+	if ref == "" {
+		return ""
+	}
+	parts := strings.Split(ref, "::")
+	if len(parts) >= 4 {
+		return parts[3]
+	}
+	return ref
+}
+
+// Run 以 mock runtime 執行 body，回傳 Plan（Scenario / Version / Identity / Additional 由呼叫端填入）
+func Run(project string, mocks Mocks, body Body) (*Plan, error) {
+	// plan 視為 preview：scenario 依 ctx.DryRun() 略過只在實際部署時執行的步驟
+	if err := os.Setenv(pulumi.EnvDryRun, "true"); err != nil {
+		return nil, err
+	}
+	mon := &monitor{mocks: mocks}
+
+	var mu sync.Mutex
+	outputs := map[string]any{}
+	collect := func(name string, o pulumi.Output) {
+		if o == nil {
+			return
+		}
+		mu.Lock()
+		outputs[name] = Unknown
+		mu.Unlock()
+		// unknown 的 output 不會執行 apply，保留 Unknown
+		o.ApplyT(func(v any) any {
+			mu.Lock()
+			outputs[name] = v
+			mu.Unlock()
+			return v
+		})
+	}
+
+	err := pulumi.RunErr(func(ctx *pulumi.Context) error {
+		extra, err := body(ctx)
+		if err != nil {
+			return err
+		}
+		for name, o := range ctx.GetCurrentExportMap() {
+			collect(name, pulumi.ToOutput(o))
+		}
+		for name, o := range extra {
+			collect(name, o)
+		}
+		return nil
+	}, pulumi.WithMocks(project, Stack, mon))
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{Resources: mon.resources, Invokes: mon.invokes, Outputs: outputs}
+	sort.Slice(plan.Resources, func(i, j int) bool {
+		a, b := plan.Resources[i], plan.Resources[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Type < b.Type
+	})
+	if mocks.Render != nil {
+		for _, r := range plan.Resources {
+			for k, v := range mocks.Render(r) {
+				if plan.Rendered == nil {
+					plan.Rendered = map[string]any{}
+				}
+				if _, dup := plan.Rendered[k]; dup {
+					return nil, fmt.Errorf("rendered key %q produced by more than one resource", k)
+				}
+				plan.Rendered[k] = v
+			}
+		}
+	}
+	return plan, nil
+}
+
+// plainMap 把 PropertyMap 轉成可 JSON 輸出的值：secret 顯示原值，computed 顯示 Unknown
+func plainMap(m resource.PropertyMap) map[string]any {
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[string(k)] = plain(v)
+	}
+	return out
+}
+
+func plain(v resource.PropertyValue) any {
+	switch {
+	case v.IsComputed():
+		return Unknown
+	case v.IsOutput():
+		o := v.OutputValue()
+		if !o.Known {
+			return Unknown
+		}
+		return plain(o.Element)
+	case v.IsSecret():
+		return plain(v.SecretValue().Element)
+	case v.IsObject():
+		return plainMap(v.ObjectValue())
+	case v.IsArray():
+		arr := v.ArrayValue()
+		out := make([]any, len(arr))
+		for i, e := range arr {
+			out[i] = plain(e)
+		}
+		return out
+	case v.IsResourceReference():
+		return string(v.ResourceReferenceValue().URN)
+	case v.IsAsset(), v.IsArchive():
+		return "<asset>"
+	}
+	return v.V
+}
@@ -0,0 +1,266 @@
+// Package scenariotrace 把一次 scenario 部署輸出為一條 OpenTelemetry trace（OTLP），
+// 集中查看某位玩家的開機為什麼花了 60 秒：設定解析、每個資源、readiness 輪詢各是一個 span。
+//
+//	<scenario> deploy        root span：ctf.challenge_id、ctf.identity_hash、ctf.scenario、ctf.scenario_version
+//	├── config               additional 解析與驗證
+//	├── <phase>              scenarioconfig/timing 的每個階段（port、instance、pod…），
+//	│                        從第一個時間點開始，created / ready 為 span event
+//	│   └── readiness.<check>  每一次 readiness 檢查（Context 取得階段的 context 後以 Step 建立）
+//	└── …
+//
+// 只以標準環境變數設定，沒有設定 endpoint 時不做任何事（Start 回傳 nil，所有方法接受 nil）：
+//
+//	OTEL_EXPORTER_OTLP_ENDPOINT / OTEL_EXPORTER_OTLP_TRACES_ENDPOINT   collector 位址（設定後才啟用）
+//	OTEL_EXPORTER_OTLP_PROTOCOL / OTEL_EXPORTER_OTLP_TRACES_PROTOCOL   http/protobuf（預設）或 grpc
+//	OTEL_EXPORTER_OTLP_HEADERS、OTEL_EXPORTER_OTLP_INSECURE 等         由 OTLP exporter 讀取
+//	OTEL_SERVICE_NAME / OTEL_RESOURCE_ATTRIBUTES                       覆蓋預設的 service.name（scenario 名稱）
+//	OTEL_TRACES_SAMPLER / OTEL_TRACES_SAMPLER_ARG                      取樣（預設全部記錄）
+//	OTEL_SDK_DISABLED=true 或 OTEL_TRACES_EXPORTER=none                 停用
+//
+// identity 只以 hash 出現（與 ownership 的 ctf-identity 相同），不直接暴露 identity。
+// 套件不依賴 Pulumi：何時結束由 scenario 決定（見各 scenario 的 trace.go）。
+package scenariotrace
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation tracer 名稱
+const instrumentation = "github.com/ctferio/scenarios/scenariotrace"
+
+// FlushTimeout Finish 等待 exporter 送出剩餘 span 的上限（collector 無法連線時最多延後部署這麼久）
+const FlushTimeout = 5 * time.Second
+
+// span attribute
+const (
+	AttrScenario        = "ctf.scenario"
+	AttrScenarioVersion = "ctf.scenario_version"
+	AttrChallengeID     = "ctf.challenge_id"
+	AttrIdentityHash    = "ctf.identity_hash"
+	AttrPhase           = "ctf.phase"
+)
+
+// Enabled 環境變數是否啟用 trace（設定了 OTLP endpoint，且沒有停用）
+func Enabled() bool {
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("OTEL_SDK_DISABLED")), "true") {
+		return false
+	}
+	if e := strings.TrimSpace(os.Getenv("OTEL_TRACES_EXPORTER")); e != "" && e != "otlp" {
+		return false
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != ""
+}
+
+// Deployment 一次部署的 trace，可在多個 goroutine（Pulumi apply）中同時使用
+type Deployment struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+	ctx      context.Context // 帶 root span 的 context
+	root     trace.Span
+
+	mu       sync.Mutex
+	phases   map[string]*phase
+	finished bool
+}
+
+// phase 一個階段的 span
+type phase struct {
+	span trace.Span
+	// last 最後一個時間點；只有 requested 時 span 結束於 Finish
+	last     time.Time
+	complete bool
+}
+
+// Start 建立 exporter 並開始 root span。沒有啟用時回傳 nil, nil；
+// exporter 設定錯誤時回傳 error（呼叫端應只 log，不影響部署）。
+func Start(ctx context.Context, scenario, version, identityHash string) (*Deployment, error) {
+	if !Enabled() {
+		return nil, nil
+	}
+	exp, err := newExporter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// WithFromEnv 在後：OTEL_SERVICE_NAME / OTEL_RESOURCE_ATTRIBUTES 優先
+	res, err := resource.New(ctx,
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(
+			attribute.String("service.name", scenario),
+			attribute.String("service.version", version),
+		),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("otel resource: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	tracer := provider.Tracer(instrumentation)
+	rootCtx, root := tracer.Start(ctx, scenario+" deploy", trace.WithAttributes(
+		attribute.String(AttrScenario, scenario),
+		attribute.String(AttrScenarioVersion, version),
+		attribute.String(AttrIdentityHash, identityHash),
+	))
+	return &Deployment{
+		provider: provider,
+		tracer:   tracer,
+		ctx:      rootCtx,
+		root:     root,
+		phases:   map[string]*phase{},
+	}, nil
+}
+
+// protocol OTLP 協定（traces 專用的設定優先，預設 http/protobuf）
+func protocol() string {
+	for _, k := range []string{"OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "OTEL_EXPORTER_OTLP_PROTOCOL"} {
+		if v := strings.TrimSpace(os.Getenv(k)); v != "" {
+			return v
+		}
+	}
+	return "http/protobuf"
+}
+
+func newExporter(ctx context.Context) (sdktrace.SpanExporter, error) {
+	switch p := protocol(); p {
+	case "grpc":
+		return otlptracegrpc.New(ctx)
+	case "http/protobuf":
+		return otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %q (want http/protobuf or grpc)", p)
+	}
+}
+
+// TraceID root span 的 trace ID（hex），寫進 log 方便對照；沒有啟用時為空字串
+func (d *Deployment) TraceID() string {
+	if d == nil {
+		return ""
+	}
+	return d.root.SpanContext().TraceID().String()
+}
+
+// SetChallenge 設定 root span 的 ctf.challenge_id（設定解析後才知道）
+func (d *Deployment) SetChallenge(challengeID string) {
+	if d == nil {
+		return
+	}
+	d.root.SetAttributes(attribute.String(AttrChallengeID, challengeID))
+}
+
+// Step 開始 root span 下的子 span（例如 config），回傳結束的函式（err 不為 nil 時標記為錯誤）
+func (d *Deployment) Step(name string) func(error) {
+	if d == nil {
+		return func(error) {}
+	}
+	return Step(d.ctx, name)
+}
+
+// Observe 記錄階段的時間點（簽名與 timing.Recorder.Observe 相同）：
+// 第一個時間點開始階段的 span，其後的時間點為 span event。
+func (d *Deployment) Observe(name, point string, at time.Time) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.finished {
+		return
+	}
+	p, ok := d.phases[name]
+	if !ok {
+		_, span := d.tracer.Start(d.ctx, name,
+			trace.WithTimestamp(at),
+			trace.WithAttributes(attribute.String(AttrPhase, name)))
+		p = &phase{span: span}
+		d.phases[name] = p
+	}
+	p.span.AddEvent(point, trace.WithTimestamp(at))
+	if at.After(p.last) {
+		p.last = at
+	}
+	// requested（timing.Requested）之外的時間點表示階段已有結果
+	if point != "requested" {
+		p.complete = true
+	}
+}
+
+// Context 回傳帶有階段 span 的 ctx（階段尚未開始時為 root span），供 Step 建立子 span
+func (d *Deployment) Context(ctx context.Context, name string) context.Context {
+	if d == nil {
+		return ctx
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if p, ok := d.phases[name]; ok {
+		return trace.ContextWithSpan(ctx, p.span)
+	}
+	return trace.ContextWithSpan(ctx, d.root)
+}
+
+// Finish 結束所有 span 並送出（只有第一次呼叫有效）。
+// 有結果的階段結束於最後一個時間點；只有 requested 的階段結束於現在，err 不為 nil 時標記為錯誤。
+func (d *Deployment) Finish(err error) error {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	if d.finished {
+		d.mu.Unlock()
+		return nil
+	}
+	d.finished = true
+	now := time.Now()
+	for _, p := range d.phases {
+		end := p.last
+		if !p.complete {
+			end = now
+			if err != nil {
+				p.span.SetStatus(codes.Error, "not completed when the deployment failed")
+			}
+		}
+		p.span.End(trace.WithTimestamp(end))
+	}
+	d.mu.Unlock()
+
+	if err != nil {
+		d.root.RecordError(err)
+		d.root.SetStatus(codes.Error, err.Error())
+	}
+	d.root.End(trace.WithTimestamp(now))
+
+	ctx, cancel := context.WithTimeout(context.Background(), FlushTimeout)
+	defer cancel()
+	if err := d.provider.Shutdown(ctx); err != nil {
+		return fmt.Errorf("flush traces: %w", err)
+	}
+	return nil
+}
+
+// Step 開始 ctx 中 span 的子 span，回傳結束的函式（err 不為 nil 時標記為錯誤）。
+// ctx 沒有記錄中的 span（未啟用 trace）時不做任何事。
+func Step(ctx context.Context, name string) func(error) {
+	parent := trace.SpanFromContext(ctx)
+	if !parent.IsRecording() {
+		return func(error) {}
+	}
+	_, span := parent.TracerProvider().Tracer(instrumentation).Start(ctx, name)
+	return func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
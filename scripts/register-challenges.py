@@ -16,6 +16,9 @@ Challenge as Code — CTFd 題目自動註冊
   # 強制更新（即使已存在也 PATCH）
   python3 scripts/register-challenges.py --force
 
+additional 會以 .scenario-schemas/<scenario>.json 驗證（Ansible 部署時由 scenario binary
+的 schema 子命令產生並取回，與部署中的 scenario 版本一致）；檔案不存在時跳過驗證。
+
 環境變數：
   CTFD_URL    CTFd 位址（如 http://<CTFD_FLOATING_IP>:8000）
   CTFD_TOKEN  CTFd API Token（Settings > Access Tokens）
@@ -25,6 +28,7 @@ import argparse
 import glob
 import json
 import os
+import re
 import sys
 from pathlib import Path
 
@@ -42,6 +46,7 @@ PROJECT_ROOT = Path(__file__).resolve().parent.parent
 CHALLENGES_DIR = PROJECT_ROOT / "challenges"
 DEFAULTS_FILE = PROJECT_ROOT / "challenge_defaults.yml"
 GENERATED_IDS_FILE = PROJECT_ROOT / "ansible" / "group_vars" / "all" / "challenge_ids.yml"
+SCHEMAS_DIR = PROJECT_ROOT / ".scenario-schemas"
 
 
 def load_env():
@@ -118,9 +123,66 @@ def load_challenge(path):
             else:
                 challenge[k] = v
 
+    # 題目目錄名稱作為 challenge_id（scenario 用於跨 instance 共用資源的命名）
+    challenge.setdefault("slug", challenge_dir.name)
+
     return challenge
 
 
+# ── additional schema 驗證 ──────────────────────────────────
+
+class AdditionalSchemaError(ValueError):
+    """additional 不符合 scenario 的 JSON Schema"""
+
+    def __init__(self, scenario_type, version, problems):
+        self.problems = problems
+        super().__init__(
+            f"additional 不符合 {scenario_type} schema（version {version}）：\n        - "
+            + "\n        - ".join(problems)
+        )
+
+
+def load_schemas(schema_dir):
+    """載入 <schema_dir>/<scenario>.json（scenario binary 的 schema 子命令輸出）"""
+    schemas = {}
+    for f in sorted(Path(schema_dir).glob("*.json")):
+        with open(f) as fp:
+            schemas[f.stem] = json.load(fp)
+    return schemas
+
+
+def validate_additional(additional, schema):
+    """依 JSON Schema 檢查 additional，回傳所有問題（空字串視為未設定，與 scenario 相同）"""
+    problems = []
+    props = schema.get("properties", {})
+    for key in schema.get("required", []):
+        if not additional.get(key):
+            problems.append(f"{key}: required")
+    for key, value in sorted(additional.items()):
+        prop = props.get(key)
+        if prop is None:
+            if schema.get("additionalProperties") is False:
+                problems.append(f"unknown key {key!r}")
+            continue
+        if value == "":
+            continue
+        if "enum" in prop and value not in prop["enum"]:
+            problems.append(f"{key}: {value!r} is not one of {', '.join(prop['enum'])}")
+        elif "pattern" in prop and not re.search(prop["pattern"], value):
+            if "x-enum" in prop:
+                want = "comma-separated list of " + ", ".join(prop["x-enum"])
+            else:
+                want = prop.get("x-kind", "string")
+            problems.append(f"{key}: {value!r} is not a valid {want}")
+        elif prop.get("x-kind") == "int":
+            n = int(value)
+            if "x-minimum" in prop and n < prop["x-minimum"]:
+                problems.append(f"{key}: {n} is less than {prop['x-minimum']}")
+            if "x-maximum" in prop and n > prop["x-maximum"]:
+                problems.append(f"{key}: {n} is greater than {prop['x-maximum']}")
+    return problems
+
+
 def expand_scenario(scenario, defaults):
     """展開 scenario 短名稱為完整 OCI reference"""
     # 如果已經包含 / 或 : 就視為完整 reference
@@ -146,8 +208,8 @@ def merge_additional(scenario_type, challenge_additional, defaults):
     return merged
 
 
-def build_payload(challenge, defaults):
-    """將 challenge.yml 轉換為 CTFd API payload"""
+def build_payload(challenge, defaults, schemas=None):
+    """將 challenge.yml 轉換為 CTFd API payload（schemas 提供時驗證 additional）"""
     scenario_short = challenge.get("scenario", "")
     scenario_full = expand_scenario(scenario_short, defaults)
 
@@ -165,6 +227,9 @@ def build_payload(challenge, defaults):
         challenge.get("additional", {}),
         defaults,
     )
+    # scenario 以 challenge_id 命名題目層級的共用資源（如 server group）
+    if challenge.get("slug"):
+        additional.setdefault("challenge_id", str(challenge["slug"]))
     placeholders = [
         k for k, v in additional.items()
         if isinstance(v, str) and v.startswith("REPLACE_")
@@ -214,6 +279,14 @@ def build_payload(challenge, defaults):
             payload["timeout"] = int(timeout_str[:-1]) * 3600
         else:
             payload["timeout"] = int(timeout_str)
+        # scenario 以 instance_timeout 計算 ownership metadata 的 ctf-expires-at
+        additional.setdefault("instance_timeout", str(payload["timeout"]))
+
+    schema = (schemas or {}).get(scenario_type)
+    if schema is not None:
+        problems = validate_additional(additional, schema)
+        if problems:
+            raise AdditionalSchemaError(scenario_type, schema.get("x-scenario-version", "?"), problems)
 
     return payload
 
@@ -323,12 +396,23 @@ def main():
         action="store_true",
         help="強制更新已存在的題目",
     )
+    parser.add_argument(
+        "--schema-dir",
+        default=str(SCHEMAS_DIR),
+        help="scenario additional JSON Schema 目錄（預設 .scenario-schemas/，由 Ansible 取回）",
+    )
+    parser.add_argument(
+        "--no-schema-check",
+        action="store_true",
+        help="不驗證 additional",
+    )
     args = parser.parse_args()
 
     # 載入設定
     load_env()
     ctfd_url, ctfd_token = load_env()
     defaults = load_defaults()
+    schemas = {} if args.no_schema_check else load_schemas(args.schema_dir)
 
     if not args.dry_run:
         if not ctfd_url:
@@ -373,7 +457,11 @@ def main():
 
         name = challenge.get("name", "???")
         try:
-            payload = build_payload(challenge, defaults)
+            payload = build_payload(challenge, defaults, schemas)
+        except AdditionalSchemaError as e:
+            print(f"  [x] {name}: {e}")
+            errors += 1
+            continue
         except (KeyError, ValueError) as e:
             print(f"  [x] {name}: YAML 格式錯誤 — {e}")
             errors += 1
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// challenge.yml 的載入與 additional 合併，與 scripts/register-challenges.py 相同：
+//
+//	challenge_defaults.yml 的 <scenario> 區塊（+ ansible/group_vars/all/challenge_ids.yml 自動產生的 ID）
+//	→ challenge.yml 的 additional → challenge.local.yml（top-level 覆蓋，additional 逐 key 覆蓋）
+//	→ challenge_id（題目目錄名稱）與 instance_timeout（timeout）未設定時補上
+//
+// 值一律轉成字串（Python str()：布林為 True / False），仍含 REPLACE_ 佔位值時失敗。
+// 兩邊的規則需同步修改。
+
+const (
+	defaultsFile    = "challenge_defaults.yml"
+	generatedIDFile = "ansible/group_vars/all/challenge_ids.yml"
+	challengeFile   = "challenge.yml"
+	localFile       = "challenge.local.yml"
+)
+
+// challenge 合併後的題目設定
+type challenge struct {
+	Dir        string
+	Slug       string
+	Name       string
+	Scenario   string // scenario 名稱（openstack-vm / k8s-pod），由 registry reference 取出
+	Additional map[string]string
+}
+
+// findRoot 由題目目錄往上找含 challenge_defaults.yml 的專案根目錄
+func findRoot(dir string) (string, error) {
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, defaultsFile)); err == nil {
+			return d, nil
+		}
+		if filepath.Dir(d) == d {
+			return "", fmt.Errorf("%s not found in %s or any parent directory", defaultsFile, dir)
+		}
+	}
+}
+
+// readYAML 讀取 YAML mapping；檔案不存在時回傳 nil
+func readYAML(path string) (map[string]any, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return doc, nil
+}
+
+// loadDefaults 載入 challenge_defaults.yml 並合併 tofu 產生的 network / image / SG ID（apply_generated_ids）
+func loadDefaults(root string) (map[string]any, error) {
+	defaults, err := readYAML(filepath.Join(root, defaultsFile))
+	if err != nil {
+		return nil, err
+	}
+	if defaults == nil {
+		defaults = map[string]any{}
+	}
+	generated, err := readYAML(filepath.Join(root, generatedIDFile))
+	if err != nil || generated == nil {
+		return defaults, err
+	}
+	vm, ok := defaults["openstack-vm"].(map[string]any)
+	if !ok {
+		if defaults["openstack-vm"] != nil {
+			return defaults, nil
+		}
+		vm = map[string]any{}
+		defaults["openstack-vm"] = vm
+	}
+	if v := generated["challenge_network_id"]; truthy(v) {
+		vm["network_id"] = pyStr(v)
+	}
+	if v := generated["challenge_image_id"]; truthy(v) {
+		vm["image_id"] = pyStr(v)
+	}
+	if sg, ok := generated["challenge_secgroup_ids"].(map[string]any); ok && truthy(sg["allow_all"]) {
+		vm["security_group_id"] = pyStr(sg["allow_all"])
+	}
+	return defaults, nil
+}
+
+// loadChallenge 載入題目目錄的 challenge.yml（challenge.local.yml 覆蓋）並合併 additional
+func loadChallenge(dir string, defaults map[string]any) (*challenge, error) {
+	doc, err := readYAML(filepath.Join(dir, challengeFile))
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("%s not found in %s", challengeFile, dir)
+	}
+	local, err := readYAML(filepath.Join(dir, localFile))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range local {
+		add, ok := v.(map[string]any)
+		if k != "additional" || !ok {
+			doc[k] = v
+			continue
+		}
+		base, _ := doc["additional"].(map[string]any)
+		if base == nil {
+			base = map[string]any{}
+			doc["additional"] = base
+		}
+		for ak, av := range add {
+			base[ak] = av
+		}
+	}
+
+	c := &challenge{Dir: dir, Slug: filepath.Base(dir), Name: pyStr(doc["name"])}
+	if v, ok := doc["slug"]; ok {
+		c.Slug = pyStr(v)
+	}
+	c.Scenario = scenarioType(pyStr(doc["scenario"]))
+	if c.Scenario == "" {
+		return nil, fmt.Errorf("%s: scenario is not set", filepath.Join(dir, challengeFile))
+	}
+
+	// merge_additional：defaults 的 scenario 區塊，再以 challenge 的 additional 覆蓋
+	c.Additional = map[string]string{}
+	if sd, ok := defaults[c.Scenario].(map[string]any); ok {
+		for k, v := range sd {
+			c.Additional[k] = pyStr(v)
+		}
+	}
+	if add, ok := doc["additional"].(map[string]any); ok {
+		for k, v := range add {
+			c.Additional[k] = pyStr(v)
+		}
+	}
+	if c.Slug != "" {
+		setDefault(c.Additional, "challenge_id", c.Slug)
+	}
+	if v, ok := doc["timeout"]; ok {
+		secs, err := timeoutSeconds(pyStr(v))
+		if err != nil {
+			return nil, fmt.Errorf("%s: timeout: %w", filepath.Join(dir, challengeFile), err)
+		}
+		setDefault(c.Additional, "instance_timeout", strconv.Itoa(secs))
+	}
+	return c, nil
+}
+
+// placeholders 仍為 REPLACE_ 佔位值的 key（tofu output 尚未寫入）
+func (c *challenge) placeholders() []string {
+	var keys []string
+	for k, v := range c.Additional {
+		if strings.HasPrefix(v, "REPLACE_") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// scenarioType 由 scenario 欄位（短名稱或 registry:5000/openstack-vm:latest）取出 scenario 名稱
+func scenarioType(ref string) string {
+	if !strings.ContainsAny(ref, "/:") {
+		return ref
+	}
+	name := ref[strings.LastIndex(ref, "/")+1:]
+	name, _, _ = strings.Cut(name, ":")
+	return name
+}
+
+// timeoutSeconds 解析 timeout（秒數或 s / m / h 結尾）
+func timeoutSeconds(s string) (int, error) {
+	mul := 1
+	switch {
+	case strings.HasSuffix(s, "s"):
+		s = strings.TrimSuffix(s, "s")
+	case strings.HasSuffix(s, "m"):
+		s, mul = strings.TrimSuffix(s, "m"), 60
+	case strings.HasSuffix(s, "h"):
+		s, mul = strings.TrimSuffix(s, "h"), 3600
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	return n * mul, nil
+}
+
+func setDefault(m map[string]string, k, v string) {
+	if _, ok := m[k]; !ok {
+		m[k] = v
+	}
+}
+
+// pyStr 對應 Python 的 str()（register-challenges.py 以 str(v) 轉換 additional 的值）
+func pyStr(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "None"
+	case string:
+		return v
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1e16 {
+			return strconv.FormatFloat(v, 'f', 1, 64)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// truthy 對應 Python 的真值判斷（None / 空字串 / 0 為假）
+func truthy(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case string:
+		return v != ""
+	case bool:
+		return v
+	case int:
+		return v != 0
+	}
+	return true
+}
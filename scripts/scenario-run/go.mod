@@ -0,0 +1,16 @@
+module github.com/ctferio/scripts/scenario-run
+
+go 1.25
+
+require (
+	// naming：stack 名稱中的 identity hash 與 scenario ownership 相同（見 ../../ansible/scenarios/scenarioconfig）
+	github.com/ctferio/scenarios/scenarioconfig v0.0.0
+	// Automation API（auto）：以 local file backend 執行 preview / up / destroy
+	github.com/pulumi/pulumi/sdk/v3 v3.219.0
+	// yaml.v3：讀取 challenge_defaults.yml / challenge.yml / challenge.local.yml
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+replace github.com/ctferio/scenarios/scenarioconfig => ../../ansible/scenarios/scenarioconfig
+
+// 執行 go mod tidy 自動補全間接依賴
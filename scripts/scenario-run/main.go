@@ -0,0 +1,250 @@
+// scenario-run：在本機以 Pulumi Automation API 對單一題目執行 scenario（preview / up / destroy），
+// 不經過 CTFd 與 chall-manager。additional 的合併規則與 register-challenges.py 相同（見 challenge.go）。
+//
+//	make run-scenario CHALLENGE=<name> ACTION=up IDENTITY=team-1
+//	cd scripts/scenario-run && go run . [-identity team-1] [-set key=value] <preview|up|destroy> ../../challenges/<name>
+//
+// 參數：
+//
+//	-identity      模擬的玩家 identity（預設 local-test）；stack 以題目與 identity 區分
+//	-set key=value 覆蓋單一 additional key（可重複，優先於 challenge.yml）
+//	-state         backend 與工作目錄（預設 <repo>/.scenario-run）
+//	-scenarios     scenario 原始碼目錄（預設 <repo>/ansible/scenarios）
+//	-keep-stack    destroy 後保留空的 stack（預設移除）
+//
+// scenario 需要的連線設定（OS_* / OS_CLOUD、KUBECONFIG、K3S_WORKER_IPS 等）與 CHALLENGE_* 全域預設
+// 由環境變數提供，與 chall-manager 部署時相同。需要安裝 pulumi CLI 與 Go。
+//
+// stdout 輸出 connection_info 與 flag（up）；pulumi 進度與各階段耗時寫到 stderr。
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optdestroy"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optpreview"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optup"
+)
+
+const defaultIdentity = "local-test"
+
+// setFlags -set 的值（key=value，可重複）
+type setFlags map[string]string
+
+func (s setFlags) String() string {
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, k+"="+s[k])
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+func (s setFlags) Set(v string) error {
+	k, val, ok := strings.Cut(v, "=")
+	if !ok || strings.TrimSpace(k) == "" {
+		return fmt.Errorf("want key=value, got %q", v)
+	}
+	s[strings.TrimSpace(k)] = val
+	return nil
+}
+
+// ── 階段計時 ──
+
+type phase struct {
+	Name     string
+	Duration time.Duration
+}
+
+type timer struct {
+	phases []phase
+}
+
+// run 執行並記錄一個階段（失敗的階段也會記錄）
+func (t *timer) run(name string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	t.phases = append(t.phases, phase{Name: name, Duration: time.Since(start)})
+	return err
+}
+
+func (t *timer) print(w io.Writer) {
+	if len(t.phases) == 0 {
+		return
+	}
+	var total time.Duration
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "\n==> 各階段耗時")
+	for _, p := range t.phases {
+		fmt.Fprintf(tw, "  %s\t%s\t\n", p.Name, p.Duration.Round(time.Millisecond))
+		total += p.Duration
+	}
+	fmt.Fprintf(tw, "  total\t%s\t\n", total.Round(time.Millisecond))
+	tw.Flush()
+}
+
+// ── 主流程 ──
+
+func main() {
+	os.Exit(runMain(os.Args[1:]))
+}
+
+func runMain(args []string) int {
+	fs := flag.NewFlagSet("scenario-run", flag.ContinueOnError)
+	identity := fs.String("identity", defaultIdentity, "player identity")
+	state := fs.String("state", "", "backend and work directory (default <repo>/.scenario-run)")
+	scenarios := fs.String("scenarios", "", "scenario source directory (default <repo>/ansible/scenarios)")
+	keep := fs.Bool("keep-stack", false, "keep the empty stack after destroy")
+	set := setFlags{}
+	fs.Var(set, "set", "additional key=value (repeatable, overrides challenge.yml)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: scenario-run [flags] <preview|up|destroy> <challenge-dir>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	action, dir := fs.Arg(0), fs.Arg(1)
+	switch action {
+	case "preview", "up", "destroy":
+	default:
+		fmt.Fprintf(os.Stderr, "scenario-run: unknown action %q (want preview, up or destroy)\n", action)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	t := &timer{}
+	defer t.print(os.Stderr)
+	if err := execute(ctx, t, action, dir, *identity, *state, *scenarios, set, *keep); err != nil {
+		fmt.Fprintf(os.Stderr, "scenario-run: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func execute(ctx context.Context, t *timer, action, dir, identity, state, scenarios string, set setFlags, keep bool) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	var c *challenge
+	var root string
+	if err := t.run("load", func() error {
+		if root, err = findRoot(dir); err != nil {
+			return err
+		}
+		defaults, err := loadDefaults(root)
+		if err != nil {
+			return err
+		}
+		if c, err = loadChallenge(dir, defaults); err != nil {
+			return err
+		}
+		for k, v := range set {
+			c.Additional[k] = v
+		}
+		if keys := c.placeholders(); len(keys) > 0 {
+			return fmt.Errorf("%s: additional still has REPLACE_ placeholders: %s", c.Slug, strings.Join(keys, ", "))
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if state == "" {
+		state = filepath.Join(root, ".scenario-run")
+	}
+	if scenarios == "" {
+		scenarios = filepath.Join(root, "ansible", "scenarios")
+	}
+	cfg := stackConfig{
+		ScenarioDir: filepath.Join(scenarios, c.Scenario),
+		StateDir:    state,
+		Stack:       stackName(c.Slug, identity),
+		Identity:    identity,
+		Additional:  c.Additional,
+		Progress:    os.Stderr,
+	}
+	fmt.Fprintf(os.Stderr, "==> %s：scenario=%s stack=%s identity=%s\n", c.Slug, c.Scenario, cfg.Stack, identity)
+
+	var project, bin string
+	if err := t.run("build", func() error {
+		if project, err = projectName(cfg.ScenarioDir); err != nil {
+			return err
+		}
+		bin, err = buildScenario(ctx, cfg, project)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	var st auto.Stack
+	if err := t.run("stack", func() error {
+		st, err = selectStack(ctx, cfg, project, bin)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	switch action {
+	case "preview":
+		return t.run("preview", func() error {
+			if _, err := st.Preview(ctx, optpreview.ProgressStreams(cfg.Progress)); err != nil {
+				return fmt.Errorf("preview: %w", err)
+			}
+			return nil
+		})
+	case "up":
+		var outputs auto.OutputMap
+		if err := t.run("up", func() error {
+			res, err := st.Up(ctx, optup.ProgressStreams(cfg.Progress))
+			if err != nil {
+				return fmt.Errorf("up: %w", err)
+			}
+			outputs = res.Outputs
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, k := range []string{"connection_info", "flag"} {
+			if v, ok := outputs[k]; ok {
+				fmt.Printf("%s: %v\n", k, v.Value)
+			}
+		}
+		return nil
+	default:
+		return t.run("destroy", func() error {
+			if _, err := st.Destroy(ctx, optdestroy.ProgressStreams(cfg.Progress)); err != nil {
+				return fmt.Errorf("destroy: %w", err)
+			}
+			if keep {
+				return nil
+			}
+			if err := st.Workspace().RemoveStack(ctx, cfg.Stack); err != nil {
+				return fmt.Errorf("remove stack %s: %w", cfg.Stack, err)
+			}
+			return nil
+		})
+	}
+}
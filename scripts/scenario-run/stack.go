@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/ctferio/scenarios/scenarioconfig/naming"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+	"gopkg.in/yaml.v3"
+)
+
+// Pulumi Automation API：取代 README 中手動的 pulumi stack init / config set / up / destroy。
+//
+//	<state>/backend              local file backend（PULUMI_BACKEND_URL=file://…），保存所有 stack 的 state
+//	<state>/work/<scenario>      scenario binary 與 LocalWorkspace 的工作目錄（不改動 repo 內的 Pulumi.yaml）
+//	<state>/work/<scenario>/src  編譯用的 scenario 與共用模組複本（不改動 repo 內的 go.mod / go.sum）
+//
+// stack 名稱為 <slug>-<sha256(identity) 前 16 hex>，與 scenario ownership 標記的 identity hash 相同；
+// 同一題同一 identity 重複執行 up 會沿用同一個 stack。
+// PULUMI_CONFIG_PASSPHRASE 未設定時使用空字串（local backend 的 secret 加密，僅供本機測試）。
+
+// localVersion 本機編譯的 scenarioVersion
+const localVersion = "local"
+
+// stackConfig stack 的目標與設定
+type stackConfig struct {
+	ScenarioDir string            // ansible/scenarios/<scenario>
+	StateDir    string            // backend 與工作目錄的根目錄
+	Stack       string            // stack 名稱
+	Identity    string            // <project>:identity
+	Additional  map[string]string // <project>:additional（JSON）
+	Progress    io.Writer         // pulumi 的進度輸出
+}
+
+// stackName 題目與 identity 的 stack 名稱
+func stackName(slug, identity string) string {
+	return slug + "-" + naming.IdentityHash(identity)
+}
+
+// projectName 讀取 scenario 的 Pulumi.yaml（openstack-vm 為 Pulumi.yml）的 name
+func projectName(dir string) (string, error) {
+	for _, f := range []string{"Pulumi.yaml", "Pulumi.yml"} {
+		raw, err := os.ReadFile(filepath.Join(dir, f))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		var p struct {
+			Name string `yaml:"name"`
+		}
+		if err := yaml.Unmarshal(raw, &p); err != nil {
+			return "", fmt.Errorf("parse %s: %w", filepath.Join(dir, f), err)
+		}
+		if p.Name == "" {
+			return "", fmt.Errorf("%s: name is not set", filepath.Join(dir, f))
+		}
+		return p.Name, nil
+	}
+	return "", fmt.Errorf("Pulumi.yaml not found in %s", dir)
+}
+
+// buildScenario 編譯 scenario binary 到工作目錄（與 chall-manager role 相同：go mod tidy 後 go build，
+// 版本標記為 local，ownership metadata 可與 chall-manager 部署的資源區分）。
+// 在 <state>/work/<project>/src 的複本中編譯（見 copySources），tidy 不改動 repo 內的 go.mod / go.sum
+func buildScenario(ctx context.Context, cfg stackConfig, project string) (string, error) {
+	work := filepath.Join(cfg.StateDir, "work", project)
+	if err := os.MkdirAll(work, 0o755); err != nil {
+		return "", err
+	}
+	src, err := copySources(ctx, cfg.ScenarioDir, filepath.Join(work, "src"))
+	if err != nil {
+		return "", err
+	}
+	bin := filepath.Join(work, "main")
+	for _, args := range [][]string{
+		{"mod", "tidy"},
+		{"build", "-ldflags=-X main.scenarioVersion=" + localVersion, "-o", bin, "."},
+	} {
+		cmd := exec.CommandContext(ctx, "go", args...)
+		cmd.Dir = src
+		cmd.Stdout, cmd.Stderr = cfg.Progress, cfg.Progress
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("go %s in %s: %w", args[0], src, err)
+		}
+	}
+	return bin, nil
+}
+
+// copySources 清空 dst 後複製 scenario 目錄，以及 go.mod 以相對路徑 replace 的本機模組（../scenarioconfig 等），
+// 保持相同的相對位置讓 replace 仍然有效。回傳 scenario 複本的路徑
+func copySources(ctx context.Context, dir, dst string) (string, error) {
+	cmd := exec.CommandContext(ctx, "go", "mod", "edit", "-json")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("read %s: %w", filepath.Join(dir, "go.mod"), err)
+	}
+	var mod struct {
+		Replace []struct {
+			New struct{ Path string }
+		}
+	}
+	if err := json.Unmarshal(out, &mod); err != nil {
+		return "", fmt.Errorf("read %s: %w", filepath.Join(dir, "go.mod"), err)
+	}
+
+	root, name := filepath.Dir(dir), filepath.Base(dir)
+	dirs := []string{name}
+	for _, r := range mod.Replace {
+		p := r.New.Path
+		if !strings.HasPrefix(p, "./") && !strings.HasPrefix(p, "../") {
+			continue // module path 或絕對路徑，不需要複製
+		}
+		rel := filepath.Join(name, filepath.FromSlash(p))
+		if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", fmt.Errorf("%s: replace %s points outside %s", filepath.Join(dir, "go.mod"), p, root)
+		}
+		dirs = append(dirs, rel)
+	}
+
+	if err := os.RemoveAll(dst); err != nil {
+		return "", err
+	}
+	for _, d := range dirs {
+		if err := os.CopyFS(filepath.Join(dst, d), os.DirFS(filepath.Join(root, d))); err != nil {
+			return "", fmt.Errorf("copy %s: %w", filepath.Join(root, d), err)
+		}
+	}
+	return filepath.Join(dst, name), nil
+}
+
+// selectStack 建立 LocalWorkspace 並選取（不存在時建立）stack，寫入 identity / additional
+func selectStack(ctx context.Context, cfg stackConfig, project, bin string) (auto.Stack, error) {
+	backend := filepath.Join(cfg.StateDir, "backend")
+	if err := os.MkdirAll(backend, 0o755); err != nil {
+		return auto.Stack{}, err
+	}
+	env := map[string]string{}
+	if _, ok := os.LookupEnv("PULUMI_CONFIG_PASSPHRASE"); !ok {
+		env["PULUMI_CONFIG_PASSPHRASE"] = ""
+	}
+	ws, err := auto.NewLocalWorkspace(ctx,
+		auto.WorkDir(filepath.Dir(bin)),
+		auto.Project(workspace.Project{
+			Name:    tokens.PackageName(project),
+			Runtime: workspace.NewProjectRuntimeInfo("go", map[string]any{"binary": bin}),
+			Backend: &workspace.ProjectBackend{URL: "file://" + backend},
+		}),
+		auto.EnvVars(env),
+	)
+	if err != nil {
+		return auto.Stack{}, fmt.Errorf("workspace: %w", err)
+	}
+	st, err := auto.UpsertStack(ctx, cfg.Stack, ws)
+	if err != nil {
+		return auto.Stack{}, fmt.Errorf("stack %s: %w", cfg.Stack, err)
+	}
+	additional, err := json.Marshal(cfg.Additional)
+	if err != nil {
+		return auto.Stack{}, err
+	}
+	if err := st.SetAllConfig(ctx, auto.ConfigMap{
+		"identity":   {Value: cfg.Identity},
+		"additional": {Value: string(additional)},
+	}); err != nil {
+		return auto.Stack{}, fmt.Errorf("stack %s: set config: %w", cfg.Stack, err)
+	}
+	return st, nil
+}
@@ -9,6 +9,10 @@
 #
 # 用法：./scripts/stress-test.sh [並發數] [challenge_id]
 #   例：./scripts/stress-test.sh 5 8
+#
+# scenario 各階段時間（port / VM / FIP / pod / service / readiness）取自 chall-manager log 中
+# scenario 寫出的 scenario_timing_summary（見 ansible/scenarios/scenarioconfig/timing），
+# 測試期間其他玩家的部署也會被計入。CM_CONTAINER 指定 chall-manager container 名稱（預設 chall-manager）。
 set -euo pipefail
 
 SCRIPT_DIR="$(cd "$(dirname "$0")" && pwd)"
@@ -22,12 +26,14 @@ CHALLENGE_ID="${2:-8}"
 # 需透過 SSH 存取
 CM_HOST="${CM_HOST:?ERROR: CM_HOST not set. Export CM_HOST=<ctfd-floating-ip>}"
 CM_SSH_USER="${CM_SSH_USER:-ubuntu}"
+CM_CONTAINER="${CM_CONTAINER:-chall-manager}"
 
 RESULTS_DIR="$PROJECT_ROOT/stress-test-results"
 mkdir -p "$RESULTS_DIR"
 TIMESTAMP=$(date '+%Y%m%d_%H%M%S')
 RESULT_FILE="$RESULTS_DIR/run_${TIMESTAMP}_c${CONCURRENCY}.csv"
 DESTROY_FILE="$RESULTS_DIR/run_${TIMESTAMP}_c${CONCURRENCY}_destroy.csv"
+TIMINGS_FILE="$RESULTS_DIR/run_${TIMESTAMP}_c${CONCURRENCY}_timings.jsonl"
 LOG_DIR="$RESULTS_DIR/logs_${TIMESTAMP}"
 mkdir -p "$LOG_DIR"
 
@@ -168,6 +174,7 @@ launch_worker() {
 
 # Launch all workers in parallel
 GLOBAL_START=$(date +%s%N)
+LOG_SINCE=$(date -u '+%Y-%m-%dT%H:%M:%SZ')
 echo "[$(date '+%H:%M:%S')] 開始並發啟動..."
 echo ""
 
@@ -393,6 +400,70 @@ print(f"  ║  成功率: 啟動 {len(boot_ok)}/{len(boot_rows)}, 關閉 {len(de
 print("  ╚═══════════════════════════════════════════════════════╝")
 PYEOF
 
+# ── scenario 各階段時間（chall-manager log 的 scenario_timing_summary）──
+echo ""
+echo "  scenario 各階段時間（scenario_timing_summary）:"
+ssh -o StrictHostKeyChecking=no -o ConnectTimeout=10 \
+    "$CM_SSH_USER@$CM_HOST" \
+    "docker logs --since '$LOG_SINCE' \$(docker ps -q --filter name=$CM_CONTAINER | head -1)" \
+    > "$LOG_DIR/chall-manager.log" 2>&1 || true
+
+python3 - "$LOG_DIR/chall-manager.log" "$TIMINGS_FILE" << 'PYEOF'
+import json, re, statistics, sys
+
+log_file, timings_file = sys.argv[1], sys.argv[2]
+
+# scenario 的 stdout 可能被 chall-manager 包在自己的 log 格式中（引號被跳脫），兩種都接受
+summaries = []
+with open(log_file, errors="replace") as f:
+    for line in f:
+        if "scenario_timing_summary" not in line:
+            continue
+        if '\\"event\\"' in line:
+            line = line.encode().decode("unicode_escape")
+        start = line.find('{"')
+        while start >= 0:
+            try:
+                obj, _ = json.JSONDecoder().raw_decode(line[start:])
+            except ValueError:
+                start = line.find('{"', start + 1)
+                continue
+            if obj.get("event") == "scenario_timing_summary":
+                summaries.append(obj)
+            break
+
+with open(timings_file, "w") as f:
+    for s in summaries:
+        f.write(json.dumps(s) + "\n")
+
+if not summaries:
+    print("    （沒有找到 scenario_timing_summary，確認 scenario 版本與 CM_CONTAINER）")
+    sys.exit(0)
+
+# 依第一次出現的順序列出階段：建立耗時（requested → created）與 ready 時間點
+order = []
+for s in summaries:
+    for name in s.get("order", []):
+        if name not in order:
+            order.append(name)
+
+def stat(vals):
+    if not vals:
+        return f"{'-':>8} {'-':>8} {'-':>8}"
+    return f"{statistics.mean(vals)/1000:>7.1f}s {statistics.median(vals)/1000:>7.1f}s {max(vals)/1000:>7.1f}s"
+
+print(f"    部署數: {len(summaries)}    明細: {timings_file}")
+print(f"    {'Phase':<15} {'建立 avg':>8} {'median':>8} {'max':>8}   {'ready@ avg':>8} {'median':>8} {'max':>8}")
+print(f"    {'─'*15} {'─'*8} {'─'*8} {'─'*8}   {'─'*8} {'─'*8} {'─'*8}")
+for name in order:
+    phases = [s["phases"][name] for s in summaries if name in s.get("phases", {})]
+    create = [p["created_ms"] - p["requested_ms"] for p in phases if "created_ms" in p and "requested_ms" in p]
+    ready = [p["ready_ms"] for p in phases if "ready_ms" in p]
+    print(f"    {name:<15} {stat(create)}   {stat(ready)}")
+totals = [s["total_ms"] for s in summaries if "total_ms" in s]
+print(f"    {'total':<15} {stat(totals)}")
+PYEOF
+
 # ── 階段 5：驗證清理 ──────────────────────────────────────────
 echo ""
 echo "==> [5/5] 驗證所有資源已清理..."
@@ -416,4 +487,5 @@ echo ""
 echo "=== 測試完成 ==="
 echo "  啟動結果: $RESULT_FILE"
 echo "  關閉結果: $DESTROY_FILE"
+echo "  階段時間: $TIMINGS_FILE"
 echo "  Logs:     $LOG_DIR/"